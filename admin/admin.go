@@ -0,0 +1,484 @@
+// Package admin provides an HTTP handler exposing JSON endpoints to inspect and purge
+// the entries of a live gocondcache.Cache.
+//
+// The handler serves the following endpoints, relative to where it is mounted:
+//
+//	GET  /entries?prefix=&host=&tag=&q=&limit=  list entries, sorted by key
+//	GET  /entry?key=                            one entry with its response status and headers
+//	POST /purge                                 remove the entries selected by the JSON body
+//	POST /soft-purge                            expire the entries selected by the JSON body
+//	GET  /stats                                 transport hit/miss counters and cache totals
+//
+// Purge requests select entries with a body such as {"key": "GET#https://example.com/"},
+// {"prefix": "GET#https://example.com/api/"}, {"host": "example.com"} or {"tag": "users"}.
+package admin
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+)
+
+// DefaultListLimit is the default maximum number of entries returned by /entries.
+const DefaultListLimit = 1000
+
+const maxRequestBodySize = 1 << 16
+
+// ErrUnauthorized is returned by the authorization helpers when a request is rejected.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Config defines the configuration options of the admin handler.
+type Config struct {
+	// Transport, when set, is the transport whose counters are reported by /stats.
+	Transport *gocondcache.CacheTransport
+
+	// Authorize is called before every request is served. A non-nil error rejects the
+	// request with 403 Forbidden. Defaults to LoopbackOnly.
+	Authorize func(r *http.Request) error
+
+	// ListLimit is the maximum number of entries returned by /entries, which can be
+	// lowered per request with the limit parameter. Defaults to DefaultListLimit.
+	ListLimit int
+}
+
+// Entry describes a cache entry.
+type Entry struct {
+	Key          string     `json:"key"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	Expiration   time.Time  `json:"expiration"`
+	StaleWindow  string     `json:"stale_window,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	Size         int        `json:"size"`
+	Expired      bool       `json:"expired"`
+}
+
+// EntryDetail describes a cache entry along with the response it holds.
+type EntryDetail struct {
+	Entry
+
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+}
+
+// EntryList is the result of /entries.
+type EntryList struct {
+	Entries   []Entry `json:"entries"`
+	Total     int     `json:"total"`
+	Truncated bool    `json:"truncated"`
+}
+
+// PurgeResult is the result of /purge and /soft-purge.
+type PurgeResult struct {
+	Count int `json:"count"`
+}
+
+// Stats is the result of /stats. Transport is omitted when no transport is configured
+// and Cache when the cache cannot be enumerated.
+type Stats struct {
+	Transport *TransportStats `json:"transport,omitempty"`
+	Cache     *CacheStats     `json:"cache,omitempty"`
+}
+
+// TransportStats holds the request counters of a CacheTransport.
+type TransportStats struct {
+	Hits        uint64  `json:"hits"`
+	Misses      uint64  `json:"misses"`
+	Revalidated uint64  `json:"revalidated"`
+	Stale       uint64  `json:"stale"`
+	Bypassed    uint64  `json:"bypassed"`
+	Errors      uint64  `json:"errors"`
+	Refreshes   uint64  `json:"refreshes"`
+	HitRatio    float64 `json:"hit_ratio"`
+}
+
+// CacheStats holds totals over the entries of a cache.
+type CacheStats struct {
+	Entries int `json:"entries"`
+	Fresh   int `json:"fresh"`
+	Expired int `json:"expired"`
+	Size    int `json:"size"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	cache     gocondcache.Cache
+	transport *gocondcache.CacheTransport
+	authorize func(r *http.Request) error
+	listLimit int
+	now       func() time.Time
+	logger    *slog.Logger
+	mux       *http.ServeMux
+}
+
+// NewHandler creates the admin handler over cache. Mount it below a prefix with
+// http.StripPrefix, eg. mux.Handle("/admin/cache/", http.StripPrefix("/admin/cache", h)).
+//
+// If config is nil, the defaults are used.
+// If the 'now' function is nil, time.Now will be used as the default time provider.
+// If the 'logger' is nil, a no-op logger writing to io.Discard will be used.
+func NewHandler(cache gocondcache.Cache, config *Config, now func() time.Time, logger *slog.Logger) http.Handler {
+	nowFunc := now
+	if nowFunc == nil {
+		nowFunc = time.Now
+	}
+
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	c := Config{}
+	if config != nil {
+		c = *config
+	}
+	if c.Authorize == nil {
+		c.Authorize = LoopbackOnly
+	}
+	if c.ListLimit <= 0 {
+		c.ListLimit = DefaultListLimit
+	}
+
+	h := &handler{
+		cache:     cache,
+		transport: c.Transport,
+		authorize: c.Authorize,
+		listLimit: c.ListLimit,
+		now:       nowFunc,
+		logger:    logger,
+		mux:       http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET /entries", h.listEntries)
+	h.mux.HandleFunc("GET /entry", h.getEntry)
+	h.mux.HandleFunc("POST /purge", h.purge)
+	h.mux.HandleFunc("POST /soft-purge", h.softPurge)
+	h.mux.HandleFunc("GET /stats", h.stats)
+
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r); err != nil {
+		h.logger.WarnContext(r.Context(), "admin request rejected", "remote", r.RemoteAddr, "error", err)
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: err.Error()})
+		return
+	}
+
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *handler) listEntries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s := caches.Selector{Prefix: query.Get("prefix"), Host: query.Get("host"), Tag: query.Get("tag")}
+	search := query.Get("q")
+
+	limit := h.listLimit
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, h.listLimit)
+	}
+
+	list := EntryList{Entries: []Entry{}}
+	err := h.each(r, func(k string, v *gocondcache.CacheItem) {
+		if !s.Match(k, v.Tags) || !strings.Contains(k, search) {
+			return
+		}
+		list.Total++
+		list.Entries = append(list.Entries, h.entry(k, v))
+	})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	slices.SortFunc(list.Entries, func(a, b Entry) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	if len(list.Entries) > limit {
+		list.Entries = list.Entries[:limit]
+		list.Truncated = true
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *handler) getEntry(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "key is required"})
+		return
+	}
+
+	item, err := h.cache.Get(r.Context(), key)
+	if err != nil && !errors.Is(err, caches.ErrCacheItemExpired) {
+		h.writeError(w, r, err)
+		return
+	}
+
+	detail := EntryDetail{Entry: h.entry(key, item)}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(item.Response)), nil)
+	if err != nil {
+		h.writeError(w, r, fmt.Errorf("reading cached response: %w", err))
+		return
+	}
+	resp.Body.Close()
+	detail.Status = resp.StatusCode
+	detail.Headers = resp.Header
+
+	writeJSON(w, http.StatusOK, detail)
+}
+
+func (h *handler) purge(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.decodeSelector(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	var count int
+	var err error
+	switch {
+	case s.Key != "":
+		count, err = h.applyKey(r, s.Key, func(k string) error {
+			return gocondcache.Delete(ctx, h.cache, k)
+		})
+	case s.Prefix != "":
+		count, err = gocondcache.PurgePrefix(ctx, h.cache, s.Prefix)
+	case s.Host != "":
+		count, err = gocondcache.PurgeHost(ctx, h.cache, s.Host)
+	case s.Tag != "":
+		count, err = gocondcache.PurgeTag(ctx, h.cache, s.Tag)
+	}
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.logger.InfoContext(ctx, "cache entries purged", "selector", s, "count", count)
+	writeJSON(w, http.StatusOK, PurgeResult{Count: count})
+}
+
+func (h *handler) softPurge(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.decodeSelector(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	softPurge := func(k string) error {
+		return gocondcache.SoftPurge(ctx, h.cache, k, h.now)
+	}
+
+	var count int
+	var err error
+	if s.Key != "" {
+		count, err = h.applyKey(r, s.Key, softPurge)
+	} else {
+		count, err = h.applyMatching(r, s, softPurge)
+	}
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.logger.InfoContext(ctx, "cache entries soft purged", "selector", s, "count", count)
+	writeJSON(w, http.StatusOK, PurgeResult{Count: count})
+}
+
+// decodeSelector reads the selector from the body of r, answering the request with
+// 400 Bad Request if it is invalid.
+func (h *handler) decodeSelector(w http.ResponseWriter, r *http.Request) (caches.Selector, bool) {
+	var s caches.Selector
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestBodySize)).Decode(&s); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request body: " + err.Error()})
+		return s, false
+	}
+	if !s.Single() {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "exactly one of key, prefix, host or tag is required"})
+		return s, false
+	}
+
+	return s, true
+}
+
+// applyKey calls f with k if the cache holds an item under it, fresh or expired, and
+// returns the number of items f was applied to.
+func (h *handler) applyKey(r *http.Request, k string, f func(k string) error) (int, error) {
+	exists, err := gocondcache.Exists(r.Context(), h.cache, k)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	if err = f(k); err != nil {
+		return 0, fmt.Errorf("purging %s: %w", k, err)
+	}
+
+	return 1, nil
+}
+
+// applyMatching calls f with the key of every entry selected by s and returns how many
+// there were.
+func (h *handler) applyMatching(r *http.Request, s caches.Selector, f func(k string) error) (int, error) {
+	// keys are collected first as the cache must not be modified while it is enumerated
+	var keys []string
+	err := h.each(r, func(k string, v *gocondcache.CacheItem) {
+		if s.Match(k, v.Tags) {
+			keys = append(keys, k)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, k := range keys {
+		if err = f(k); err != nil {
+			return i, fmt.Errorf("purging %s: %w", k, err)
+		}
+	}
+
+	return len(keys), nil
+}
+
+func (h *handler) stats(w http.ResponseWriter, r *http.Request) {
+	var stats Stats
+
+	if h.transport != nil {
+		ts := h.transport.Stats()
+		stats.Transport = &TransportStats{
+			Hits:        ts.Hits,
+			Misses:      ts.Misses,
+			Revalidated: ts.Revalidated,
+			Stale:       ts.Stale,
+			Bypassed:    ts.Bypassed,
+			Errors:      ts.Errors,
+			Refreshes:   ts.Refreshes,
+		}
+		// revalidated and stale responses are served from the cache too
+		served := ts.Hits + ts.Revalidated + ts.Stale
+		if total := served + ts.Misses; total > 0 {
+			stats.Transport.HitRatio = float64(served) / float64(total)
+		}
+	}
+
+	cs := CacheStats{}
+	err := h.each(r, func(_ string, v *gocondcache.CacheItem) {
+		cs.Entries++
+		cs.Size += len(v.Response)
+		if h.expired(v) {
+			cs.Expired++
+		} else {
+			cs.Fresh++
+		}
+	})
+	switch {
+	case err == nil:
+		stats.Cache = &cs
+	case !errors.Is(err, caches.ErrNotSupported):
+		h.writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// each calls f for every entry of the cache.
+func (h *handler) each(r *http.Request, f func(k string, v *gocondcache.CacheItem)) error {
+	ranger, ok := h.cache.(gocondcache.Ranger)
+	if !ok {
+		return caches.ErrNotSupported
+	}
+
+	return ranger.Range(r.Context(), func(k string, v *gocondcache.CacheItem) bool {
+		f(k, v)
+		return true
+	})
+}
+
+func (h *handler) entry(k string, v *gocondcache.CacheItem) Entry {
+	e := Entry{
+		Key:          k,
+		ETag:         v.ETAG,
+		LastModified: v.LastModified,
+		Expiration:   v.Expiration,
+		Tags:         v.Tags,
+		Size:         len(v.Response),
+		Expired:      h.expired(v),
+	}
+	if v.StaleWindow > 0 {
+		e.StaleWindow = v.StaleWindow.String()
+	}
+
+	return e
+}
+
+func (h *handler) expired(v *gocondcache.CacheItem) bool {
+	return h.now().UTC().After(v.Expiration)
+}
+
+func (h *handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, caches.ErrNoCacheItem):
+		status = http.StatusNotFound
+	case errors.Is(err, caches.ErrNotSupported):
+		status = http.StatusNotImplemented
+	default:
+		h.logger.ErrorContext(r.Context(), "admin request failed", "path", r.URL.Path, "error", err)
+	}
+
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// LoopbackOnly allows requests coming from a loopback address only.
+func LoopbackOnly(r *http.Request) error {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%w: %s is not a loopback address", ErrUnauthorized, host)
+	}
+
+	return nil
+}
+
+// BearerToken returns an authorization hook that allows requests carrying token in an
+// Authorization: Bearer header.
+func BearerToken(token string) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return fmt.Errorf("%w: missing or invalid bearer token", ErrUnauthorized)
+		}
+
+		return nil
+	}
+}
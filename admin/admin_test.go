@@ -0,0 +1,340 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/admin"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+func testTime() time.Time {
+	return time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func allowAll(*http.Request) error { return nil }
+
+func newTestCache(t *testing.T, now func() time.Time) *local.BasicCache {
+	t.Helper()
+
+	item := func(ttl time.Duration, tags ...string) *gocondcache.CacheItem {
+		return &gocondcache.CacheItem{
+			ETAG:       `"v1"`,
+			Response:   []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\nok"),
+			Expiration: testTime().Add(ttl),
+			Tags:       tags,
+		}
+	}
+
+	cache := local.NewBasicCacheWithTimeFunc(now)
+	gocondcachetest.Seed(t, &cache, map[string]*gocondcache.CacheItem{
+		"GET#https://a.example.com/users/1": item(time.Hour, "users"),
+		"GET#https://a.example.com/users/2": item(-time.Hour, "users"),
+		"GET#https://a.example.com/posts/1": item(time.Hour),
+		"GET#https://b.example.com/":        item(time.Hour),
+	})
+
+	return &cache
+}
+
+func serve(t *testing.T, h http.Handler, method, target, body string, v any) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+	if v != nil {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+
+	return rec.Code
+}
+
+func TestListEntries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		target        string
+		expectedKeys  []string
+		expectedTotal int
+	}{
+		{
+			name:   "all",
+			target: "/entries",
+			expectedKeys: []string{
+				"GET#https://a.example.com/posts/1",
+				"GET#https://a.example.com/users/1",
+				"GET#https://a.example.com/users/2",
+				"GET#https://b.example.com/",
+			},
+			expectedTotal: 4,
+		},
+		{
+			name:          "by tag",
+			target:        "/entries?tag=users",
+			expectedKeys:  []string{"GET#https://a.example.com/users/1", "GET#https://a.example.com/users/2"},
+			expectedTotal: 2,
+		},
+		{
+			name:          "by host and search",
+			target:        "/entries?host=a.example.com&q=posts",
+			expectedKeys:  []string{"GET#https://a.example.com/posts/1"},
+			expectedTotal: 1,
+		},
+		{
+			name:          "limited",
+			target:        "/entries?prefix=GET%23https://a.example.com/&limit=1",
+			expectedKeys:  []string{"GET#https://a.example.com/posts/1"},
+			expectedTotal: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := admin.NewHandler(newTestCache(t, testTime), &admin.Config{Authorize: allowAll}, testTime, nil)
+
+			var list admin.EntryList
+			if code := serve(t, h, http.MethodGet, tt.target, "", &list); code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", code)
+			}
+
+			if list.Total != tt.expectedTotal {
+				t.Errorf("expected total %d, got %d", tt.expectedTotal, list.Total)
+			}
+			if list.Truncated != (tt.expectedTotal > len(tt.expectedKeys)) {
+				t.Errorf("unexpected truncated %v", list.Truncated)
+			}
+			if len(list.Entries) != len(tt.expectedKeys) {
+				t.Fatalf("expected %d entries, got %d", len(tt.expectedKeys), len(list.Entries))
+			}
+			for i, k := range tt.expectedKeys {
+				if list.Entries[i].Key != k {
+					t.Errorf("expected entry %d to be %s, got %s", i, k, list.Entries[i].Key)
+				}
+			}
+		})
+	}
+}
+
+func TestGetEntry(t *testing.T) {
+	t.Parallel()
+
+	h := admin.NewHandler(newTestCache(t, testTime), &admin.Config{Authorize: allowAll}, testTime, nil)
+
+	var detail admin.EntryDetail
+	code := serve(t, h, http.MethodGet, "/entry?key=GET%23https://a.example.com/users/2", "", &detail)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if !detail.Expired || detail.Status != http.StatusOK || detail.Headers.Get("Content-Type") != "text/plain" {
+		t.Errorf("unexpected entry detail %+v", detail)
+	}
+
+	if code = serve(t, h, http.MethodGet, "/entry?key=missing", "", nil); code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a missing entry, got %d", code)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		expectedStatus int
+		expectedCount  int
+		expectedErr    map[string]error
+	}{
+		{
+			name:           "purge by tag",
+			path:           "/purge",
+			body:           `{"tag": "users"}`,
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+			expectedErr: map[string]error{
+				"GET#https://a.example.com/users/1": caches.ErrNoCacheItem,
+				"GET#https://a.example.com/posts/1": nil,
+			},
+		},
+		{
+			name:           "purge by key",
+			path:           "/purge",
+			body:           `{"key": "GET#https://b.example.com/"}`,
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+			expectedErr: map[string]error{
+				"GET#https://b.example.com/":        caches.ErrNoCacheItem,
+				"GET#https://a.example.com/users/1": nil,
+			},
+		},
+		{
+			name:           "purge by missing key",
+			path:           "/purge",
+			body:           `{"key": "GET#https://missing.example.com/"}`,
+			expectedStatus: http.StatusOK,
+			expectedCount:  0,
+			expectedErr: map[string]error{
+				"GET#https://b.example.com/": nil,
+			},
+		},
+		{
+			name:           "soft purge by missing key",
+			path:           "/soft-purge",
+			body:           `{"key": "GET#https://missing.example.com/"}`,
+			expectedStatus: http.StatusOK,
+			expectedCount:  0,
+			expectedErr: map[string]error{
+				"GET#https://missing.example.com/": caches.ErrNoCacheItem,
+			},
+		},
+		{
+			name:           "soft purge by host",
+			path:           "/soft-purge",
+			body:           `{"host": "a.example.com"}`,
+			expectedStatus: http.StatusOK,
+			expectedCount:  3,
+			expectedErr: map[string]error{
+				"GET#https://a.example.com/posts/1": caches.ErrCacheItemExpired,
+				"GET#https://b.example.com/":        nil,
+			},
+		},
+		{
+			name:           "ambiguous selector",
+			path:           "/purge",
+			body:           `{"host": "a.example.com", "tag": "users"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// the clock ticks on every call so that soft purged entries are expired when read back
+			var ticks atomic.Int64
+			cache := newTestCache(t, func() time.Time {
+				return testTime().Add(time.Duration(ticks.Add(1)) * time.Second)
+			})
+			h := admin.NewHandler(cache, &admin.Config{Authorize: allowAll}, testTime, nil)
+
+			var result admin.PurgeResult
+			code := serve(t, h, http.MethodPost, tt.path, tt.body, &result)
+			if code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, code)
+			}
+			if result.Count != tt.expectedCount {
+				t.Errorf("expected count %d, got %d", tt.expectedCount, result.Count)
+			}
+
+			for k, expected := range tt.expectedErr {
+				if _, err := cache.Get(context.Background(), k); !errors.Is(err, expected) {
+					t.Errorf("expected %v getting %s, got %v", expected, k, err)
+				}
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	cache := local.NewBasicCache()
+	transport := gocondcache.New(&cache, nil, nil, nil)(http.DefaultTransport).(*gocondcache.CacheTransport)
+	client := &http.Client{Transport: transport}
+	for range 4 {
+		resp, err := client.Get(origin.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	h := admin.NewHandler(&cache, &admin.Config{Transport: transport, Authorize: allowAll}, nil, nil)
+
+	var stats admin.Stats
+	if code := serve(t, h, http.MethodGet, "/stats", "", &stats); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+
+	if stats.Transport == nil || stats.Transport.Hits != 3 || stats.Transport.Misses != 1 {
+		t.Fatalf("unexpected transport stats %+v", stats.Transport)
+	}
+	if stats.Transport.HitRatio != 0.75 {
+		t.Errorf("expected hit ratio 0.75, got %v", stats.Transport.HitRatio)
+	}
+	if stats.Cache == nil || stats.Cache.Entries != 1 || stats.Cache.Fresh != 1 {
+		t.Errorf("unexpected cache stats %+v", stats.Cache)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		config         *admin.Config
+		remoteAddr     string
+		authorization  string
+		expectedStatus int
+	}{
+		{name: "default rejects remote", config: nil, remoteAddr: "192.0.2.1:1234", expectedStatus: http.StatusForbidden},
+		{name: "default allows loopback", config: nil, remoteAddr: "127.0.0.1:1234", expectedStatus: http.StatusOK},
+		{
+			name:           "bearer token rejected",
+			config:         &admin.Config{Authorize: admin.BearerToken("secret")},
+			remoteAddr:     "127.0.0.1:1234",
+			authorization:  "Bearer wrong",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "bearer token accepted",
+			config:         &admin.Config{Authorize: admin.BearerToken("secret")},
+			remoteAddr:     "192.0.2.1:1234",
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := admin.NewHandler(newTestCache(t, testTime), tt.config, testTime, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package gocondcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgduncan/go-cond-cache/caches"
+)
+
+const (
+	// DefaultFailureThreshold is the default number of consecutive failures that opens the circuit.
+	DefaultFailureThreshold = 5
+	// DefaultCoolDown is the default duration the circuit stays open before a trial call is allowed.
+	DefaultCoolDown = 30 * time.Second
+)
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every call through to the wrapped cache.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every call with caches.ErrCircuitOpen until the cool-down has passed.
+	CircuitOpen
+	// CircuitHalfOpen lets a single trial call through to decide whether to close the circuit again.
+	CircuitHalfOpen
+)
+
+// CircuitBreakerConfig defines the configuration options for a CircuitBreaker.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive backend failures that opens the circuit.
+	FailureThreshold int
+
+	// CoolDown is how long the circuit stays open before a trial call is let through.
+	CoolDown time.Duration
+}
+
+// CircuitBreaker wraps a Cache and stops calling it for a cool-down period once it has
+// failed a number of times in a row. While the circuit is open every call returns
+// caches.ErrCircuitOpen, which the transport handles according to its BackendErrorPolicy.
+//
+// Missing and expired items and unsupported operations are not failures, and neither is
+// a call abandoned because the caller canceled its context.
+type CircuitBreaker struct {
+	cache Cache
+
+	threshold int
+	coolDown  time.Duration
+	now       func() time.Time
+
+	lock     sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// Get retrieves an item from the wrapped cache unless the circuit is open.
+func (cb *CircuitBreaker) Get(ctx context.Context, k string) (*CacheItem, error) {
+	if err := cb.before(); err != nil {
+		return nil, err
+	}
+
+	item, err := cb.cache.Get(ctx, k)
+	cb.after(err)
+
+	return item, err
+}
+
+// Set stores an item in the wrapped cache unless the circuit is open.
+func (cb *CircuitBreaker) Set(ctx context.Context, k string, v *CacheItem) error {
+	if err := cb.before(); err != nil {
+		return err
+	}
+
+	err := cb.cache.Set(ctx, k, v)
+	cb.after(err)
+
+	return err
+}
+
+// Update modifies the expiration of an item in the wrapped cache unless the circuit is open.
+func (cb *CircuitBreaker) Update(ctx context.Context, k string, expiration time.Time) error {
+	if err := cb.before(); err != nil {
+		return err
+	}
+
+	err := cb.cache.Update(ctx, k, expiration)
+	cb.after(err)
+
+	return err
+}
+
+// Peek looks up an item of the wrapped cache as Peek does, unless the circuit is open.
+func (cb *CircuitBreaker) Peek(ctx context.Context, k string) (*CacheItem, error) {
+	if err := cb.before(); err != nil {
+		return nil, err
+	}
+
+	item, err := Peek(ctx, cb.cache, k)
+	cb.after(err)
+
+	return item, err
+}
+
+// Range enumerates the wrapped cache unless the circuit is open.
+// Returns caches.ErrNotSupported if the wrapped cache is not a Ranger.
+func (cb *CircuitBreaker) Range(ctx context.Context, f func(k string, v *CacheItem) bool) error {
+	ranger, ok := cb.cache.(Ranger)
+	if !ok {
+		return caches.ErrNotSupported
+	}
+
+	if err := cb.before(); err != nil {
+		return err
+	}
+
+	err := ranger.Range(ctx, f)
+	cb.after(err)
+
+	return err
+}
+
+// Delete removes an item from the wrapped cache unless the circuit is open.
+// Returns caches.ErrNotSupported if the wrapped cache is not a Deleter.
+func (cb *CircuitBreaker) Delete(ctx context.Context, k string) error {
+	deleter, ok := cb.cache.(Deleter)
+	if !ok {
+		return caches.ErrNotSupported
+	}
+
+	if err := cb.before(); err != nil {
+		return err
+	}
+
+	err := deleter.Delete(ctx, k)
+	cb.after(err)
+
+	return err
+}
+
+// PurgePrefix removes every item whose key starts with prefix from the wrapped cache
+// unless the circuit is open.
+func (cb *CircuitBreaker) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	if err := cb.before(); err != nil {
+		return 0, err
+	}
+
+	n, err := PurgePrefix(ctx, cb.cache, prefix)
+	cb.after(err)
+
+	return n, err
+}
+
+// PurgeHost removes every item cached for host from the wrapped cache unless the
+// circuit is open.
+func (cb *CircuitBreaker) PurgeHost(ctx context.Context, host string) (int, error) {
+	if err := cb.before(); err != nil {
+		return 0, err
+	}
+
+	n, err := PurgeHost(ctx, cb.cache, host)
+	cb.after(err)
+
+	return n, err
+}
+
+// PurgeTag removes every item stored with tag from the wrapped cache unless the circuit
+// is open.
+func (cb *CircuitBreaker) PurgeTag(ctx context.Context, tag string) (int, error) {
+	if err := cb.before(); err != nil {
+		return 0, err
+	}
+
+	n, err := PurgeTag(ctx, cb.cache, tag)
+	cb.after(err)
+
+	return n, err
+}
+
+// SoftPurge expires an item of the wrapped cache unless the circuit is open.
+func (cb *CircuitBreaker) SoftPurge(ctx context.Context, k string) error {
+	if err := cb.before(); err != nil {
+		return err
+	}
+
+	err := SoftPurge(ctx, cb.cache, k, cb.now)
+	cb.after(err)
+
+	return err
+}
+
+// State returns the current state of the circuit.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.state == CircuitOpen && !cb.now().Before(cb.openedAt.Add(cb.coolDown)) {
+		return CircuitHalfOpen
+	}
+
+	return cb.state
+}
+
+func (cb *CircuitBreaker) before() error {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch cb.state {
+	case CircuitClosed:
+		return nil
+	case CircuitOpen:
+		if cb.now().Before(cb.openedAt.Add(cb.coolDown)) {
+			return caches.ErrCircuitOpen
+		}
+		cb.state = CircuitHalfOpen
+		cb.trial = true
+		return nil
+	case CircuitHalfOpen:
+		// only a single trial call is let through while half open
+		if cb.trial {
+			return caches.ErrCircuitOpen
+		}
+		cb.trial = true
+		return nil
+	}
+
+	return nil
+}
+
+func (cb *CircuitBreaker) after(err error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if inconclusive(err) {
+		// the backend was not asked, or not heard from, so the circuit is left as is
+		cb.trial = false
+		return
+	}
+
+	if !isBackendFailure(err) {
+		cb.state = CircuitClosed
+		cb.failures = 0
+		cb.trial = false
+		return
+	}
+
+	cb.failures++
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.threshold {
+		cb.state = CircuitOpen
+		cb.openedAt = cb.now()
+		cb.trial = false
+	}
+}
+
+func isBackendFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return !errors.Is(err, caches.ErrNoCacheItem) && !errors.Is(err, caches.ErrCacheItemExpired)
+}
+
+// inconclusive reports whether err says nothing about the health of the backend, either
+// because the operation is not supported or because the caller gave up on the call.
+func inconclusive(err error) bool {
+	return errors.Is(err, caches.ErrNotSupported) || errors.Is(err, context.Canceled)
+}
+
+// NewCircuitBreaker wraps cache with a circuit breaker.
+// If config is nil or any of its values are zero, the defaults are used.
+// If the 'now' function is nil, time.Now will be used as the default time provider.
+func NewCircuitBreaker(cache Cache, config *CircuitBreakerConfig, now func() time.Time) *CircuitBreaker {
+	nowFunc := now
+	if nowFunc == nil {
+		nowFunc = time.Now
+	}
+
+	threshold := DefaultFailureThreshold
+	coolDown := DefaultCoolDown
+	if config != nil {
+		if config.FailureThreshold > 0 {
+			threshold = config.FailureThreshold
+		}
+		if config.CoolDown > 0 {
+			coolDown = config.CoolDown
+		}
+	}
+
+	return &CircuitBreaker{
+		cache: cache,
+
+		threshold: threshold,
+		coolDown:  coolDown,
+		now:       nowFunc,
+	}
+}
@@ -0,0 +1,211 @@
+package gocondcache_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/cachetest"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+)
+
+var errBackendDown = errors.New("backend down")
+
+// failingCache is a Cache whose calls all fail until healthy is set.
+type failingCache struct {
+	healthy bool
+	calls   int
+}
+
+func (f *failingCache) Get(ctx context.Context, _ string) (*gocondcache.CacheItem, error) {
+	f.calls++
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if f.healthy {
+		return nil, caches.ErrNoCacheItem
+	}
+	return nil, errBackendDown
+}
+
+func (f *failingCache) Set(context.Context, string, *gocondcache.CacheItem) error {
+	f.calls++
+	if f.healthy {
+		return nil
+	}
+	return errBackendDown
+}
+
+func (f *failingCache) Update(context.Context, string, time.Time) error {
+	f.calls++
+	if f.healthy {
+		return nil
+	}
+	return errBackendDown
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	currentTime := testTime()
+	backend := &failingCache{}
+	cb := gocondcache.NewCircuitBreaker(backend, &gocondcache.CircuitBreakerConfig{
+		FailureThreshold: 2,
+		CoolDown:         time.Minute,
+	}, func() time.Time { return currentTime })
+
+	for range 2 {
+		if _, err := cb.Get(ctx, "key"); !errors.Is(err, errBackendDown) {
+			t.Fatalf("expected backend error, got %v", err)
+		}
+	}
+	if cb.State() != gocondcache.CircuitOpen {
+		t.Fatalf("expected circuit to be open, got %v", cb.State())
+	}
+
+	// calls are short-circuited while open
+	if _, err := cb.Get(ctx, "key"); !errors.Is(err, caches.ErrCircuitOpen) {
+		t.Fatalf("expected circuit open error, got %v", err)
+	}
+	if backend.calls != 2 {
+		t.Errorf("expected 2 backend calls, got %d", backend.calls)
+	}
+
+	// a failing trial call after the cool-down reopens the circuit
+	currentTime = currentTime.Add(time.Minute)
+	if err := cb.Set(ctx, "key", &gocondcache.CacheItem{}); !errors.Is(err, errBackendDown) {
+		t.Fatalf("expected backend error, got %v", err)
+	}
+	if cb.State() != gocondcache.CircuitOpen {
+		t.Fatalf("expected circuit to be open, got %v", cb.State())
+	}
+
+	// a successful trial call closes it again
+	currentTime = currentTime.Add(time.Minute)
+	backend.healthy = true
+	if _, err := cb.Get(ctx, "key"); !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Fatalf("expected missing item error, got %v", err)
+	}
+	if cb.State() != gocondcache.CircuitClosed {
+		t.Fatalf("expected circuit to be closed, got %v", cb.State())
+	}
+}
+
+func TestCircuitBreakerCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	currentTime := testTime()
+	backend := &failingCache{}
+	cb := gocondcache.NewCircuitBreaker(backend, &gocondcache.CircuitBreakerConfig{
+		FailureThreshold: 2,
+		CoolDown:         time.Minute,
+	}, func() time.Time { return currentTime })
+
+	// a canceled call between two failures does not reset their count
+	_, _ = cb.Get(ctx, "key")
+	if _, err := cb.Get(canceled, "key"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	_, _ = cb.Get(ctx, "key")
+	if cb.State() != gocondcache.CircuitOpen {
+		t.Fatalf("expected circuit to be open, got %v", cb.State())
+	}
+
+	// a canceled trial call neither closes the circuit nor keeps the trial slot
+	currentTime = currentTime.Add(time.Minute)
+	if _, err := cb.Get(canceled, "key"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	if cb.State() != gocondcache.CircuitHalfOpen {
+		t.Fatalf("expected circuit to stay half open, got %v", cb.State())
+	}
+
+	// the next trial call decides, and the backend is still down
+	if _, err := cb.Get(ctx, "key"); !errors.Is(err, errBackendDown) {
+		t.Fatalf("expected backend error, got %v", err)
+	}
+	if cb.State() != gocondcache.CircuitOpen {
+		t.Fatalf("expected circuit to be open, got %v", cb.State())
+	}
+}
+
+func TestBackendErrorPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		policy          gocondcache.BackendErrorPolicy
+		expectedErr     error
+		expectedOrigins int
+	}{
+		{
+			name:            "fail open goes to the origin",
+			policy:          gocondcache.FailOpen,
+			expectedErr:     nil,
+			expectedOrigins: 1,
+		},
+		{
+			name:            "fail closed returns the backend error",
+			policy:          gocondcache.FailClosed,
+			expectedErr:     errBackendDown,
+			expectedOrigins: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			requestCount := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				requestCount++
+				w.Header().Set("ETag", `"abc"`)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			transport := gocondcache.New(
+				&failingCache{},
+				&gocondcache.Config{BackendErrorPolicy: tt.policy, ReadTimeout: time.Second},
+				testTime,
+				slog.New(slog.NewTextHandler(io.Discard, nil)),
+			)(http.DefaultTransport)
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+
+			resp, err := transport.RoundTrip(req)
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if requestCount != tt.expectedOrigins {
+				t.Errorf("expected %d requests to server, got %d", tt.expectedOrigins, requestCount)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(_ *testing.T, now func() time.Time) gocondcache.Cache {
+		cache := local.NewBasicCacheWithTimeFunc(now)
+		return gocondcache.NewCircuitBreaker(&cache, nil, now)
+	})
+}
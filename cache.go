@@ -13,6 +13,14 @@ type CacheItem struct {
 	LastModified *time.Time
 	Response     []byte
 	Expiration   time.Time
+
+	// Tags are arbitrary labels attached to the item when it was stored. They are
+	// persisted by the backends so that items can be listed and purged by tag.
+	Tags []string
+
+	// StaleWindow is how long past Expiration the item may still be served when
+	// revalidation with the origin fails. A zero value disables serving stale items.
+	StaleWindow time.Duration
 }
 
 // Cache defines the interface for cache operations across different storage implementations.
@@ -26,3 +34,54 @@ type Cache interface {
 	Set(ctx context.Context, k string, v *CacheItem) error
 	Update(ctx context.Context, k string, expiration time.Time) error
 }
+
+// Ranger is implemented by caches that can enumerate the items they hold.
+type Ranger interface {
+	// Range calls f for every item in the cache, expired or not, until f returns false.
+	// The cache must not be modified from within f.
+	Range(ctx context.Context, f func(k string, v *CacheItem) bool) error
+}
+
+// Peeker is implemented by caches that can look up an item without the side effects of
+// Get, such as recording an access that protects the item from eviction.
+type Peeker interface {
+	// Peek returns the item stored under k as Get does, except that the lookup is not
+	// recorded as an access to the item and that its Response may be left empty.
+	Peek(ctx context.Context, k string) (*CacheItem, error)
+}
+
+// Deleter is implemented by caches that can remove items.
+type Deleter interface {
+	// Delete removes the item stored under k. Deleting a missing item is not an error.
+	Delete(ctx context.Context, k string) error
+}
+
+// PrefixPurger is implemented by caches that can remove every item whose key starts
+// with a prefix without enumerating the whole cache.
+type PrefixPurger interface {
+	// PurgePrefix removes every item whose key starts with prefix and returns how many
+	// were removed.
+	PurgePrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// HostPurger is implemented by caches that can remove every item cached for a host
+// without enumerating the whole cache.
+type HostPurger interface {
+	// PurgeHost removes every item whose key was built from a URL of host, as returned
+	// by caches.KeyHost, and returns how many were removed.
+	PurgeHost(ctx context.Context, host string) (int, error)
+}
+
+// SoftPurger is implemented by caches that can expire an item in place.
+type SoftPurger interface {
+	// SoftPurge sets the expiration of the item stored under k to now, so that the next
+	// request revalidates it with its validators instead of fetching it again.
+	// Returns caches.ErrNoCacheItem if the item doesn't exist.
+	SoftPurge(ctx context.Context, k string) error
+}
+
+// TagPurger is implemented by caches that index items by tag.
+type TagPurger interface {
+	// PurgeTag removes every item stored with tag and returns how many were removed.
+	PurgeTag(ctx context.Context, tag string) (int, error)
+}
@@ -1,7 +1,10 @@
 package caches
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -14,6 +17,31 @@ var (
 	DefaultExpiredTaskTimer = 10 * time.Minute
 )
 
+// Key returns the cache key of a request, made of its method and URL.
 func Key(req http.Request) string {
 	return strings.Join([]string{req.Method, "#", req.URL.String()}, "")
 }
+
+// KeyWithBody returns the cache key of a request whose body is part of its identity,
+// such as a POST to a GraphQL endpoint. The key is the request's Key followed by the
+// hex encoded SHA-256 digest of body.
+func KeyWithBody(req http.Request, body []byte) string {
+	digest := sha256.Sum256(body)
+	return strings.Join([]string{Key(req), "#", hex.EncodeToString(digest[:])}, "")
+}
+
+// KeyHost returns the host of the URL a key built by Key or KeyWithBody was made of,
+// or an empty string if the key was not built by them.
+func KeyHost(k string) string {
+	_, rawURL, found := strings.Cut(k, "#")
+	if !found {
+		return ""
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
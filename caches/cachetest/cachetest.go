@@ -0,0 +1,481 @@
+// Package cachetest provides a conformance test suite for gocondcache.Cache
+// implementations, so that every backend behaves the same way towards the transport.
+//
+// A backend adopts it from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		cachetest.RunConformance(t, func(t *testing.T, now func() time.Time) gocondcache.Cache {
+//			return mybackend.New(..., now)
+//		})
+//	}
+//
+// The optional capabilities of the gocondcache package, such as gocondcache.Deleter or
+// gocondcache.TagPurger, are tested too when the cache implements them.
+//
+// RunCompliance takes the same factory and checks the RFC 9111 behavior of a
+// gocondcache.CacheTransport storing responses in the cache.
+package cachetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+const (
+	// LargeResponseSize is the size of the response stored by the large body test. It
+	// stays below the 400 KB item size limit of DynamoDB.
+	LargeResponseSize = 300 << 10
+
+	concurrentWorkers    = 8
+	concurrentOperations = 50
+)
+
+// Factory returns an empty cache for a single test. The cache must use now as its
+// clock, which the suite advances to expire items. Resources held by the cache should be
+// released with t.Cleanup.
+type Factory func(t *testing.T, now func() time.Time) gocondcache.Cache
+
+// RunConformance runs the conformance suite against the caches returned by factory.
+// Every test runs against a new cache, sequentially, so a factory may share a single
+// database as long as it empties it.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock)
+	}{
+		{"GetMissing", testGetMissing},
+		{"SetGet", testSetGet},
+		{"Overwrite", testOverwrite},
+		{"Expiration", testExpiration},
+		{"Update", testUpdate},
+		{"UpdateMissing", testUpdateMissing},
+		{"Keys", testKeys},
+		{"LargeResponse", testLargeResponse},
+		{"CanceledContext", testCanceledContext},
+		{"Concurrency", testConcurrency},
+		{"Peek", testPeek},
+		{"Delete", testDelete},
+		{"Range", testRange},
+		{"PurgePrefix", testPurgePrefix},
+		{"PurgeHost", testPurgeHost},
+		{"PurgeTag", testPurgeTag},
+		{"SoftPurge", testSoftPurge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := gocondcachetest.NewClock(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+			tt.test(t, factory(t, clock.Now), clock)
+		})
+	}
+}
+
+func newItem(clock *gocondcachetest.Clock, ttl time.Duration, body string) *gocondcache.CacheItem {
+	lastModified := clock.Now().Add(-time.Hour)
+
+	return &gocondcache.CacheItem{
+		ETAG:         `"` + body + `"`,
+		LastModified: &lastModified,
+		Response:     []byte("HTTP/1.1 200 OK\r\nContent-Length: " + fmt.Sprint(len(body)) + "\r\n\r\n" + body),
+		Expiration:   clock.Now().Add(ttl),
+	}
+}
+
+func set(t *testing.T, cache gocondcache.Cache, k string, v *gocondcache.CacheItem) {
+	t.Helper()
+
+	if err := cache.Set(context.Background(), k, v); err != nil {
+		t.Fatalf("Set(%q) returned error: %v", k, err)
+	}
+}
+
+// get returns the item stored under k, expecting the error to match expectedErr.
+func get(t *testing.T, cache gocondcache.Cache, k string, expectedErr error) *gocondcache.CacheItem {
+	t.Helper()
+
+	item, err := cache.Get(context.Background(), k)
+	if !errors.Is(err, expectedErr) {
+		t.Fatalf("Get(%q) returned error %v, expected %v", k, err, expectedErr)
+	}
+	if !errors.Is(expectedErr, caches.ErrNoCacheItem) && item == nil {
+		t.Fatalf("Get(%q) returned no item", k)
+	}
+
+	return item
+}
+
+func assertItem(t *testing.T, got, expected *gocondcache.CacheItem) {
+	t.Helper()
+
+	if got.ETAG != expected.ETAG {
+		t.Errorf("expected ETAG %q, got %q", expected.ETAG, got.ETAG)
+	}
+	if (got.LastModified == nil) != (expected.LastModified == nil) ||
+		(got.LastModified != nil && !got.LastModified.Equal(*expected.LastModified)) {
+		t.Errorf("expected LastModified %v, got %v", expected.LastModified, got.LastModified)
+	}
+	if !bytes.Equal(got.Response, expected.Response) {
+		t.Errorf("expected response of %d bytes, got %d bytes", len(expected.Response), len(got.Response))
+	}
+	if !got.Expiration.Equal(expected.Expiration) {
+		t.Errorf("expected Expiration %v, got %v", expected.Expiration, got.Expiration)
+	}
+	if !slices.Equal(got.Tags, expected.Tags) {
+		t.Errorf("expected Tags %v, got %v", expected.Tags, got.Tags)
+	}
+	if got.StaleWindow != expected.StaleWindow {
+		t.Errorf("expected StaleWindow %v, got %v", expected.StaleWindow, got.StaleWindow)
+	}
+}
+
+func testGetMissing(t *testing.T, cache gocondcache.Cache, _ *gocondcachetest.Clock) {
+	if item := get(t, cache, "GET#https://example.com/missing", caches.ErrNoCacheItem); item != nil {
+		t.Errorf("expected no item, got %+v", item)
+	}
+}
+
+func testSetGet(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	item := newItem(clock, time.Minute, "body")
+	item.Tags = []string{"a", "b"}
+	item.StaleWindow = time.Hour
+	set(t, cache, "GET#https://example.com/", item)
+
+	assertItem(t, get(t, cache, "GET#https://example.com/", nil), item)
+}
+
+func testOverwrite(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	set(t, cache, "GET#https://example.com/", newItem(clock, time.Minute, "first"))
+	second := newItem(clock, 2*time.Minute, "second")
+	set(t, cache, "GET#https://example.com/", second)
+
+	assertItem(t, get(t, cache, "GET#https://example.com/", nil), second)
+}
+
+func testExpiration(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	item := newItem(clock, time.Minute, "body")
+	set(t, cache, "GET#https://example.com/", item)
+
+	clock.Advance(30 * time.Second)
+	get(t, cache, "GET#https://example.com/", nil)
+
+	// an expired item is still returned, so that it can be revalidated
+	clock.Advance(time.Minute)
+	assertItem(t, get(t, cache, "GET#https://example.com/", caches.ErrCacheItemExpired), item)
+}
+
+func testUpdate(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	item := newItem(clock, time.Minute, "body")
+	item.Tags = []string{"a"}
+	set(t, cache, "GET#https://example.com/", item)
+
+	clock.Advance(2 * time.Minute)
+	get(t, cache, "GET#https://example.com/", caches.ErrCacheItemExpired)
+
+	expiration := clock.Now().Add(time.Minute)
+	if err := cache.Update(context.Background(), "GET#https://example.com/", expiration); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	updated := *item
+	updated.Expiration = expiration
+	assertItem(t, get(t, cache, "GET#https://example.com/", nil), &updated)
+}
+
+func testUpdateMissing(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	err := cache.Update(context.Background(), "GET#https://example.com/missing", clock.Now().Add(time.Minute))
+	if !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected ErrNoCacheItem updating a missing item, got %v", err)
+	}
+
+	get(t, cache, "GET#https://example.com/missing", caches.ErrNoCacheItem)
+}
+
+func testKeys(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	keys := []string{
+		"GET#https://example.com/path?query=1&other=%20#fragment",
+		"POST#https://example.com/graphql#0123456789abcdef",
+		"GET#https://example.com/ünïcödé/😀",
+		"GET#https://example.com/path",
+		"GET#https://example.com/path/",
+	}
+	for i, k := range keys {
+		set(t, cache, k, newItem(clock, time.Minute, fmt.Sprint(i)))
+	}
+
+	for i, k := range keys {
+		if got := get(t, cache, k, nil); got.ETAG != fmt.Sprintf(`"%d"`, i) {
+			t.Errorf("Get(%q) returned the item of another key, %s", k, got.ETAG)
+		}
+	}
+}
+
+func testLargeResponse(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	item := newItem(clock, time.Minute, string(bytes.Repeat([]byte("0123456789abcdef"), LargeResponseSize/16)))
+	set(t, cache, "GET#https://example.com/large", item)
+
+	assertItem(t, get(t, cache, "GET#https://example.com/large", nil), item)
+}
+
+func testCanceledContext(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	set(t, cache, "GET#https://example.com/", newItem(clock, time.Minute, "body"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// a cache may ignore the context, but must not fail for any other reason
+	check := func(op string, err error) {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf("%s with a canceled context returned %v, expected nil or context.Canceled", op, err)
+		}
+	}
+
+	_, err := cache.Get(ctx, "GET#https://example.com/")
+	check("Get", err)
+	check("Set", cache.Set(ctx, "GET#https://example.com/other", newItem(clock, time.Minute, "other")))
+	check("Update", cache.Update(ctx, "GET#https://example.com/", clock.Now().Add(time.Hour)))
+}
+
+func testConcurrency(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrentWorkers*concurrentOperations)
+
+	for w := range concurrentWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx := context.Background()
+			for i := range concurrentOperations {
+				// workers share half of their keys with each other
+				k := fmt.Sprintf("GET#https://example.com/%d", i%(concurrentOperations/2)+w%2*concurrentOperations)
+				if err := cache.Set(ctx, k, newItem(clock, time.Minute, fmt.Sprint(i))); err != nil {
+					errs <- fmt.Errorf("Set(%q): %w", k, err)
+				}
+				if _, err := cache.Get(ctx, k); err != nil && !errors.Is(err, caches.ErrCacheItemExpired) {
+					errs <- fmt.Errorf("Get(%q): %w", k, err)
+				}
+				if err := cache.Update(ctx, k, clock.Now().Add(time.Hour)); err != nil {
+					errs <- fmt.Errorf("Update(%q): %w", k, err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func testPeek(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	peeker, ok := cache.(gocondcache.Peeker)
+	if !ok {
+		t.Skip("cache is not a gocondcache.Peeker")
+	}
+
+	item := newItem(clock, time.Minute, "body")
+	set(t, cache, "GET#https://example.com/", item)
+
+	// the response may be left out
+	peeked, err := peeker.Peek(context.Background(), "GET#https://example.com/")
+	if err != nil {
+		t.Fatalf("Peek returned error: %v", err)
+	}
+	if len(peeked.Response) == 0 {
+		peeked.Response = item.Response
+	}
+	assertItem(t, peeked, item)
+
+	if _, err = peeker.Peek(context.Background(), "GET#https://example.com/missing"); !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected ErrNoCacheItem peeking at a missing item, got %v", err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, err = peeker.Peek(context.Background(), "GET#https://example.com/"); !errors.Is(err, caches.ErrCacheItemExpired) {
+		t.Errorf("expected ErrCacheItemExpired peeking at an expired item, got %v", err)
+	}
+}
+
+func testDelete(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	deleter, ok := cache.(gocondcache.Deleter)
+	if !ok {
+		t.Skip("cache is not a gocondcache.Deleter")
+	}
+
+	set(t, cache, "GET#https://example.com/a", newItem(clock, time.Minute, "a"))
+	set(t, cache, "GET#https://example.com/b", newItem(clock, time.Minute, "b"))
+
+	if err := deleter.Delete(context.Background(), "GET#https://example.com/a"); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+	if err := deleter.Delete(context.Background(), "GET#https://example.com/missing"); err != nil {
+		t.Errorf("Delete of a missing item returned error: %v", err)
+	}
+
+	get(t, cache, "GET#https://example.com/a", caches.ErrNoCacheItem)
+	get(t, cache, "GET#https://example.com/b", nil)
+}
+
+func testRange(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	ranger, ok := cache.(gocondcache.Ranger)
+	if !ok {
+		t.Skip("cache is not a gocondcache.Ranger")
+	}
+
+	expected := []string{"GET#https://example.com/a", "GET#https://example.com/b", "GET#https://example.com/c"}
+	set(t, cache, expected[0], newItem(clock, time.Minute, "a"))
+	set(t, cache, expected[1], newItem(clock, time.Minute, "b"))
+	set(t, cache, expected[2], newItem(clock, -time.Minute, "c"))
+
+	var keys []string
+	err := ranger.Range(context.Background(), func(k string, v *gocondcache.CacheItem) bool {
+		keys = append(keys, k)
+		if v == nil || len(v.Response) == 0 {
+			t.Errorf("Range returned an empty item for %q", k)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Range returned error: %v", err)
+	}
+
+	slices.Sort(keys)
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected Range to return %v, expired items included, got %v", expected, keys)
+	}
+
+	calls := 0
+	err = ranger.Range(context.Background(), func(string, *gocondcache.CacheItem) bool {
+		calls++
+		return false
+	})
+	if err != nil || calls != 1 {
+		t.Errorf("expected Range to stop after f returned false, got %d calls and error %v", calls, err)
+	}
+}
+
+// testPurge checks that purge removes the items under gone and no other.
+func testPurge(t *testing.T, cache gocondcache.Cache, purge func() (int, error), gone, kept []string) {
+	t.Helper()
+
+	n, err := purge()
+	if err != nil {
+		t.Fatalf("purge returned error: %v", err)
+	}
+	if n != len(gone) {
+		t.Errorf("expected %d items purged, got %d", len(gone), n)
+	}
+
+	for _, k := range gone {
+		get(t, cache, k, caches.ErrNoCacheItem)
+	}
+	for _, k := range kept {
+		get(t, cache, k, nil)
+	}
+}
+
+func testPurgePrefix(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	purger, ok := cache.(gocondcache.PrefixPurger)
+	if !ok {
+		t.Skip("cache is not a gocondcache.PrefixPurger")
+	}
+
+	gone := []string{"GET#https://example.com/api/a", "GET#https://example.com/api/b"}
+	kept := []string{"GET#https://example.com/apiary", "HEAD#https://example.com/api/a", "GET#https://example.org/api/a"}
+	for _, k := range append(slices.Clone(gone), kept...) {
+		set(t, cache, k, newItem(clock, time.Minute, k))
+	}
+
+	testPurge(t, cache, func() (int, error) {
+		return purger.PurgePrefix(context.Background(), "GET#https://example.com/api/")
+	}, gone, kept)
+}
+
+func testPurgeHost(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	purger, ok := cache.(gocondcache.HostPurger)
+	if !ok {
+		t.Skip("cache is not a gocondcache.HostPurger")
+	}
+
+	gone := []string{"GET#https://example.com/", "POST#http://example.com/graphql#digest", "GET#https://user@example.com?q"}
+	kept := []string{"GET#https://example.com:8443/", "GET#https://www.example.com/", "GET#https://example.org/example.com"}
+	for _, k := range append(slices.Clone(gone), kept...) {
+		set(t, cache, k, newItem(clock, time.Minute, k))
+	}
+
+	testPurge(t, cache, func() (int, error) {
+		return purger.PurgeHost(context.Background(), "example.com")
+	}, gone, kept)
+}
+
+func testPurgeTag(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	purger, ok := cache.(gocondcache.TagPurger)
+	if !ok {
+		t.Skip("cache is not a gocondcache.TagPurger")
+	}
+
+	tags := map[string][]string{
+		"GET#https://example.com/users/1": {"user-1", "users"},
+		"GET#https://example.com/users/2": {"users", "user-2"},
+		"GET#https://example.com/posts/1": {"posts", "user-1"},
+		"GET#https://example.com/":        nil,
+	}
+	for k, v := range tags {
+		item := newItem(clock, time.Minute, k)
+		item.Tags = v
+		set(t, cache, k, item)
+	}
+
+	// replacing an item without a tag removes it from the tag
+	replaced := newItem(clock, time.Minute, "replaced")
+	replaced.Tags = []string{"posts"}
+	set(t, cache, "GET#https://example.com/posts/1", replaced)
+
+	testPurge(t, cache, func() (int, error) {
+		return purger.PurgeTag(context.Background(), "user-1")
+	}, []string{"GET#https://example.com/users/1"}, []string{
+		"GET#https://example.com/users/2",
+		"GET#https://example.com/posts/1",
+		"GET#https://example.com/",
+	})
+
+	testPurge(t, cache, func() (int, error) {
+		return purger.PurgeTag(context.Background(), "missing")
+	}, nil, []string{"GET#https://example.com/users/2"})
+}
+
+func testSoftPurge(t *testing.T, cache gocondcache.Cache, clock *gocondcachetest.Clock) {
+	purger, ok := cache.(gocondcache.SoftPurger)
+	if !ok {
+		t.Skip("cache is not a gocondcache.SoftPurger")
+	}
+
+	item := newItem(clock, time.Hour, "body")
+	set(t, cache, "GET#https://example.com/", item)
+
+	if err := purger.SoftPurge(context.Background(), "GET#https://example.com/"); err != nil {
+		t.Fatalf("SoftPurge returned error: %v", err)
+	}
+	if err := purger.SoftPurge(context.Background(), "GET#https://example.com/missing"); !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected ErrNoCacheItem soft purging a missing item, got %v", err)
+	}
+
+	// the item expires now and is kept so that it can be revalidated
+	clock.Advance(time.Second)
+	purged := *item
+	purged.Expiration = clock.Now().Add(-time.Second)
+	assertItem(t, get(t, cache, "GET#https://example.com/", caches.ErrCacheItemExpired), &purged)
+}
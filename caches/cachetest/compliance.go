@@ -0,0 +1,669 @@
+package cachetest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+// Requirement is the level of the RFC 9111 requirement a compliance test checks, as
+// defined by RFC 2119.
+type Requirement string
+
+const (
+	// RequirementMust is an absolute requirement.
+	RequirementMust Requirement = "MUST"
+	// RequirementShould is a recommendation, usually an optimization.
+	RequirementShould Requirement = "SHOULD"
+	// RequirementMay is an optional behavior.
+	RequirementMay Requirement = "MAY"
+)
+
+// ComplianceResult is the outcome of a test of the compliance matrix.
+type ComplianceResult struct {
+	ID          string
+	Group       string
+	Description string
+	Requirement Requirement
+	Section     string // section of RFC 9111, or of RFC 9110, defining the behavior
+
+	// Supported is true if CacheTransport is known to implement the behavior.
+	Supported bool
+
+	Passed  bool
+	Failure string // why the test failed, if it did
+}
+
+// originExpectation is what a step of a compliance test expects the origin to see.
+type originExpectation int
+
+const (
+	// fromCache expects the response to be served without contacting the origin.
+	fromCache originExpectation = iota
+	// forwarded expects the request to reach the origin, with or without validators.
+	forwarded
+	// validated expects the request to reach the origin with validators.
+	validated
+	// fetched expects the request to reach the origin without validators.
+	fetched
+)
+
+type complianceStep struct {
+	advance time.Duration
+	method  string // defaults to GET
+	header  http.Header
+	expect  originExpectation
+
+	status         int    // expected status code, defaults to 200
+	body           string // expected body, unchecked when empty
+	responseHeader http.Header
+}
+
+type complianceTest struct {
+	id          string
+	group       string
+	description string
+	requirement Requirement
+	section     string
+	supported   bool
+
+	// responses is the script of the origin, see gocondcachetest.Origin.Handle.
+	responses []gocondcachetest.Response
+	steps     []complianceStep
+}
+
+// complianceStart is the time the clock of every compliance test starts at.
+var complianceStart = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// RunCompliance runs a compliance matrix, modeled on https://cache-tests.fyi, checking
+// that a gocondcache.CacheTransport storing responses in the caches returned by factory
+// behaves as the private HTTP cache defined by RFC 9111. It covers freshness,
+// validation, invalidation, Vary, the cacheability of status codes, the update of stored
+// headers on 304 and request directives.
+//
+// Every test is a subtest. Behaviors the transport does not implement yet are skipped
+// with the reason they fail, and the test fails if a supported behavior breaks or an
+// unsupported one starts passing, so that the matrix stays accurate. A summary table is
+// logged, and the results are returned.
+func RunCompliance(t *testing.T, factory Factory) []ComplianceResult {
+	t.Helper()
+
+	results := make([]ComplianceResult, 0, len(complianceTests))
+	for _, tt := range complianceTests {
+		result := ComplianceResult{
+			ID:          tt.id,
+			Group:       tt.group,
+			Description: tt.description,
+			Requirement: tt.requirement,
+			Section:     tt.section,
+			Supported:   tt.supported,
+		}
+
+		t.Run(tt.id, func(t *testing.T) {
+			result.Failure = runComplianceTest(t, factory, tt)
+			result.Passed = result.Failure == ""
+
+			switch {
+			case result.Passed && !tt.supported:
+				t.Errorf("%s: passes but is marked unsupported, update the compliance matrix", tt.description)
+			case !result.Passed && tt.supported:
+				t.Errorf("%s: %s", tt.description, result.Failure)
+			case !result.Passed:
+				t.Skipf("not supported by CacheTransport, %s: %s", tt.description, result.Failure)
+			}
+		})
+
+		results = append(results, result)
+	}
+
+	t.Log("RFC 9111 compliance:\n" + complianceSummary(results))
+
+	return results
+}
+
+// runComplianceTest runs tt and returns why it failed, or an empty string if it passed.
+func runComplianceTest(t *testing.T, factory Factory, tt complianceTest) string {
+	clock := gocondcachetest.NewClock(complianceStart)
+	origin := gocondcachetest.NewOrigin(clock)
+	defer origin.Close()
+
+	origin.Handle("/r", tt.responses...)
+	client := gocondcachetest.NewClient(factory(t, clock.Now), nil, clock)
+
+	for i, step := range tt.steps {
+		clock.Advance(step.advance)
+
+		if failure := runComplianceStep(client, origin, step); failure != "" {
+			return fmt.Sprintf("request %d: %s", i+1, failure)
+		}
+	}
+
+	return ""
+}
+
+func runComplianceStep(client *http.Client, origin *gocondcachetest.Origin, step complianceStep) string {
+	method := step.method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequest(method, origin.URL+"/r", nil)
+	if err != nil {
+		return err.Error()
+	}
+	for k, v := range step.header {
+		req.Header[k] = v
+	}
+
+	before := origin.Stats("/r")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err.Error()
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err.Error()
+	}
+	after := origin.Stats("/r")
+
+	requested := after.Requests > before.Requests
+	conditional := after.Conditional > before.Conditional
+	switch {
+	case step.expect == fromCache && requested:
+		return "expected the response to be served from the cache, the origin was contacted"
+	case step.expect != fromCache && !requested:
+		return "expected the request to reach the origin, it was served from the cache"
+	case step.expect == validated && !conditional:
+		return "expected a conditional request, the origin received an unconditional one"
+	case step.expect == fetched && conditional:
+		return "expected an unconditional request, the origin received a conditional one"
+	}
+
+	status := step.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if resp.StatusCode != status {
+		return fmt.Sprintf("expected status %d, got %d", status, resp.StatusCode)
+	}
+	if step.body != "" && string(body) != step.body {
+		return fmt.Sprintf("expected body %q, got %q", step.body, body)
+	}
+	for k := range step.responseHeader {
+		if expected, got := step.responseHeader.Get(k), resp.Header.Get(k); got != expected {
+			return fmt.Sprintf("expected header %s: %s, got %q", k, expected, got)
+		}
+	}
+
+	return ""
+}
+
+// complianceSummary formats results as a table, followed by the number of passing
+// tests for each requirement level.
+func complianceSummary(results []ComplianceResult) string {
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RESULT\tLEVEL\tSECTION\tID\tBEHAVIOR")
+	passed := map[Requirement]int{}
+	total := map[Requirement]int{}
+	for _, r := range results {
+		result := "FAIL"
+		if r.Passed {
+			result = "PASS"
+			passed[r.Requirement]++
+		}
+		total[r.Requirement]++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result, r.Requirement, r.Section, r.ID, r.Description)
+	}
+	_ = w.Flush()
+
+	for _, level := range []Requirement{RequirementMust, RequirementShould, RequirementMay} {
+		fmt.Fprintf(&b, "%s: %d/%d passed\n", level, passed[level], total[level])
+	}
+
+	return b.String()
+}
+
+var complianceTests = []complianceTest{
+	// freshness
+	{
+		id:          "freshness-max-age",
+		group:       "freshness",
+		description: "a response fresh according to max-age is reused",
+		requirement: RequirementShould,
+		section:     "4.2.1",
+		supported:   true,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "max-age=3600"}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: 10 * time.Minute, expect: fromCache, body: "1"},
+		},
+	},
+	{
+		id:          "freshness-max-age-stale",
+		group:       "freshness",
+		description: "a response stale according to max-age is not reused without validation",
+		requirement: RequirementMust,
+		section:     "4.2.4",
+		supported:   true,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "max-age=60"}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: 2 * time.Minute, expect: forwarded, body: "1"},
+		},
+	},
+	{
+		id:          "freshness-max-age-case-insensitive",
+		group:       "freshness",
+		description: "cache directive names are matched case-insensitively",
+		requirement: RequirementShould,
+		section:     "5.2",
+		supported:   false,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "MAX-AGE=3600"}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: 10 * time.Minute, expect: fromCache, body: "1"},
+		},
+	},
+	{
+		id:          "freshness-expires",
+		group:       "freshness",
+		description: "a response fresh according to Expires is reused",
+		requirement: RequirementShould,
+		section:     "5.3",
+		supported:   false,
+		responses: []gocondcachetest.Response{{
+			Body:   "1",
+			ETag:   `"1"`,
+			Header: http.Header{"Expires": {complianceStart.Add(time.Hour).Format(http.TimeFormat)}},
+		}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: 10 * time.Minute, expect: fromCache, body: "1"},
+		},
+	},
+	{
+		id:          "freshness-age",
+		group:       "freshness",
+		description: "the Age of a response counts towards its max-age",
+		requirement: RequirementMust,
+		section:     "4.2.3",
+		supported:   false,
+		responses: []gocondcachetest.Response{{
+			Body:         "1",
+			ETag:         `"1"`,
+			CacheControl: "max-age=3600",
+			Header:       http.Header{"Age": {"3540"}},
+		}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: 2 * time.Minute, expect: forwarded, body: "1"},
+		},
+	},
+	{
+		id:          "freshness-s-maxage-ignored",
+		group:       "freshness",
+		description: "a private cache ignores s-maxage",
+		requirement: RequirementMust,
+		section:     "5.2.2.10",
+		supported:   true,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "s-maxage=3600"}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: time.Second, expect: forwarded, body: "1"},
+		},
+	},
+
+	// validation
+	{
+		id:          "validation-etag",
+		group:       "validation",
+		description: "a stale response with an ETag is validated with If-None-Match",
+		requirement: RequirementMust,
+		section:     "4.3.1",
+		supported:   true,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "max-age=60"}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: 2 * time.Minute, expect: validated, body: "1"},
+		},
+	},
+	{
+		id:          "validation-last-modified",
+		group:       "validation",
+		description: "a stale response with a Last-Modified is validated with If-Modified-Since",
+		requirement: RequirementShould,
+		section:     "4.3.1",
+		supported:   true,
+		responses: []gocondcachetest.Response{{
+			Body:         "1",
+			LastModified: complianceStart.Add(-time.Hour),
+			CacheControl: "max-age=60",
+		}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: 2 * time.Minute, expect: validated, body: "1"},
+		},
+	},
+	{
+		id:          "validation-200-replaces",
+		group:       "validation",
+		description: "a full response to a validation request replaces the stored response",
+		requirement: RequirementMust,
+		section:     "4.3.3",
+		supported:   true,
+		responses: []gocondcachetest.Response{
+			{Body: "1", ETag: `"1"`, CacheControl: "max-age=60"},
+			{Body: "2", ETag: `"2"`, CacheControl: "max-age=60"},
+		},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: 2 * time.Minute, expect: validated, body: "2"},
+			{advance: 30 * time.Second, expect: fromCache, body: "2"},
+		},
+	},
+	{
+		id:          "validation-5xx-forwarded",
+		group:       "validation",
+		description: "a server error during validation is forwarded when serving stale is not allowed",
+		requirement: RequirementMust,
+		section:     "4.2.4",
+		supported:   true,
+		responses: []gocondcachetest.Response{
+			{Body: "1", ETag: `"1"`, CacheControl: "max-age=60"},
+			{StatusCode: http.StatusServiceUnavailable, Body: "down"},
+		},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: 2 * time.Minute, expect: validated, status: http.StatusServiceUnavailable, body: "down"},
+		},
+	},
+
+	// update on 304
+	{
+		id:          "update-304-freshness",
+		group:       "update-304",
+		description: "a 304 response freshens the stored response with its max-age",
+		requirement: RequirementShould,
+		section:     "4.3.4",
+		supported:   true,
+		responses: []gocondcachetest.Response{
+			{Body: "1", ETag: `"1"`, CacheControl: "max-age=60"},
+			{Body: "1", ETag: `"1"`, CacheControl: "max-age=3600"},
+		},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: 2 * time.Minute, expect: validated, body: "1"},
+			{advance: 10 * time.Minute, expect: fromCache, body: "1"},
+		},
+	},
+	{
+		id:          "update-304-headers",
+		group:       "update-304",
+		description: "the headers of a 304 response update the stored response",
+		requirement: RequirementMust,
+		section:     "4.3.4",
+		supported:   false,
+		responses: []gocondcachetest.Response{
+			{Body: "1", ETag: `"1"`, CacheControl: "max-age=60", Header: http.Header{"X-Version": {"1"}}},
+			{Body: "1", ETag: `"1"`, CacheControl: "max-age=60", Header: http.Header{"X-Version": {"2"}}},
+		},
+		steps: []complianceStep{
+			{expect: fetched, body: "1", responseHeader: http.Header{"X-Version": {"1"}}},
+			{advance: 2 * time.Minute, expect: validated, body: "1", responseHeader: http.Header{"X-Version": {"2"}}},
+		},
+	},
+
+	// invalidation
+	{
+		id:          "invalidation-post",
+		group:       "invalidation",
+		description: "a successful POST invalidates the stored response of its URI",
+		requirement: RequirementMust,
+		section:     "4.4",
+		supported:   false,
+		responses: []gocondcachetest.Response{
+			{Body: "1", ETag: `"1"`, CacheControl: "max-age=3600"},
+			{Body: "posted"},
+			{Body: "2", ETag: `"2"`, CacheControl: "max-age=3600"},
+		},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{method: http.MethodPost, expect: forwarded, body: "posted"},
+			{expect: forwarded, body: "2"},
+		},
+	},
+	{
+		id:          "invalidation-put",
+		group:       "invalidation",
+		description: "a successful PUT invalidates the stored response of its URI",
+		requirement: RequirementMust,
+		section:     "4.4",
+		supported:   false,
+		responses: []gocondcachetest.Response{
+			{Body: "1", ETag: `"1"`, CacheControl: "max-age=3600"},
+			{StatusCode: http.StatusNoContent},
+			{Body: "2", ETag: `"2"`, CacheControl: "max-age=3600"},
+		},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{method: http.MethodPut, expect: forwarded, status: http.StatusNoContent},
+			{expect: forwarded, body: "2"},
+		},
+	},
+	{
+		id:          "invalidation-delete",
+		group:       "invalidation",
+		description: "a successful DELETE invalidates the stored response of its URI",
+		requirement: RequirementMust,
+		section:     "4.4",
+		supported:   false,
+		responses: []gocondcachetest.Response{
+			{Body: "1", ETag: `"1"`, CacheControl: "max-age=3600"},
+			{StatusCode: http.StatusNoContent},
+			{StatusCode: http.StatusNotFound, Body: "gone"},
+		},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{method: http.MethodDelete, expect: forwarded, status: http.StatusNoContent},
+			{expect: forwarded, status: http.StatusNotFound, body: "gone"},
+		},
+	},
+
+	// vary
+	{
+		id:          "vary-match",
+		group:       "vary",
+		description: "a response is reused for a request with the same values of its Vary headers",
+		requirement: RequirementShould,
+		section:     "4.1",
+		supported:   true,
+		responses: []gocondcachetest.Response{
+			{Body: "en", ETag: `"en"`, CacheControl: "max-age=3600", Header: http.Header{"Vary": {"Accept-Language"}}},
+		},
+		steps: []complianceStep{
+			{header: http.Header{"Accept-Language": {"en"}}, expect: fetched, body: "en"},
+			{header: http.Header{"Accept-Language": {"en"}}, expect: fromCache, body: "en"},
+		},
+	},
+	{
+		id:          "vary-mismatch",
+		group:       "vary",
+		description: "a response is not reused for a request with other values of its Vary headers",
+		requirement: RequirementMust,
+		section:     "4.1",
+		supported:   false,
+		responses: []gocondcachetest.Response{
+			{Body: "en", ETag: `"en"`, CacheControl: "max-age=3600", Header: http.Header{"Vary": {"Accept-Language"}}},
+			{Body: "fr", ETag: `"fr"`, CacheControl: "max-age=3600", Header: http.Header{"Vary": {"Accept-Language"}}},
+		},
+		steps: []complianceStep{
+			{header: http.Header{"Accept-Language": {"en"}}, expect: fetched, body: "en"},
+			{header: http.Header{"Accept-Language": {"fr"}}, expect: forwarded, body: "fr"},
+		},
+	},
+	{
+		id:          "vary-wildcard",
+		group:       "vary",
+		description: "a response with Vary: * is not reused without validation",
+		requirement: RequirementMust,
+		section:     "4.1",
+		supported:   false,
+		responses: []gocondcachetest.Response{
+			{Body: "1", ETag: `"1"`, CacheControl: "max-age=3600", Header: http.Header{"Vary": {"*"}}},
+		},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{expect: forwarded, body: "1"},
+		},
+	},
+
+	// status codes
+	{
+		id:          "status-204",
+		group:       "status",
+		description: "a fresh 204 response is reused",
+		requirement: RequirementShould,
+		section:     "3",
+		supported:   true,
+		responses: []gocondcachetest.Response{
+			{StatusCode: http.StatusNoContent, ETag: `"1"`, CacheControl: "max-age=3600"},
+		},
+		steps: []complianceStep{
+			{expect: fetched, status: http.StatusNoContent},
+			{advance: 10 * time.Minute, expect: fromCache, status: http.StatusNoContent},
+		},
+	},
+	{
+		id:          "status-404",
+		group:       "status",
+		description: "a fresh 404 response is reused",
+		requirement: RequirementMay,
+		section:     "3",
+		supported:   false,
+		responses: []gocondcachetest.Response{
+			{StatusCode: http.StatusNotFound, Body: "missing", ETag: `"1"`, CacheControl: "max-age=3600"},
+		},
+		steps: []complianceStep{
+			{expect: fetched, status: http.StatusNotFound, body: "missing"},
+			{advance: 10 * time.Minute, expect: fromCache, status: http.StatusNotFound, body: "missing"},
+		},
+	},
+	{
+		id:          "status-500",
+		group:       "status",
+		description: "a 500 response without explicit freshness is not reused",
+		requirement: RequirementMust,
+		section:     "4.2.2",
+		supported:   true,
+		responses: []gocondcachetest.Response{
+			{StatusCode: http.StatusInternalServerError, Body: "error", ETag: `"1"`},
+		},
+		steps: []complianceStep{
+			{expect: fetched, status: http.StatusInternalServerError, body: "error"},
+			{expect: forwarded, status: http.StatusInternalServerError, body: "error"},
+		},
+	},
+
+	// response directives
+	{
+		id:          "response-no-store",
+		group:       "response-directives",
+		description: "a response with no-store is not stored",
+		requirement: RequirementMust,
+		section:     "5.2.2.5",
+		supported:   false,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "no-store"}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: time.Second, expect: fetched, body: "1"},
+		},
+	},
+	{
+		id:          "response-no-cache",
+		group:       "response-directives",
+		description: "a response with no-cache is not reused without validation",
+		requirement: RequirementMust,
+		section:     "5.2.2.4",
+		supported:   false,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "max-age=3600, no-cache"}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: time.Second, expect: validated, body: "1"},
+		},
+	},
+	{
+		id:          "response-private",
+		group:       "response-directives",
+		description: "a response with private is reused by a private cache",
+		requirement: RequirementMay,
+		section:     "5.2.2.7",
+		supported:   true,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "private, max-age=3600"}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: 10 * time.Minute, expect: fromCache, body: "1"},
+		},
+	},
+
+	// request directives
+	{
+		id:          "request-no-cache",
+		group:       "request-directives",
+		description: "a request with no-cache is not answered with a stored response without validation",
+		requirement: RequirementMust,
+		section:     "5.2.1.4",
+		supported:   false,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "max-age=3600"}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{header: http.Header{"Cache-Control": {"no-cache"}}, expect: forwarded, body: "1"},
+		},
+	},
+	{
+		id:          "request-max-age-0",
+		group:       "request-directives",
+		description: "a request with max-age=0 is not answered with a stored response without validation",
+		requirement: RequirementMust,
+		section:     "5.2.1.1",
+		supported:   false,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "max-age=3600"}},
+		steps: []complianceStep{
+			{expect: fetched, body: "1"},
+			{advance: time.Second, header: http.Header{"Cache-Control": {"max-age=0"}}, expect: forwarded, body: "1"},
+		},
+	},
+	{
+		id:          "request-no-store",
+		group:       "request-directives",
+		description: "the response to a request with no-store is not stored",
+		requirement: RequirementMust,
+		section:     "5.2.1.5",
+		supported:   false,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "max-age=3600"}},
+		steps: []complianceStep{
+			{header: http.Header{"Cache-Control": {"no-store"}}, expect: fetched, body: "1"},
+			{expect: fetched, body: "1"},
+		},
+	},
+	{
+		id:          "request-only-if-cached",
+		group:       "request-directives",
+		description: "a request with only-if-cached is answered with a 504 when nothing is stored",
+		requirement: RequirementShould,
+		section:     "5.2.1.7",
+		supported:   false,
+		responses:   []gocondcachetest.Response{{Body: "1", ETag: `"1"`, CacheControl: "max-age=3600"}},
+		steps: []complianceStep{
+			{header: http.Header{"Cache-Control": {"only-if-cached"}}, expect: fromCache, status: http.StatusGatewayTimeout},
+		},
+	},
+}
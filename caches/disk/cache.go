@@ -0,0 +1,550 @@
+// Package disk implements a gocondcache.Cache storing every item as files under a
+// directory, for command line tools and single node services that want their cache to
+// outlive the process without running a database.
+//
+// Each item is stored as two files named by the SHA-256 hash of its key: a .body file
+// holding the response, and a .meta sidecar holding the rest of the item as JSON, so
+// that Update only rewrites the sidecar. Files are written to a temporary file and
+// renamed into place, and the sidecar records the size and checksum of the body, so that
+// an item torn by a crash is treated as missing.
+//
+// Several processes may share a directory: operations are serialized with a lock file,
+// using flock on unix systems. On other systems, only the goroutines of a process are
+// serialized.
+package disk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+)
+
+const (
+	bodyExt = ".body"
+	metaExt = ".meta"
+
+	lockName = ".lock"
+	tmpInfix = ".tmp-"
+
+	dirMode = 0o700
+
+	// evictPercent is the share of MaxBytes the cache is reduced to when it exceeds it,
+	// so that the directory is walked once every several writes rather than on each.
+	evictPercent = 90
+)
+
+// Config defines the configuration options of a disk Cache.
+type Config struct {
+	// MaxBytes is the maximum size of the files of the cache. When a write exceeds it,
+	// the least recently used items are removed until the cache is back to 90% of it,
+	// and an item larger than MaxBytes is not stored at all. Each process only counts its
+	// own writes towards the limit between two cleanups, so the directory may briefly
+	// exceed it when it is shared. Zero means no limit.
+	MaxBytes int64
+}
+
+// Cache implements the gocondcache.Cache interface storing items as files under a
+// directory. It must be closed once it is no longer used.
+type Cache struct {
+	dir      string
+	maxBytes int64
+	lock     *locker
+	now      func() time.Time
+
+	// bytes is the size of the cache as last counted plus the writes of this process
+	// since, guarded by the exclusive lock.
+	bytes int64
+}
+
+// metadata is the content of the sidecar of an item.
+type metadata struct {
+	Key          string        `json:"key"`
+	ETag         string        `json:"etag,omitempty"`
+	LastModified *time.Time    `json:"last_modified,omitempty"`
+	Expiration   time.Time     `json:"expiration"`
+	Tags         []string      `json:"tags,omitempty"`
+	StaleWindow  time.Duration `json:"stale_window,omitempty"`
+	BodySize     int           `json:"body_size"`
+	BodyChecksum uint32        `json:"body_checksum"`
+}
+
+// entry is an item found while walking the directory.
+type entry struct {
+	name     string // hash of the key
+	meta     metadata
+	size     int64
+	accessed time.Time
+}
+
+// New returns a Cache storing its items under dir, which is created if it does not
+// exist. A nil config returns an unbounded cache, and a nil now defaults to time.Now.
+func New(dir string, config *Config, now func() time.Time) (*Cache, error) {
+	if dir == "" {
+		return nil, caches.ValidationError{Reason: "empty directory"}
+	}
+
+	c := Config{}
+	if config != nil {
+		c = *config
+	}
+	if c.MaxBytes < 0 {
+		return nil, caches.ValidationError{Reason: "negative max bytes"}
+	}
+
+	nowFunc := now
+	if nowFunc == nil {
+		nowFunc = time.Now
+	}
+
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return nil, err
+	}
+	lock, err := newLocker(filepath.Join(dir, lockName))
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &Cache{dir: dir, maxBytes: c.MaxBytes, lock: lock, now: nowFunc}
+	if c.MaxBytes > 0 {
+		if err = cache.count(); err != nil {
+			return nil, errors.Join(err, lock.close())
+		}
+	}
+
+	return cache, nil
+}
+
+// Close releases the lock file of the cache.
+func (c *Cache) Close() error {
+	return c.lock.close()
+}
+
+// Get reads the item stored under k and records the access for the eviction of the
+// least recently used items. Returns caches.ErrNoCacheItem if the item doesn't exist,
+// and the item along with caches.ErrCacheItemExpired if it has expired.
+func (c *Cache) Get(_ context.Context, k string) (*gocondcache.CacheItem, error) {
+	if err := c.lock.rlock(); err != nil {
+		return nil, err
+	}
+	defer c.lock.runlock()
+
+	name := hashKey(k)
+	item, err := c.read(name, k)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.maxBytes > 0 {
+		now := c.now()
+		// a failed access time only makes the item more likely to be evicted
+		_ = os.Chtimes(c.path(name, bodyExt), now, now)
+	}
+
+	if c.now().UTC().After(item.Expiration) {
+		return item, caches.ErrCacheItemExpired
+	}
+
+	return item, nil
+}
+
+// Peek reads the item stored under k like Get, but only from its metadata, so that its
+// Response is empty, and without recording the access.
+func (c *Cache) Peek(_ context.Context, k string) (*gocondcache.CacheItem, error) {
+	if err := c.lock.rlock(); err != nil {
+		return nil, err
+	}
+	defer c.lock.runlock()
+
+	meta, err := c.readMeta(hashKey(k), k)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &gocondcache.CacheItem{
+		ETAG:         meta.ETag,
+		LastModified: meta.LastModified,
+		Expiration:   meta.Expiration,
+		Tags:         meta.Tags,
+		StaleWindow:  meta.StaleWindow,
+	}
+	if c.now().UTC().After(item.Expiration) {
+		return item, caches.ErrCacheItemExpired
+	}
+
+	return item, nil
+}
+
+// Set stores v under k, replacing any previous item, and removes the least recently
+// used items if the cache exceeds its size limit. An item larger than the limit is not
+// stored, but still removes the previous one.
+func (c *Cache) Set(_ context.Context, k string, v *gocondcache.CacheItem) error {
+	meta, err := json.Marshal(metadata{
+		Key:          k,
+		ETag:         v.ETAG,
+		LastModified: v.LastModified,
+		Expiration:   v.Expiration,
+		Tags:         v.Tags,
+		StaleWindow:  v.StaleWindow,
+		BodySize:     len(v.Response),
+		BodyChecksum: crc32.ChecksumIEEE(v.Response),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = c.lock.lock(); err != nil {
+		return err
+	}
+	defer c.lock.unlock()
+
+	name := hashKey(k)
+	c.bytes -= c.remove(name)
+
+	size := int64(len(v.Response) + len(meta))
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return nil
+	}
+
+	if err = os.MkdirAll(filepath.Dir(c.path(name, "")), dirMode); err != nil {
+		return err
+	}
+	// the body is written first, so that a crash before the sidecar is renamed leaves
+	// the previous sidecar with a mismatching checksum
+	if err = writeFile(c.path(name, bodyExt), v.Response); err != nil {
+		return err
+	}
+	now := c.now()
+	_ = os.Chtimes(c.path(name, bodyExt), now, now)
+	if err = writeFile(c.path(name, metaExt), meta); err != nil {
+		return err
+	}
+
+	c.bytes += size
+	if c.maxBytes > 0 && c.bytes > c.maxBytes {
+		return c.evict(name)
+	}
+
+	return nil
+}
+
+// Update sets the expiration of the item stored under k, rewriting its sidecar only.
+// Returns caches.ErrNoCacheItem if the item doesn't exist.
+func (c *Cache) Update(_ context.Context, k string, expiration time.Time) error {
+	if err := c.lock.lock(); err != nil {
+		return err
+	}
+	defer c.lock.unlock()
+
+	name := hashKey(k)
+	meta, err := c.readMeta(name, k)
+	if err != nil {
+		return err
+	}
+
+	meta.Expiration = expiration
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(c.path(name, metaExt), data)
+}
+
+// Range calls f for every item in the cache until f returns false, without recording
+// accesses. The keys are collected before f is first called, so f may safely use the
+// cache, and items removed in the meantime are skipped.
+func (c *Cache) Range(ctx context.Context, f func(k string, v *gocondcache.CacheItem) bool) error {
+	if err := c.lock.rlock(); err != nil {
+		return err
+	}
+	entries, err := c.walk()
+	c.lock.runlock()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		if err = c.lock.rlock(); err != nil {
+			return err
+		}
+		item, err := c.read(e.name, e.meta.Key)
+		c.lock.runlock()
+		if errors.Is(err, caches.ErrNoCacheItem) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !f(e.meta.Key, item) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the item stored under k, if any.
+func (c *Cache) Delete(_ context.Context, k string) error {
+	if err := c.lock.lock(); err != nil {
+		return err
+	}
+	defer c.lock.unlock()
+
+	c.bytes -= c.remove(hashKey(k))
+
+	return nil
+}
+
+// PurgePrefix removes every item whose key starts with prefix.
+func (c *Cache) PurgePrefix(_ context.Context, prefix string) (int, error) {
+	return c.purge(func(meta *metadata) bool {
+		return strings.HasPrefix(meta.Key, prefix)
+	})
+}
+
+// PurgeHost removes every item cached for host.
+func (c *Cache) PurgeHost(_ context.Context, host string) (int, error) {
+	return c.purge(func(meta *metadata) bool {
+		return caches.KeyHost(meta.Key) == host
+	})
+}
+
+// PurgeTag removes every item stored with tag.
+func (c *Cache) PurgeTag(_ context.Context, tag string) (int, error) {
+	return c.purge(func(meta *metadata) bool {
+		return slices.Contains(meta.Tags, tag)
+	})
+}
+
+// SoftPurge sets the expiration of the item stored under k to now.
+func (c *Cache) SoftPurge(ctx context.Context, k string) error {
+	return c.Update(ctx, k, c.now().UTC())
+}
+
+// purge removes every item whose sidecar matches.
+func (c *Cache) purge(match func(meta *metadata) bool) (int, error) {
+	if err := c.lock.lock(); err != nil {
+		return 0, err
+	}
+	defer c.lock.unlock()
+
+	entries, err := c.walk()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, e := range entries {
+		if match(&e.meta) {
+			c.bytes -= c.remove(e.name)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// evict removes the least recently used items until the cache is back to evictPercent
+// of its limit, keeping the item named kept. The exclusive lock must be held.
+func (c *Cache) evict(kept string) error {
+	entries, err := c.walk()
+	if err != nil {
+		return err
+	}
+
+	c.bytes = 0
+	for _, e := range entries {
+		c.bytes += e.size
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].accessed.Before(entries[j].accessed)
+	})
+	low := c.maxBytes * evictPercent / 100
+	for _, e := range entries {
+		if c.bytes <= low {
+			break
+		}
+		if e.name != kept {
+			c.bytes -= c.remove(e.name)
+		}
+	}
+
+	return nil
+}
+
+// count sets the size of the cache to the size of its files.
+func (c *Cache) count() error {
+	if err := c.lock.rlock(); err != nil {
+		return err
+	}
+	defer c.lock.runlock()
+
+	entries, err := c.walk()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		c.bytes += e.size
+	}
+
+	return nil
+}
+
+// walk returns the items of the directory. The lock must be held.
+func (c *Cache) walk() ([]entry, error) {
+	var entries []entry
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, metaExt) || strings.Contains(d.Name(), tmpInfix) {
+			return err
+		}
+
+		name := strings.TrimSuffix(d.Name(), metaExt)
+		meta, err := c.readMeta(name, "")
+		if errors.Is(err, caches.ErrNoCacheItem) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		metaInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		bodyInfo, err := os.Stat(c.path(name, bodyExt))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, entry{
+			name:     name,
+			meta:     meta,
+			size:     metaInfo.Size() + bodyInfo.Size(),
+			accessed: bodyInfo.ModTime(),
+		})
+		return nil
+	})
+
+	return entries, err
+}
+
+// read returns the item named name stored under k. Returns caches.ErrNoCacheItem if
+// the item is missing or torn.
+func (c *Cache) read(name, k string) (*gocondcache.CacheItem, error) {
+	meta, err := c.readMeta(name, k)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := os.ReadFile(c.path(name, bodyExt))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, caches.ErrNoCacheItem
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(body) != meta.BodySize || crc32.ChecksumIEEE(body) != meta.BodyChecksum {
+		return nil, caches.ErrNoCacheItem
+	}
+
+	return &gocondcache.CacheItem{
+		ETAG:         meta.ETag,
+		LastModified: meta.LastModified,
+		Response:     body,
+		Expiration:   meta.Expiration,
+		Tags:         meta.Tags,
+		StaleWindow:  meta.StaleWindow,
+	}, nil
+}
+
+// readMeta returns the sidecar of the item named name. If k is not empty, the sidecar
+// must be the one of k. Returns caches.ErrNoCacheItem if the sidecar is missing or
+// unreadable.
+func (c *Cache) readMeta(name, k string) (metadata, error) {
+	var meta metadata
+
+	data, err := os.ReadFile(c.path(name, metaExt))
+	if errors.Is(err, fs.ErrNotExist) {
+		return meta, caches.ErrNoCacheItem
+	}
+	if err != nil {
+		return meta, err
+	}
+
+	if json.Unmarshal(data, &meta) != nil || (k != "" && meta.Key != k) {
+		return meta, caches.ErrNoCacheItem
+	}
+
+	return meta, nil
+}
+
+// remove removes the files of the item named name, and returns their size. The
+// exclusive lock must be held.
+func (c *Cache) remove(name string) int64 {
+	var size int64
+	for _, ext := range []string{metaExt, bodyExt} {
+		path := c.path(name, ext)
+		if info, err := os.Stat(path); err == nil && os.Remove(path) == nil {
+			size += info.Size()
+		}
+	}
+
+	return size
+}
+
+// path returns the path of the file of the item named name with the extension ext.
+// Items are spread over subdirectories named by the first byte of their hash.
+func (c *Cache) path(name, ext string) string {
+	return filepath.Join(c.dir, name[:2], name+ext)
+}
+
+func hashKey(k string) string {
+	sum := sha256.Sum256([]byte(k))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFile writes data to a temporary file next to path, syncs it and renames it to
+// path, so that neither readers nor a crash ever leave a partial file under path.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+tmpInfix+"*")
+	if err != nil {
+		return err
+	}
+	// the temporary file is only left behind if the rename failed
+	defer os.Remove(tmp.Name()) //nolint:errcheck // best effort cleanup
+
+	if _, err = tmp.Write(data); err != nil {
+		return errors.Join(err, tmp.Close())
+	}
+	if err = tmp.Sync(); err != nil {
+		return errors.Join(err, tmp.Close())
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
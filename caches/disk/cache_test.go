@@ -0,0 +1,331 @@
+//go:build !integration
+
+package disk_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/cachetest"
+	"github.com/dgduncan/go-cond-cache/caches/disk"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+func testTime() time.Time {
+	return time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)
+}
+
+// newCache returns a cache under dir closed at the end of the test.
+func newCache(t *testing.T, dir string, config *disk.Config, now func() time.Time) *disk.Cache {
+	t.Helper()
+
+	cache, err := disk.New(dir, config, now)
+	if err != nil {
+		t.Fatalf("failed to create the cache: %v", err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+
+	return cache
+}
+
+func newItem(response string) *gocondcache.CacheItem {
+	return &gocondcache.CacheItem{ETAG: `"` + response + `"`, Response: []byte(response), Expiration: testTime().Add(time.Hour)}
+}
+
+// files returns the files of the items stored under dir with the extension ext.
+func files(t *testing.T, dir, ext string) []string {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*", "*"+ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return matches
+}
+
+func TestConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T, now func() time.Time) gocondcache.Cache {
+		return newCache(t, t.TempDir(), nil, now)
+	})
+}
+
+func TestBoundedConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T, now func() time.Time) gocondcache.Cache {
+		return newCache(t, t.TempDir(), &disk.Config{MaxBytes: 4 << 20}, now)
+	})
+}
+
+func TestCompliance(t *testing.T) {
+	cachetest.RunCompliance(t, func(t *testing.T, now func() time.Time) gocondcache.Cache {
+		return newCache(t, t.TempDir(), nil, now)
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		dir         string
+		config      *disk.Config
+		expectedErr error
+	}{
+		{name: "empty directory", dir: "", expectedErr: caches.ValidationError{Reason: "empty directory"}},
+		{
+			name:        "negative max bytes",
+			dir:         t.TempDir(),
+			config:      &disk.Config{MaxBytes: -1},
+			expectedErr: caches.ValidationError{Reason: "negative max bytes"},
+		},
+		{name: "missing directory is created", dir: filepath.Join(t.TempDir(), "a", "b")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache, err := disk.New(tt.dir, tt.config, testTime)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err == nil {
+				_ = cache.Close()
+			}
+		})
+	}
+}
+
+func TestReopen(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	cache := newCache(t, dir, nil, testTime)
+	_ = cache.Set(ctx, "GET#https://example.com/", newItem("body"))
+	if err := cache.Close(); err != nil {
+		t.Fatalf("failed to close the cache: %v", err)
+	}
+
+	reopened := newCache(t, dir, nil, testTime)
+	if item, err := reopened.Get(ctx, "GET#https://example.com/"); err != nil || string(item.Response) != "body" {
+		t.Errorf("expected the item to survive the cache, got %+v and %v", item, err)
+	}
+}
+
+func TestUpdateKeepsBody(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	cache := newCache(t, dir, nil, testTime)
+	_ = cache.Set(ctx, "k", newItem("body"))
+
+	bodies := files(t, dir, ".body")
+	if len(bodies) != 1 || len(files(t, dir, ".meta")) != 1 {
+		t.Fatalf("expected a body and a sidecar, got %v", bodies)
+	}
+	before, _ := os.Stat(bodies[0])
+
+	if err := cache.Update(ctx, "k", testTime().Add(2*time.Hour)); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	// the body is the same file, only the sidecar was replaced
+	after, _ := os.Stat(bodies[0])
+	if !os.SameFile(before, after) || !after.ModTime().Equal(before.ModTime()) {
+		t.Error("expected Update not to rewrite the body")
+	}
+	if item, _ := cache.Get(ctx, "k"); !item.Expiration.Equal(testTime().Add(2 * time.Hour)) {
+		t.Errorf("expected the expiration to be updated, got %v", item.Expiration)
+	}
+}
+
+func TestTornItem(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		damage func(body, meta string) error
+	}{
+		{name: "body replaced", damage: func(body, _ string) error { return os.WriteFile(body, []byte("other"), 0o600) }},
+		{name: "body missing", damage: func(body, _ string) error { return os.Remove(body) }},
+		{name: "sidecar unreadable", damage: func(_, meta string) error { return os.WriteFile(meta, []byte("{"), 0o600) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			dir := t.TempDir()
+			cache := newCache(t, dir, nil, testTime)
+			_ = cache.Set(ctx, "k", newItem("body"))
+
+			if err := tt.damage(files(t, dir, ".body")[0], files(t, dir, ".meta")[0]); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := cache.Get(ctx, "k"); !errors.Is(err, caches.ErrNoCacheItem) {
+				t.Errorf("expected a torn item to be missing, got %v", err)
+			}
+			// the item can be stored again
+			_ = cache.Set(ctx, "k", newItem("body"))
+			if _, err := cache.Get(ctx, "k"); err != nil {
+				t.Errorf("expected the item to be stored again, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	clock := gocondcachetest.NewClock(testTime())
+
+	// measure the size of an item on disk
+	probeDir := t.TempDir()
+	_ = newCache(t, probeDir, nil, clock.Now).Set(ctx, "k-0", newItem("0123456789"))
+	var size int64
+	for _, path := range append(files(t, probeDir, ".body"), files(t, probeDir, ".meta")...) {
+		info, _ := os.Stat(path)
+		size += info.Size()
+	}
+
+	cache := newCache(t, dir, &disk.Config{MaxBytes: 10 * size}, clock.Now)
+	for i := range 10 {
+		_ = cache.Set(ctx, fmt.Sprintf("k-%d", i), newItem("0123456789"))
+		clock.Advance(time.Second)
+	}
+
+	// reading k-0 makes k-1 the least recently used item, and the cache is reduced to 90%
+	// of its limit, leaving room for the next writes
+	_, _ = cache.Get(ctx, "k-0")
+	clock.Advance(time.Second)
+	_ = cache.Set(ctx, "k-a", newItem("0123456789"))
+
+	for k, expectedErr := range map[string]error{
+		"k-0": nil,
+		"k-1": caches.ErrNoCacheItem,
+		"k-2": caches.ErrNoCacheItem,
+		"k-3": nil,
+		"k-a": nil,
+	} {
+		if _, err := cache.Get(ctx, k); !errors.Is(err, expectedErr) {
+			t.Errorf("Get(%q): expected %v, got %v", k, expectedErr, err)
+		}
+	}
+	_ = cache.Set(ctx, "k-b", newItem("0123456789"))
+	if bodies := files(t, dir, ".body"); len(bodies) != 10 {
+		t.Errorf("expected 10 items on disk, got %d", len(bodies))
+	}
+
+	// an item larger than the cache is not stored, and the one it replaces is removed
+	_ = cache.Set(ctx, "k-0", newItem(string(make([]byte, 10*size))))
+	if _, err := cache.Get(ctx, "k-0"); !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected the large item not to be stored, got %v", err)
+	}
+	if bodies := files(t, dir, ".body"); len(bodies) != 9 {
+		t.Errorf("expected 9 items on disk, got %d", len(bodies))
+	}
+}
+
+func TestPeekKeepsAccessTime(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	clock := gocondcachetest.NewClock(testTime())
+	cache := newCache(t, dir, &disk.Config{MaxBytes: 1 << 20}, clock.Now)
+
+	_ = cache.Set(ctx, "k", newItem("body"))
+	bodies := files(t, dir, ".body")
+	before, _ := os.Stat(bodies[0])
+
+	clock.Advance(time.Minute)
+	item, err := cache.Peek(ctx, "k")
+	if err != nil {
+		t.Fatalf("failed to peek: %v", err)
+	}
+	if item.ETAG != `"body"` || len(item.Response) != 0 {
+		t.Errorf("expected the metadata of the item without its response, got %+v", item)
+	}
+	if after, _ := os.Stat(bodies[0]); !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("expected the access time to be left at %v, got %v", before.ModTime(), after.ModTime())
+	}
+}
+
+func TestMaxBytesCountsExistingItems(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	clock := gocondcachetest.NewClock(testTime())
+
+	unbounded := newCache(t, dir, nil, clock.Now)
+	for i := range 10 {
+		_ = unbounded.Set(ctx, fmt.Sprintf("k-%d", i), newItem("0123456789"))
+		clock.Advance(time.Second)
+	}
+
+	// a bounded cache opened on the directory evicts the items stored before it
+	bounded := newCache(t, dir, &disk.Config{MaxBytes: 1024}, clock.Now)
+	_ = bounded.Set(ctx, "new", newItem("0123456789"))
+
+	if _, err := bounded.Get(ctx, "k-0"); !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected the oldest item to be evicted, got %v", err)
+	}
+	if _, err := bounded.Get(ctx, "new"); err != nil {
+		t.Errorf("expected the new item to be kept, got %v", err)
+	}
+}
+
+func TestSharedDirectory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// each cache holds its own lock file descriptor, as separate processes would
+	shared := []*disk.Cache{newCache(t, dir, nil, testTime), newCache(t, dir, nil, testTime)}
+
+	var wg sync.WaitGroup
+	for w, cache := range shared {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range 50 {
+				k := fmt.Sprintf("k-%d", i%10)
+				_ = cache.Set(ctx, k, newItem(fmt.Sprintf("%d-%d", w, i)))
+				if _, err := cache.Get(ctx, k); err != nil {
+					t.Errorf("Get(%q) returned error: %v", k, err)
+				}
+				_ = cache.Update(ctx, k, testTime().Add(2*time.Hour))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every item written by one cache is read whole by the other
+	for i := range 10 {
+		item, err := shared[1].Get(ctx, fmt.Sprintf("k-%d", i))
+		if err != nil || item.ETAG != `"`+string(item.Response)+`"` {
+			t.Errorf("expected a consistent item, got %+v and %v", item, err)
+		}
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*", "*.tmp-*")); len(tmp) != 0 {
+		t.Errorf("expected no temporary file left behind, got %v", tmp)
+	}
+}
@@ -0,0 +1,75 @@
+package disk
+
+import (
+	"os"
+	"sync"
+)
+
+// locker serializes the operations on a cache directory. Goroutines of a process are
+// serialized by a sync.RWMutex, and processes by a lock on a file of the directory,
+// which is held in shared mode while at least one goroutine reads.
+type locker struct {
+	mu   sync.RWMutex
+	file *os.File
+
+	readersMu sync.Mutex
+	readers   int
+}
+
+func newLocker(path string) (*locker, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600) //nolint:gosec // the path is within the cache directory
+	if err != nil {
+		return nil, err
+	}
+
+	return &locker{file: file}, nil
+}
+
+func (l *locker) rlock() error {
+	l.mu.RLock()
+
+	l.readersMu.Lock()
+	defer l.readersMu.Unlock()
+
+	if l.readers == 0 {
+		if err := lockFile(l.file, false); err != nil {
+			l.mu.RUnlock()
+			return err
+		}
+	}
+	l.readers++
+
+	return nil
+}
+
+func (l *locker) runlock() {
+	l.readersMu.Lock()
+	l.readers--
+	if l.readers == 0 {
+		_ = unlockFile(l.file)
+	}
+	l.readersMu.Unlock()
+
+	l.mu.RUnlock()
+}
+
+func (l *locker) lock() error {
+	l.mu.Lock()
+
+	if err := lockFile(l.file, true); err != nil {
+		l.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (l *locker) unlock() {
+	_ = unlockFile(l.file)
+
+	l.mu.Unlock()
+}
+
+func (l *locker) close() error {
+	return l.file.Close()
+}
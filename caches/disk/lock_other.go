@@ -0,0 +1,15 @@
+//go:build !unix
+
+package disk
+
+import "os"
+
+// lockFile does nothing on systems without flock, where only the goroutines of a
+// process are serialized.
+func lockFile(*os.File, bool) error {
+	return nil
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
@@ -2,6 +2,9 @@ package dynamodb
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
 	"strconv"
 	"time"
 
@@ -18,14 +21,29 @@ const (
 	DefaultReadCapacityUnits = 5
 	// DefaultWriteCapacityUnits is the default write capacity units for the DynamoDB table.
 	DefaultWriteCapacityUnits = 5
+	// DefaultTagIndex is the default name of the global secondary index on the tag attribute.
+	DefaultTagIndex = "tag-index"
 )
 
+// tagKeyPrefix starts the url of the items recording the tags of cache items.
+const tagKeyPrefix = "tag#"
+
+// MaxTags is the largest number of tags of a cache item. Update writes the item and its
+// tag items in one transaction, which DynamoDB limits to 100 writes.
+const MaxTags = 99
+
+// indexPollInterval is how often Migrate checks whether the tag index is ready.
+const indexPollInterval = time.Second
+
 // Config defines the configuration options for the DynamoDB cache implementation.
 type Config struct {
 	DeleteExpiredItems bool // Controls if a the expired_at TTL property is put in the database to allow automatic deletion of expired items
 
 	ItemExpiration time.Duration // How long a items stays valid in the database. This is independent of the expiration retrieved from the conditional response.
 	Table          string
+	TagIndex       string // Name of the global secondary index on the tag attribute, used by PurgeTag. Defaults to DefaultTagIndex.
+
+	Now func() time.Time // Returns the current time. Defaults to time.Now.
 }
 
 // Cache implements the gocondcache.Cache interface using Amazon DynamoDB as the storage backend.
@@ -35,6 +53,7 @@ type Cache struct {
 	client *dynamodb.Client
 
 	table      string
+	tagIndex   string
 	expiration time.Duration
 	now        func() time.Time
 }
@@ -47,49 +66,39 @@ type cacheItem struct {
 	ExpiredAt int64  `json:"expired_at" dynamodbav:"expired_at"`
 }
 
+// tagItem records that the cache item stored under Key was stored with Tag. Tag items
+// live in the cache table under a url of the form tag#<tag>#<key>, and are found by
+// querying the tag index.
+type tagItem struct {
+	URL       string `json:"url"        dynamodbav:"url"`
+	Tag       string `json:"tag"        dynamodbav:"tag"`
+	Key       string `json:"key"        dynamodbav:"key"`
+	ExpiredAt int64  `json:"expired_at" dynamodbav:"expired_at"`
+}
+
 // Get retrieves a cache item from DynamoDB by its key. It returns the cached item
 // if found and not expired, or an appropriate error otherwise.
+// Returns caches.ErrNoCacheItem if the item doesn't exist, and the item along with
+// caches.ErrCacheItemExpired if it has expired.
 func (c *Cache) Get(ctx context.Context, k string) (*gocondcache.CacheItem, error) {
-	key, err := attributevalue.Marshal(k)
+	_, ci, err := c.fetch(ctx, k)
 	if err != nil {
 		return nil, err
 	}
 
-	output, err := c.client.GetItem(ctx, &dynamodb.GetItemInput{
-		Key: map[string]types.AttributeValue{
-			"url": key,
-		},
-		ConsistentRead: aws.Bool(true),
-		TableName:      aws.String(c.table),
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	if output.Item == nil {
-		return nil, caches.ErrNoCacheItem
-	}
-
-	var item cacheItem
-	if marshalErr := attributevalue.UnmarshalMap(output.Item, &item); marshalErr != nil {
-		return nil, marshalErr
+	if c.now().UTC().After(ci.Expiration) {
+		return ci, caches.ErrCacheItemExpired
 	}
 
-	var ci gocondcache.CacheItem
-	if decErr := gobDecode(item.Response, &ci); decErr != nil {
-		return nil, decErr
-	}
-
-	if c.now().UTC().Unix() >= ci.Expiration.UTC().Unix() {
-		return &ci, caches.ErrCacheItemExpired
-	}
-
-	return &ci, nil
+	return ci, nil
 }
 
 // Set stores a new cache item in DynamoDB with the provided key and value.
 // It handles the serialization of the cache item and sets the appropriate timestamps.
 func (c *Cache) Set(ctx context.Context, k string, v *gocondcache.CacheItem) error {
+	if len(v.Tags) > MaxTags {
+		return fmt.Errorf("cache item has %d tags, at most %d are supported", len(v.Tags), MaxTags)
+	}
 	createdAt := c.now()
 
 	encItem, err := gobEncode(v)
@@ -114,40 +123,385 @@ func (c *Cache) Set(ctx context.Context, k string, v *gocondcache.CacheItem) err
 		Item:      av,
 	}
 
-	_, err = c.client.PutItem(ctx, &input)
-	return err
+	if _, err = c.client.PutItem(ctx, &input); err != nil {
+		return err
+	}
+
+	tagAVs, err := c.tagItems(k, v.Tags, i.ExpiredAt)
+	if err != nil {
+		return err
+	}
+	for _, tav := range tagAVs {
+		if _, err = c.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(c.table), Item: tav}); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Update modifies the expiration time of an existing cache item in DynamoDB.
 // This is typically used when a cached response is revalidated with the origin server.
+// The expired_at attribute of the tag items of the cache item is extended in the same
+// transaction, so that the DynamoDB TTL never removes them before the cache item.
+// Returns caches.ErrNoCacheItem if the item doesn't exist.
 func (c *Cache) Update(ctx context.Context, k string, expiration time.Time) error {
 	key, err := attributevalue.Marshal(k)
 	if err != nil {
 		return err
 	}
 
-	expirationString := strconv.FormatInt(expiration.UTC().Unix(), 10) // converting to UTC may not be
-	updatedAtString := strconv.FormatInt(c.now().UTC().Unix(), 10)
+	for {
+		item, ci, fetchErr := c.fetch(ctx, k)
+		if fetchErr != nil {
+			return fetchErr
+		}
+		ci.Expiration = expiration
+
+		encItem, encErr := gobEncode(ci)
+		if encErr != nil {
+			return encErr
+		}
 
-	_, err = c.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		updatedAt := c.now()
+		expiredAt := updatedAt.Add(c.expiration).Unix()
+		writes := []types.TransactWriteItem{{
+			Update: &types.Update{
+				TableName: aws.String(c.table),
+				Key: map[string]types.AttributeValue{
+					"url": key,
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":previous":   &types.AttributeValueMemberB{Value: item.Response},
+					":response":   &types.AttributeValueMemberB{Value: encItem},
+					":updated_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(updatedAt.Unix(), 10)},
+					":expired_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(expiredAt, 10)},
+				},
+				ConditionExpression: aws.String("response = :previous"),
+				UpdateExpression:    aws.String("SET response = :response, updated_at = :updated_at, expired_at = :expired_at"),
+			},
+		}}
+
+		// the tag items are written whole, which also restores those the TTL already removed
+		tagAVs, tagErr := c.tagItems(k, ci.Tags, expiredAt)
+		if tagErr != nil {
+			return tagErr
+		}
+		for _, tav := range tagAVs {
+			writes = append(writes, types.TransactWriteItem{Put: &types.Put{TableName: aws.String(c.table), Item: tav}})
+		}
+
+		_, err = c.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: writes})
+		if !conditionFailed(err) {
+			return err
+		}
+		// the item was replaced or removed since it was read, so it is read again
+	}
+}
+
+// Range calls f for every item stored in DynamoDB until f returns false.
+// It scans the whole table, so it should only be used for maintenance.
+func (c *Cache) Range(ctx context.Context, f func(k string, v *gocondcache.CacheItem) bool) error {
+	paginator := dynamodb.NewScanPaginator(c.client, &dynamodb.ScanInput{
+		TableName:        aws.String(c.table),
+		ConsistentRead:   aws.Bool(true),
+		FilterExpression: aws.String("attribute_not_exists(#tag)"),
+		ExpressionAttributeNames: map[string]string{
+			"#tag": "tag",
+		},
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, av := range page.Items {
+			var item cacheItem
+			if marshalErr := attributevalue.UnmarshalMap(av, &item); marshalErr != nil {
+				return marshalErr
+			}
+
+			var ci gocondcache.CacheItem
+			if decErr := gobDecode(item.Response, &ci); decErr != nil {
+				return decErr
+			}
+
+			if !f(item.URL, &ci) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the cache item stored under k from DynamoDB.
+func (c *Cache) Delete(ctx context.Context, k string) error {
+	key, err := attributevalue.Marshal(k)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
 		TableName: aws.String(c.table),
 		Key: map[string]types.AttributeValue{
 			"url": key,
 		},
+	})
+
+	return err
+}
+
+// PurgePrefix removes every cache item whose key starts with prefix from DynamoDB.
+// It scans the whole table, so it should only be used for maintenance.
+func (c *Cache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	return c.deleteMatching(ctx, "begins_with(#url, :v)", prefix, func(string) bool { return true })
+}
+
+// PurgeHost removes every cache item cached for host from DynamoDB.
+// It scans the whole table, so it should only be used for maintenance.
+func (c *Cache) PurgeHost(ctx context.Context, host string) (int, error) {
+	return c.deleteMatching(ctx, "contains(#url, :v)", host, func(k string) bool {
+		return caches.KeyHost(k) == host
+	})
+}
+
+// PurgeTag removes every cache item stored with tag from DynamoDB, found by querying
+// the tag index. Tag items left behind by a cache item that was since replaced without
+// the tag are removed without removing the cache item.
+func (c *Cache) PurgeTag(ctx context.Context, tag string) (int, error) {
+	paginator := dynamodb.NewQueryPaginator(c.client, &dynamodb.QueryInput{
+		TableName:              aws.String(c.table),
+		IndexName:              aws.String(c.tagIndex),
+		KeyConditionExpression: aws.String("#tag = :tag"),
+		ExpressionAttributeNames: map[string]string{
+			"#tag": "tag",
+		},
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":expired_at": &types.AttributeValueMemberS{
-				Value: expirationString,
+			":tag": &types.AttributeValueMemberS{Value: tag},
+		},
+	})
+
+	purged := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, av := range page.Items {
+			var ti tagItem
+			if marshalErr := attributevalue.UnmarshalMap(av, &ti); marshalErr != nil {
+				return purged, marshalErr
+			}
+
+			ci, getErr := c.Get(ctx, ti.Key)
+			switch {
+			case getErr == nil || errors.Is(getErr, caches.ErrCacheItemExpired):
+				if slices.Contains(ci.Tags, tag) {
+					if err = c.Delete(ctx, ti.Key); err != nil {
+						return purged, err
+					}
+					purged++
+				}
+			case !errors.Is(getErr, caches.ErrNoCacheItem):
+				return purged, getErr
+			}
+
+			if err = c.Delete(ctx, ti.URL); err != nil {
+				return purged, err
+			}
+		}
+	}
+
+	return purged, nil
+}
+
+// SoftPurge sets the expiration of the cache item stored under k to now, keeping it in
+// DynamoDB so it can be revalidated.
+// Returns caches.ErrNoCacheItem if the item doesn't exist.
+func (c *Cache) SoftPurge(ctx context.Context, k string) error {
+	return c.Update(ctx, k, c.now().UTC())
+}
+
+// Migrate prepares a table created by an earlier version of the cache, which had no tag
+// index, for PurgeTag: it adds the tag index to the table if it is missing, and waits
+// until DynamoDB has built it. Building the index of a large table can take minutes.
+func (c *Cache) Migrate(ctx context.Context) error {
+	for {
+		output, err := c.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(c.table)})
+		if err != nil {
+			return err
+		}
+
+		index := slices.IndexFunc(output.Table.GlobalSecondaryIndexes, func(i types.GlobalSecondaryIndexDescription) bool {
+			return aws.ToString(i.IndexName) == c.tagIndex
+		})
+		switch {
+		case index < 0:
+			if err = c.createTagIndex(ctx, output.Table); err != nil {
+				return err
+			}
+		case output.Table.GlobalSecondaryIndexes[index].IndexStatus == types.IndexStatusActive:
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(indexPollInterval):
+		}
+	}
+}
+
+// createTagIndex adds the tag index to table.
+func (c *Cache) createTagIndex(ctx context.Context, table *types.TableDescription) error {
+	create := &types.CreateGlobalSecondaryIndexAction{
+		IndexName: aws.String(c.tagIndex),
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("tag"),
+				KeyType:       types.KeyTypeHash,
 			},
-			":updated_at": &types.AttributeValueMemberS{
-				Value: updatedAtString,
+		},
+		Projection: &types.Projection{
+			ProjectionType: types.ProjectionTypeAll,
+		},
+	}
+	// tables billed on demand reject a provisioned throughput
+	if table.BillingModeSummary == nil || table.BillingModeSummary.BillingMode != types.BillingModePayPerRequest {
+		create.ProvisionedThroughput = &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(DefaultReadCapacityUnits),
+			WriteCapacityUnits: aws.Int64(DefaultWriteCapacityUnits),
+		}
+	}
+
+	_, err := c.client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName: aws.String(c.table),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("tag"),
+				AttributeType: types.ScalarAttributeTypeS,
 			},
 		},
-		UpdateExpression: aws.String("SET expired_at = :expired_at, updated_at = :updated_at"),
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{Create: create}},
 	})
 
 	return err
 }
 
+// tagItems returns the marshaled tag items recording the tags of the cache item stored
+// under k.
+func (c *Cache) tagItems(k string, tags []string, expiredAt int64) ([]map[string]types.AttributeValue, error) {
+	items := make([]map[string]types.AttributeValue, 0, len(tags))
+	for _, tag := range tags {
+		tav, err := attributevalue.MarshalMap(tagItem{
+			URL:       tagKeyPrefix + tag + "#" + k,
+			Tag:       tag,
+			Key:       k,
+			ExpiredAt: expiredAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, tav)
+	}
+
+	return items, nil
+}
+
+// conditionFailed reports whether err is a transaction canceled because the condition
+// on its first write failed.
+func conditionFailed(err error) bool {
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) || len(canceled.CancellationReasons) == 0 {
+		return false
+	}
+
+	return aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed"
+}
+
+// fetch returns the item stored under k along with the cache item it holds.
+// Returns caches.ErrNoCacheItem if the item doesn't exist.
+func (c *Cache) fetch(ctx context.Context, k string) (*cacheItem, *gocondcache.CacheItem, error) {
+	key, err := attributevalue.Marshal(k)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	output, err := c.client.GetItem(ctx, &dynamodb.GetItemInput{
+		Key: map[string]types.AttributeValue{
+			"url": key,
+		},
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String(c.table),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if output.Item == nil {
+		return nil, nil, caches.ErrNoCacheItem
+	}
+
+	var item cacheItem
+	if marshalErr := attributevalue.UnmarshalMap(output.Item, &item); marshalErr != nil {
+		return nil, nil, marshalErr
+	}
+
+	var ci gocondcache.CacheItem
+	if decErr := gobDecode(item.Response, &ci); decErr != nil {
+		return nil, nil, decErr
+	}
+
+	return &item, &ci, nil
+}
+
+// deleteMatching deletes the items selected by the scan filter, called with value
+// bound to :v and the url attribute to #url, for which match returns true.
+func (c *Cache) deleteMatching(ctx context.Context, filter string, value string, match func(k string) bool) (int, error) {
+	paginator := dynamodb.NewScanPaginator(c.client, &dynamodb.ScanInput{
+		TableName:            aws.String(c.table),
+		ConsistentRead:       aws.Bool(true),
+		FilterExpression:     aws.String(filter + " AND attribute_not_exists(#tag)"),
+		ProjectionExpression: aws.String("#url"),
+		ExpressionAttributeNames: map[string]string{
+			"#url": "url",
+			"#tag": "tag",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":v": &types.AttributeValueMemberS{Value: value},
+		},
+	})
+
+	purged := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, av := range page.Items {
+			var item cacheItem
+			if marshalErr := attributevalue.UnmarshalMap(av, &item); marshalErr != nil {
+				return purged, marshalErr
+			}
+			if !match(item.URL) {
+				continue
+			}
+
+			if err = c.Delete(ctx, item.URL); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
 // New creates a new DynamoDB cache instance with the provided configuration.
 // It validates the configuration and sets default values where appropriate.
 // Returns an error if the client is nil or if the configuration is invalid.
@@ -158,18 +512,34 @@ func New(client *dynamodb.Client, config *Config) (*Cache, error) {
 		}
 	}
 
+	c := Config{}
+	if config != nil {
+		c = *config
+	}
+
 	var itemExpiration time.Duration
-	if config.ItemExpiration == 0 {
+	if c.ItemExpiration == 0 {
 		itemExpiration = caches.DefaultExpiredDuration
 	} else {
-		itemExpiration = config.ItemExpiration
+		itemExpiration = c.ItemExpiration
+	}
+
+	tagIndex := c.TagIndex
+	if tagIndex == "" {
+		tagIndex = DefaultTagIndex
+	}
+
+	now := c.Now
+	if now == nil {
+		now = time.Now
 	}
 
 	return &Cache{
 		client: client,
 
-		table:      config.Table,
+		table:      c.Table,
+		tagIndex:   tagIndex,
 		expiration: itemExpiration,
-		now:        time.Now,
+		now:        now,
 	}, nil
 }
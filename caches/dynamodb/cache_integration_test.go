@@ -4,6 +4,8 @@ package dynamodb
 
 import (
 	"context"
+	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,15 +13,22 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches/cachetest"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
 	"github.com/stretchr/testify/assert"
 )
 
 const (
-	tableName = "test-table" //nolint:unused
+	tableName = "test"
+	endpoint  = "http://localhost:8000"
 )
 
-func setup(t *testing.T) (*dynamodb.Client, error) {
+// tables numbers the tables created by newCache.
+var tables atomic.Int32
+
+func newClient(t *testing.T) (*dynamodb.Client, error) {
 	t.Setenv("AWS_ACCESS_KEY_ID", "DUMMYIDEXAMPLE")
 	t.Setenv("AWS_SECRET_ACCESS_KEY", "DUMMYEXAMPLEKEY")
 
@@ -28,9 +37,18 @@ func setup(t *testing.T) (*dynamodb.Client, error) {
 		return nil, err
 	}
 
-	c := dynamodb.NewFromConfig(awsconfig)
+	return dynamodb.NewFromConfig(awsconfig, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	}), nil
+}
+
+func setup(t *testing.T) (*dynamodb.Client, error) {
+	c, err := newClient(t)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := createTable(context.Background(), c); err != nil {
+	if err := createTable(context.Background(), c, tableName); err != nil {
 		return nil, err
 	}
 
@@ -80,7 +98,7 @@ func putCacheItem(_ *testing.T, c *dynamodb.Client) error {
 	}
 
 	input := dynamodb.PutItemInput{
-		TableName: aws.String("test"),
+		TableName: aws.String(tableName),
 		Item:      av,
 	}
 
@@ -126,8 +144,8 @@ func TestGetIntegration(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx := context.Background()
 
-			d, err := New(ctx, c, &Config{
-				Table: "test",
+			d, err := New(c, &Config{
+				Table: tableName,
 
 				ItemExpiration: 1 * time.Minute,
 			})
@@ -150,3 +168,114 @@ func TestGetIntegration(t *testing.T) {
 		})
 	}
 }
+
+func newCache(t *testing.T, now func() time.Time) gocondcache.Cache {
+	ctx := context.Background()
+
+	c, err := newClient(t)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	table := fmt.Sprintf("conformance-%d", tables.Add(1))
+	if err = createTable(ctx, c, table); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = c.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(table)})
+	})
+
+	d, err := New(c, &Config{Table: table, Now: now})
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	return d
+}
+
+func TestConformanceIntegration(t *testing.T) {
+	cachetest.RunConformance(t, newCache)
+}
+
+func TestComplianceIntegration(t *testing.T) {
+	cachetest.RunCompliance(t, newCache)
+}
+
+func TestUpdateExtendsTagItemsIntegration(t *testing.T) {
+	ctx := context.Background()
+	clock := gocondcachetest.NewClock(time.Now())
+	d := newCache(t, clock.Now).(*Cache)
+
+	k := "GET#https://example.com/"
+	err := d.Set(ctx, k, &gocondcache.CacheItem{ETAG: "etag", Expiration: clock.Now(), Tags: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("Set returned error: %v", err)
+	}
+	clock.Advance(time.Hour)
+	if err = d.Update(ctx, k, clock.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	item, _, err := d.fetch(ctx, k)
+	if err != nil {
+		t.Fatalf("failed to read the cache item: %v", err)
+	}
+	for _, tag := range []string{"a", "b"} {
+		url := &types.AttributeValueMemberS{Value: tagKeyPrefix + tag + "#" + k}
+		output, getErr := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(d.table),
+			Key:            map[string]types.AttributeValue{"url": url},
+			ConsistentRead: aws.Bool(true),
+		})
+		if getErr != nil {
+			t.Fatalf("failed to read the tag item: %v", getErr)
+		}
+		var ti tagItem
+		_ = attributevalue.UnmarshalMap(output.Item, &ti)
+		if ti.ExpiredAt != item.ExpiredAt {
+			t.Errorf("expected tag item %q to expire with the cache item at %d, got %d",
+				tag, item.ExpiredAt, ti.ExpiredAt)
+		}
+	}
+}
+
+func TestMigrateIntegration(t *testing.T) {
+	ctx := context.Background()
+	c, err := newClient(t)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// a table created before the tag index existed
+	table := fmt.Sprintf("legacy-%d", tables.Add(1))
+	_, err = c.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("url"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema:   []types.KeySchemaElement{{AttributeName: aws.String("url"), KeyType: types.KeyTypeHash}},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = c.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(table)})
+	})
+
+	d, err := New(c, &Config{Table: table})
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	_ = d.Set(ctx, "k", &gocondcache.CacheItem{ETAG: "etag", Expiration: time.Now().Add(time.Hour), Tags: []string{"a"}})
+
+	// migrating twice is a no-op the second time
+	for range 2 {
+		if err = d.Migrate(ctx); err != nil {
+			t.Fatalf("Migrate returned error: %v", err)
+		}
+	}
+	if purged, purgeErr := d.PurgeTag(ctx, "a"); purgeErr != nil || purged != 1 {
+		t.Errorf("expected the tagged item to be purged, got %d and %v", purged, purgeErr)
+	}
+}
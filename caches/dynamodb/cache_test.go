@@ -53,21 +53,24 @@ func TestNewDynamoDBCache(t *testing.T) {
 			expectedCache: &Cache{
 				client:     &dynamodb.Client{},
 				table:      tableName,
+				tagIndex:   DefaultTagIndex,
 				expiration: caches.DefaultExpiredDuration,
 				now:        testingTime,
 			},
 			expectedErr: nil,
 		},
 		{
-			name:   "custom item expiration",
+			name:   "custom item expiration and tag index",
 			client: &dynamodb.Client{},
 			config: &Config{
 				Table:          tableName,
 				ItemExpiration: time.Hour,
+				TagIndex:       "custom-index",
 			},
 			expectedCache: &Cache{
 				client:     &dynamodb.Client{},
 				table:      tableName,
+				tagIndex:   "custom-index",
 				expiration: time.Hour,
 				now:        testingTime,
 			},
@@ -81,7 +84,7 @@ func TestNewDynamoDBCache(t *testing.T) {
 
 			cache, err := New(tt.client, tt.config)
 
-			if errors.Is(err, tt.expectedErr) {
+			if !errors.Is(err, tt.expectedErr) {
 				t.Errorf("expected error %v, got %v", tt.expectedErr, err)
 			}
 
@@ -96,6 +99,10 @@ func TestNewDynamoDBCache(t *testing.T) {
 				t.Errorf("expected table %s, got %s", tt.expectedCache.table, cache.table)
 			}
 
+			if cache.tagIndex != tt.expectedCache.tagIndex {
+				t.Errorf("expected tag index %s, got %s", tt.expectedCache.tagIndex, cache.tagIndex)
+			}
+
 			if cache.expiration != tt.expectedCache.expiration {
 				t.Errorf("expected expiration %v, got %v", tt.expectedCache.expiration, cache.expiration)
 			}
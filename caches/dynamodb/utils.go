@@ -10,14 +10,19 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
-func createTable(ctx context.Context, client *dynamodb.Client) error { //nolint:unused // not used at the moment
+// createTable creates a cache table named table, along with its tag index.
+func createTable(ctx context.Context, client *dynamodb.Client, table string) error { //nolint:unused // only used by the integration tests
 	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
-		TableName: aws.String("test"),
+		TableName: aws.String(table),
 		AttributeDefinitions: []types.AttributeDefinition{
 			{
 				AttributeName: aws.String("url"),
 				AttributeType: types.ScalarAttributeTypeS,
 			},
+			{
+				AttributeName: aws.String("tag"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
 		},
 		KeySchema: []types.KeySchemaElement{
 			{
@@ -25,6 +30,24 @@ func createTable(ctx context.Context, client *dynamodb.Client) error { //nolint:
 				KeyType:       types.KeyTypeHash,
 			},
 		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String(DefaultTagIndex),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("tag"),
+						KeyType:       types.KeyTypeHash,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+				ProvisionedThroughput: &types.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(DefaultReadCapacityUnits),
+					WriteCapacityUnits: aws.Int64(DefaultWriteCapacityUnits),
+				},
+			},
+		},
 		ProvisionedThroughput: &types.ProvisionedThroughput{
 			ReadCapacityUnits:  aws.Int64(DefaultReadCapacityUnits),
 			WriteCapacityUnits: aws.Int64(DefaultWriteCapacityUnits),
@@ -10,6 +10,18 @@ var (
 	ErrCacheItemExpired = errors.New("cache item expired")
 	// ErrNoCacheItem is returned when the key is not found in the cache.
 	ErrNoCacheItem = errors.New("no value found in cache")
+	// ErrCircuitOpen is returned when calls to a failing cache are short-circuited.
+	ErrCircuitOpen = errors.New("cache circuit breaker is open")
+	// ErrWriteQueueFull is returned when a write is dropped because the write-behind queue is full.
+	ErrWriteQueueFull = errors.New("write-behind queue is full")
+	// ErrNotSupported is returned when a cache does not implement an optional operation.
+	ErrNotSupported = errors.New("operation not supported by cache")
+	// ErrCacheClosed is returned when a write is attempted on a cache that has been closed.
+	ErrCacheClosed = errors.New("cache is closed")
+	// ErrInvalidExport is returned when importing data that is not a valid cache export.
+	ErrInvalidExport = errors.New("invalid cache export")
+	// ErrInvalidSnapshot is returned when loading a file that is not a valid cache snapshot.
+	ErrInvalidSnapshot = errors.New("invalid cache snapshot")
 )
 
 // ValidationError represents an validation error on the initial creation of a cache.
@@ -0,0 +1,108 @@
+package local
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// task is a goroutine running a function periodically, such as the janitor removing
+// the expired items of a cache.
+type task struct {
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// startTask calls run every interval until the returned task is stopped.
+func startTask(interval time.Duration, run func()) *task {
+	j := &task{stop: make(chan struct{}), done: make(chan struct{})}
+
+	go func() {
+		defer close(j.done)
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-t.C:
+				run()
+			}
+		}
+	}()
+
+	return j
+}
+
+// close stops the task, if any, and waits for it to return.
+func (j *task) close() {
+	if j == nil {
+		return
+	}
+
+	j.stopOnce.Do(func() { close(j.stop) })
+	<-j.done
+}
+
+// RemoveExpired removes the items whose expiration is more than Config.RetentionGrace in
+// the past, as the janitor does every Config.ExpiredTaskTimer, and returns how many were
+// removed. Items are looked up under the read lock, so that readers are only blocked
+// while they are removed.
+func (bc *BasicCache) RemoveExpired() int {
+	bc.lock.RLock()
+	cutoff := bc.now().UTC().Add(-bc.retention)
+	var expired []string
+	for k, v := range bc.cache {
+		if cutoff.After(v.Expiration) {
+			expired = append(expired, k)
+		}
+	}
+	bc.lock.RUnlock()
+
+	if len(expired) == 0 {
+		return 0
+	}
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	removed := 0
+	for _, k := range expired {
+		// the item may have been replaced since it was looked up
+		if v, found := bc.cache[k]; found && cutoff.After(v.Expiration) {
+			bc.remove(k)
+			removed++
+		}
+	}
+	bc.usage.expired += uint64(removed) //nolint:gosec // never negative
+
+	return removed
+}
+
+// RemoveExpired removes the expired items of every shard, as BasicCache.RemoveExpired
+// does, and returns how many were removed.
+func (sc *ShardedCache) RemoveExpired() int {
+	removed := 0
+	for _, shard := range sc.shards {
+		removed += shard.RemoveExpired()
+	}
+
+	return removed
+}
+
+// Close stops the janitor removing expired items and the periodic snapshots, if they
+// run, and waits for them to return. If the cache is configured with a snapshot path, it
+// is then saved a last time. The cache can still be used once closed.
+func (bc *BasicCache) Close() error {
+	bc.janitor.close()
+	bc.snapshotter.close()
+
+	if bc.snapshotPath == "" {
+		return nil
+	}
+
+	return bc.SaveSnapshot(context.Background(), bc.snapshotPath)
+}
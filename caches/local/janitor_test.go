@@ -0,0 +1,85 @@
+//go:build !integration
+
+package local_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	local "github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+func TestJanitor(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := gocondcachetest.NewClock(testTime())
+	// the janitor never ticks during the test, the sweeps are triggered by it
+	cache := local.NewBasicCacheWithTimeFunc(clock.Now, local.WithJanitor(time.Hour, time.Minute))
+	defer cache.Close()
+
+	expirations := map[string]time.Duration{
+		"past grace":   -2 * time.Minute,
+		"within grace": -30 * time.Second,
+		"fresh":        time.Hour,
+	}
+	for k, d := range expirations {
+		_ = cache.Set(ctx, k, &gocondcache.CacheItem{Expiration: testTime().Add(d), Tags: []string{"t"}})
+	}
+
+	if removed := cache.RemoveExpired(); removed != 1 {
+		t.Fatalf("expected 1 item removed, got %d", removed)
+	}
+	if _, err := cache.Get(ctx, "past grace"); !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected the item past the grace to be removed, got %v", err)
+	}
+	// items within the grace are kept so that they can be revalidated
+	if _, err := cache.Get(ctx, "within grace"); !errors.Is(err, caches.ErrCacheItemExpired) {
+		t.Errorf("expected the item within the grace to be kept, got %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if removed := cache.RemoveExpired(); removed != 1 {
+		t.Fatalf("expected 1 more item removed, got %d", removed)
+	}
+	if _, err := cache.Get(ctx, "within grace"); !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected the item to be removed once past the grace, got %v", err)
+	}
+	if _, err := cache.Get(ctx, "fresh"); err != nil {
+		t.Errorf("expected the fresh item to be kept, got %v", err)
+	}
+	if stats := cache.Stats(); stats.Entries != 1 || stats.Expired != 2 || stats.Bytes != int64(len("fresh")+local.EntryOverhead) {
+		t.Errorf("expected the removed items to be accounted for, got %+v", stats)
+	}
+}
+
+func TestJanitorClose(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := gocondcachetest.NewClock(testTime())
+	cache := local.NewBasicCacheWithTimeFunc(clock.Now, local.WithJanitor(time.Hour, 0))
+
+	if err := cache.Close(); err != nil {
+		t.Fatalf("failed to close the cache: %v", err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("expected Close to be idempotent, got %v", err)
+	}
+
+	// expired items can still be removed once the janitor is stopped
+	_ = cache.Set(ctx, "a", &gocondcache.CacheItem{Expiration: testTime().Add(-time.Hour)})
+	if removed := cache.RemoveExpired(); removed != 1 {
+		t.Errorf("expected the expired item to be removed, got %d", removed)
+	}
+
+	// caches without a janitor can be closed too
+	if err := local.New(nil, nil).Close(); err != nil {
+		t.Errorf("expected Close without a janitor to succeed, got %v", err)
+	}
+}
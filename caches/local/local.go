@@ -2,7 +2,7 @@ package local
 
 import (
 	"context"
-	"errors"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,37 +10,212 @@ import (
 	"github.com/dgduncan/go-cond-cache/caches"
 )
 
-type BasicCache struct {
-	cache map[string]*gocondcache.CacheItem
-	now   func() time.Time
-	lock  *sync.RWMutex
+// Admission selects how a bounded BasicCache chooses the items it keeps.
+type Admission int
+
+const (
+	// AdmitAll stores every new item, evicting the least recently used items.
+	AdmitAll Admission = iota
+
+	// AdmitTinyLFU is the W-TinyLFU policy. New items are kept in a window holding 1% of
+	// the limits, and only admitted beyond it if their estimated read frequency is
+	// higher than the one of the item they would evict, so that a sweep over many items
+	// read once does not push out the items read often.
+	AdmitTinyLFU
+)
+
+// Config defines the configuration options of a BasicCache.
+type Config struct {
+	// MaxEntries is the maximum number of items stored. When it is exceeded, items are
+	// evicted as chosen by Admission. Zero means no limit.
+	MaxEntries int
+
+	// MaxBytes is the maximum size of the items stored, each counted as the length of
+	// its key and response plus EntryOverhead. When it is exceeded, items are evicted as
+	// chosen by Admission, and an item larger than MaxBytes is not stored at all. Zero
+	// means no limit.
+	MaxBytes int64
+
+	// Admission is the policy choosing the items evicted when a limit is exceeded.
+	// Defaults to AdmitAll.
+	Admission Admission
+
+	// OnEvict, when set, is called with every item evicted to respect a limit, after the
+	// cache lock is released.
+	OnEvict func(key string, item *gocondcache.CacheItem)
+
+	// DeleteExpiredItems starts a janitor goroutine removing the items whose expiration
+	// is more than RetentionGrace in the past. It runs until Close is called.
+	DeleteExpiredItems bool
+
+	// ExpiredTaskTimer defines the interval at which the janitor runs. Defaults to
+	// caches.DefaultExpiredTaskTimer.
+	ExpiredTaskTimer time.Duration
+
+	// RetentionGrace is how long the janitor keeps items past their expiration, so that
+	// they can still be revalidated with a conditional request instead of fetched again.
+	RetentionGrace time.Duration
+
+	// SnapshotPath, when set, is the file the cache is saved to every SnapshotInterval
+	// and on Close, and loaded from when it is created, so that its items survive a
+	// restart. Items past RetentionGrace are not loaded.
+	SnapshotPath string
+
+	// SnapshotInterval defines the interval at which the snapshot is saved. Defaults to
+	// DefaultSnapshotInterval.
+	SnapshotInterval time.Duration
 }
 
-func NewBasicCache() BasicCache {
-	return BasicCache{
-		cache: make(map[string]*gocondcache.CacheItem),
-		now:   time.Now,
-		lock:  &sync.RWMutex{},
+func (c *Config) expiredTaskTimer() time.Duration {
+	if c.ExpiredTaskTimer > 0 {
+		return c.ExpiredTaskTimer
 	}
+
+	return caches.DefaultExpiredTaskTimer
+}
+
+// Stats holds the size and eviction counters of a BasicCache.
+type Stats struct {
+	Entries      int    // items stored
+	Bytes        int64  // size of the items stored, as counted for Config.MaxBytes
+	Evictions    uint64 // items evicted to respect a limit
+	EvictedBytes uint64 // size of the items evicted
+	Expired      uint64 // items removed by the janitor
+}
+
+// usage holds the counters reported by Stats. It is guarded by the cache lock.
+type usage struct {
+	bytes        int64
+	evictions    uint64
+	evictedBytes uint64
+	expired      uint64
+}
+
+func (u *usage) evicted(size int64) {
+	u.evictions++
+	u.evictedBytes += uint64(size) //nolint:gosec // sizes are never negative
+}
+
+// evictedItem is an item removed by a write, reported to Config.OnEvict.
+type evictedItem struct {
+	key  string
+	item *gocondcache.CacheItem
+}
+
+type BasicCache struct {
+	cache    map[string]*gocondcache.CacheItem
+	tags     map[string]map[string]struct{} // keys of the items stored with each tag
+	policy   policy                         // nil for an unbounded cache
+	maxBytes int64
+	usage    *usage
+	onEvict  func(key string, item *gocondcache.CacheItem)
+
+	janitor      *task // nil if expired items are not removed
+	retention    time.Duration
+	snapshotter  *task // nil if the cache is not saved
+	snapshotPath string
+
+	now  func() time.Time
+	lock *sync.RWMutex
 }
 
-func NewBasicCacheWithTimeFunc(now func() time.Time) BasicCache {
+// New returns a BasicCache bounded by config. A nil config returns an unbounded cache,
+// and a nil now defaults to time.Now. If config.DeleteExpiredItems or
+// config.SnapshotPath is set, Close must be called to stop the background goroutines
+// once the cache is no longer used.
+func New(config *Config, now func() time.Time) *BasicCache {
+	c := Config{}
+	if config != nil {
+		c = *config
+	}
+
 	nowFunc := now
 	if nowFunc == nil {
 		nowFunc = time.Now
 	}
 
-	return BasicCache{
-		cache: make(map[string]*gocondcache.CacheItem),
-		now:   nowFunc,
-		lock:  &sync.RWMutex{},
+	var p policy
+	switch {
+	case c.MaxEntries <= 0 && c.MaxBytes <= 0:
+	case c.Admission == AdmitTinyLFU:
+		p = newTinyLFUPolicy(c.MaxEntries, c.MaxBytes)
+	default:
+		p = newLRUPolicy(c.MaxEntries, c.MaxBytes)
+	}
+
+	bc := &BasicCache{
+		cache:        make(map[string]*gocondcache.CacheItem),
+		tags:         make(map[string]map[string]struct{}),
+		policy:       p,
+		maxBytes:     c.MaxBytes,
+		usage:        &usage{},
+		onEvict:      c.OnEvict,
+		retention:    c.RetentionGrace,
+		snapshotPath: c.SnapshotPath,
+		now:          nowFunc,
+		lock:         &sync.RWMutex{},
 	}
+
+	if c.DeleteExpiredItems {
+		bc.janitor = startTask(c.expiredTaskTimer(), func() { bc.RemoveExpired() })
+	}
+	bc.snapshotter = snapshots(bc, &c, nowFunc)
+
+	return bc
+}
+
+// Option configures a cache created by NewBasicCacheWithTimeFunc.
+type Option func(*Config)
+
+// WithJanitor starts a janitor removing, every interval, the items whose expiration is
+// more than retentionGrace in the past. A zero interval defaults to
+// caches.DefaultExpiredTaskTimer. Close must be called to stop it.
+func WithJanitor(interval, retentionGrace time.Duration) Option {
+	return func(c *Config) {
+		c.DeleteExpiredItems = true
+		c.ExpiredTaskTimer = interval
+		c.RetentionGrace = retentionGrace
+	}
+}
+
+func NewBasicCache() BasicCache {
+	return *New(nil, nil)
+}
+
+func NewBasicCacheWithTimeFunc(now func() time.Time, options ...Option) BasicCache {
+	var c Config
+	for _, option := range options {
+		option(&c)
+	}
+
+	return *New(&c, now)
 }
 
 func (bc *BasicCache) Get(_ context.Context, key string) (*gocondcache.CacheItem, error) {
+	// a bounded cache records every read in its policy
+	if bc.policy != nil {
+		bc.lock.Lock()
+		defer bc.lock.Unlock()
+		bc.policy.access(key)
+	} else {
+		bc.lock.RLock()
+		defer bc.lock.RUnlock()
+	}
+
+	return bc.lookup(key)
+}
+
+// Peek retrieves an item from the cache like Get, without recording the access in the
+// admission policy.
+func (bc *BasicCache) Peek(_ context.Context, key string) (*gocondcache.CacheItem, error) {
 	bc.lock.RLock()
 	defer bc.lock.RUnlock()
 
+	return bc.lookup(key)
+}
+
+// lookup returns the item stored under key. The lock must be held.
+func (bc *BasicCache) lookup(key string) (*gocondcache.CacheItem, error) {
 	val, found := bc.cache[key]
 	if !found {
 		return nil, caches.ErrNoCacheItem
@@ -53,25 +228,195 @@ func (bc *BasicCache) Get(_ context.Context, key string) (*gocondcache.CacheItem
 	return val, nil
 }
 
+// Set stores item under key, evicting items as chosen by the admission policy if a limit
+// of the cache is exceeded.
 func (bc *BasicCache) Set(_ context.Context, key string, item *gocondcache.CacheItem) error {
+	bc.notify(bc.set(key, item))
+
+	return nil
+}
+
+func (bc *BasicCache) set(key string, item *gocondcache.CacheItem) []evictedItem {
 	bc.lock.Lock()
 	defer bc.lock.Unlock()
 
+	bc.remove(key)
+
+	size := entrySize(key, item)
+	if bc.maxBytes > 0 && size > bc.maxBytes {
+		bc.usage.evicted(size)
+		return []evictedItem{{key: key, item: item}}
+	}
+
 	bc.cache[key] = item
+	bc.usage.bytes += size
+	for _, tag := range item.Tags {
+		if bc.tags[tag] == nil {
+			bc.tags[tag] = make(map[string]struct{})
+		}
+		bc.tags[tag][key] = struct{}{}
+	}
 
-	return nil
+	if bc.policy == nil {
+		return nil
+	}
+
+	var evicted []evictedItem
+	for _, k := range bc.policy.add(key, size) {
+		evicted = append(evicted, evictedItem{key: k, item: bc.cache[k]})
+		bc.usage.evicted(bc.remove(k))
+	}
+
+	return evicted
+}
+
+// notify reports evicted items to the OnEvict callback. The lock must not be held.
+func (bc *BasicCache) notify(evicted []evictedItem) {
+	if bc.onEvict == nil {
+		return
+	}
+
+	for _, e := range evicted {
+		bc.onEvict(e.key, e.item)
+	}
 }
 
 func (bc *BasicCache) Update(_ context.Context, key string, expiration time.Time) error {
-	ctx := context.TODO()
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	item, found := bc.cache[key]
+	if !found {
+		return caches.ErrNoCacheItem
+	}
+
+	// the item may still be in use by a reader, so a copy is stored instead
+	updated := *item
+	updated.Expiration = expiration
+	bc.cache[key] = &updated
+	if bc.policy != nil {
+		bc.policy.refresh(key)
+	}
+
+	return nil
+}
+
+// Range calls f for every item in the cache until f returns false. Items are collected
+// before f is first called, so f may safely use the cache.
+func (bc *BasicCache) Range(ctx context.Context, f func(k string, v *gocondcache.CacheItem) bool) error {
+	bc.lock.RLock()
+	keys := make([]string, 0, len(bc.cache))
+	items := make([]*gocondcache.CacheItem, 0, len(bc.cache))
+	for k, v := range bc.cache {
+		keys = append(keys, k)
+		items = append(items, v)
+	}
+	bc.lock.RUnlock()
 
-	// NOTE : this may cause a race condition because of the use of a double lock compared to a larger single lock
-	// that encompasses the read and write operations
-	item, err := bc.Get(ctx, key)
-	if err != nil && !errors.Is(err, caches.ErrCacheItemExpired) {
-		return err
+	for i := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !f(keys[i], items[i]) {
+			return nil
+		}
 	}
-	item.Expiration = expiration
 
-	return bc.Set(ctx, key, item)
+	return nil
+}
+
+func (bc *BasicCache) Delete(_ context.Context, key string) error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.remove(key)
+
+	return nil
+}
+
+// PurgePrefix removes every item whose key starts with prefix.
+func (bc *BasicCache) PurgePrefix(_ context.Context, prefix string) (int, error) {
+	return bc.purge(func(k string) bool {
+		return strings.HasPrefix(k, prefix)
+	}), nil
+}
+
+// PurgeHost removes every item cached for host.
+func (bc *BasicCache) PurgeHost(_ context.Context, host string) (int, error) {
+	return bc.purge(func(k string) bool {
+		return caches.KeyHost(k) == host
+	}), nil
+}
+
+// SoftPurge sets the expiration of the item stored under key to now.
+func (bc *BasicCache) SoftPurge(ctx context.Context, key string) error {
+	return bc.Update(ctx, key, bc.now().UTC())
+}
+
+func (bc *BasicCache) purge(match func(k string) bool) int {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	purged := 0
+	for k := range bc.cache {
+		if match(k) {
+			bc.remove(k)
+			purged++
+		}
+	}
+
+	return purged
+}
+
+// PurgeTag removes every item stored with tag.
+func (bc *BasicCache) PurgeTag(_ context.Context, tag string) (int, error) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	keys := bc.tags[tag]
+	purged := len(keys)
+	for k := range keys {
+		bc.remove(k)
+	}
+
+	return purged, nil
+}
+
+// Stats returns the size and eviction counters of the cache.
+func (bc *BasicCache) Stats() Stats {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return Stats{
+		Entries:      len(bc.cache),
+		Bytes:        bc.usage.bytes,
+		Evictions:    bc.usage.evictions,
+		EvictedBytes: bc.usage.evictedBytes,
+		Expired:      bc.usage.expired,
+	}
+}
+
+// remove removes the item stored under key from the cache and its indexes, and returns
+// its size. The lock must be held for writing.
+func (bc *BasicCache) remove(key string) int64 {
+	item, found := bc.cache[key]
+	if !found {
+		return 0
+	}
+
+	for _, tag := range item.Tags {
+		delete(bc.tags[tag], key)
+		if len(bc.tags[tag]) == 0 {
+			delete(bc.tags, tag)
+		}
+	}
+	delete(bc.cache, key)
+	if bc.policy != nil {
+		bc.policy.remove(key)
+	}
+
+	size := entrySize(key, item)
+	bc.usage.bytes -= size
+
+	return size
 }
@@ -5,7 +5,10 @@ package local_test
 import (
 	"reflect"
 	"testing"
+	"time"
 
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches/cachetest"
 	local "github.com/dgduncan/go-cond-cache/caches/local"
 )
 
@@ -24,3 +27,17 @@ func TestNewBasicCache(t *testing.T) {
 		})
 	}
 }
+
+func TestConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(_ *testing.T, now func() time.Time) gocondcache.Cache {
+		cache := local.NewBasicCacheWithTimeFunc(now)
+		return &cache
+	})
+}
+
+func TestCompliance(t *testing.T) {
+	cachetest.RunCompliance(t, func(_ *testing.T, now func() time.Time) gocondcache.Cache {
+		cache := local.NewBasicCacheWithTimeFunc(now)
+		return &cache
+	})
+}
@@ -0,0 +1,116 @@
+package local
+
+import (
+	"container/list"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+)
+
+// EntryOverhead is the number of bytes each item is counted for in addition to the
+// length of its key and response, approximating its metadata and bookkeeping.
+const EntryOverhead = 256
+
+// policy tracks the items of a bounded BasicCache and chooses the ones to evict. It is
+// guarded by the cache lock.
+type policy interface {
+	// access records a read of key, whether an item is stored under it or not.
+	access(key string)
+	// refresh marks the item stored under key as recently used, without counting a read.
+	refresh(key string)
+	// add tracks an item newly stored under key and returns the keys of the items to
+	// evict for the cache to respect its limits, which may include key itself.
+	add(key string, size int64) []string
+	remove(key string)
+}
+
+// policyEntry is an item tracked by a policy.
+type policyEntry struct {
+	key     string
+	size    int64
+	segment *segment
+}
+
+// segment is a list of items, most recently used first, with its own limits.
+type segment struct {
+	maxEntries int
+	maxBytes   int64
+
+	order *list.List
+	bytes int64
+}
+
+func newSegment(maxEntries int, maxBytes int64) *segment {
+	return &segment{maxEntries: maxEntries, maxBytes: maxBytes, order: list.New()}
+}
+
+// over reports whether the items of s exceed one of its limits. A zero limit is no limit.
+func (s *segment) over() bool {
+	return (s.maxEntries > 0 && s.order.Len() > s.maxEntries) || (s.maxBytes > 0 && s.bytes > s.maxBytes)
+}
+
+func (s *segment) pushFront(e *policyEntry) *list.Element {
+	e.segment = s
+	s.bytes += e.size
+	return s.order.PushFront(e)
+}
+
+func (s *segment) remove(elem *list.Element) *policyEntry {
+	e := entryOf(elem)
+	s.bytes -= e.size
+	s.order.Remove(elem)
+	return e
+}
+
+// back returns the least recently used item of s, or nil if s is empty.
+func (s *segment) back() *list.Element {
+	return s.order.Back()
+}
+
+func entryOf(elem *list.Element) *policyEntry {
+	e, _ := elem.Value.(*policyEntry)
+	return e
+}
+
+// lruPolicy evicts the least recently used items.
+type lruPolicy struct {
+	items *segment
+	elems map[string]*list.Element
+}
+
+func newLRUPolicy(maxEntries int, maxBytes int64) *lruPolicy {
+	return &lruPolicy{items: newSegment(maxEntries, maxBytes), elems: make(map[string]*list.Element)}
+}
+
+func (p *lruPolicy) access(key string) {
+	p.refresh(key)
+}
+
+func (p *lruPolicy) refresh(key string) {
+	if elem, found := p.elems[key]; found {
+		p.items.order.MoveToFront(elem)
+	}
+}
+
+func (p *lruPolicy) add(key string, size int64) []string {
+	p.elems[key] = p.items.pushFront(&policyEntry{key: key, size: size})
+
+	var evicted []string
+	for p.items.over() {
+		oldest := entryOf(p.items.back())
+		evicted = append(evicted, oldest.key)
+		p.remove(oldest.key)
+	}
+
+	return evicted
+}
+
+func (p *lruPolicy) remove(key string) {
+	if elem, found := p.elems[key]; found {
+		p.items.remove(elem)
+		delete(p.elems, key)
+	}
+}
+
+func entrySize(key string, item *gocondcache.CacheItem) int64 {
+	return int64(len(key) + len(item.Response) + EntryOverhead)
+}
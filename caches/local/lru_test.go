@@ -0,0 +1,206 @@
+//go:build !integration
+
+package local_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/cachetest"
+	local "github.com/dgduncan/go-cond-cache/caches/local"
+)
+
+func testTime() time.Time {
+	return time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func newItem(response string, tags ...string) *gocondcache.CacheItem {
+	return &gocondcache.CacheItem{
+		Response:   []byte(response),
+		Expiration: testTime().Add(time.Hour),
+		Tags:       tags,
+	}
+}
+
+func keys(t *testing.T, cache *local.BasicCache) []string {
+	t.Helper()
+
+	var found []string
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if _, err := cache.Get(context.Background(), k); err == nil {
+			found = append(found, k)
+		} else if !errors.Is(err, caches.ErrNoCacheItem) {
+			t.Fatalf("failed to get %s: %v", k, err)
+		}
+	}
+
+	return found
+}
+
+func TestMaxEntries(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var evicted []string
+	cache := local.New(&local.Config{
+		MaxEntries: 2,
+		OnEvict: func(key string, _ *gocondcache.CacheItem) {
+			evicted = append(evicted, key)
+		},
+	}, testTime)
+
+	_ = cache.Set(ctx, "a", newItem("a"))
+	_ = cache.Set(ctx, "b", newItem("b"))
+	// reading a makes b the least recently used item
+	_, _ = cache.Get(ctx, "a")
+	_ = cache.Set(ctx, "c", newItem("c"))
+	// revalidating a makes c the least recently used item
+	_ = cache.Update(ctx, "a", testTime().Add(2*time.Hour))
+	_ = cache.Set(ctx, "d", newItem("d"))
+
+	// checking the keys reads them, so it is done once every write is made
+	if found := keys(t, cache); !reflect.DeepEqual(found, []string{"a", "d"}) {
+		t.Errorf("expected a and d to be stored, got %v", found)
+	}
+	if !reflect.DeepEqual(evicted, []string{"b", "c"}) {
+		t.Errorf("expected b then c to be evicted, got %v", evicted)
+	}
+
+	stats := cache.Stats()
+	if stats.Entries != 2 || stats.Evictions != 2 {
+		t.Errorf("expected 2 entries and 2 evictions, got %+v", stats)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := local.New(&local.Config{MaxEntries: 2}, testTime)
+
+	_ = cache.Set(ctx, "a", newItem("a"))
+	_ = cache.Set(ctx, "b", newItem("b"))
+	// peeking at a leaves it the least recently used item
+	if item, err := cache.Peek(ctx, "a"); err != nil || string(item.Response) != "a" {
+		t.Fatalf("expected to peek at a, got %v and error %v", item, err)
+	}
+	_ = cache.Set(ctx, "c", newItem("c"))
+
+	if found := keys(t, cache); !reflect.DeepEqual(found, []string{"b", "c"}) {
+		t.Errorf("expected b and c to be stored, got %v", found)
+	}
+	if _, err := cache.Peek(ctx, "a"); !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected ErrNoCacheItem peeking at an evicted item, got %v", err)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	size := int64(1 + 100 + local.EntryOverhead)
+	cache := local.New(&local.Config{MaxBytes: 3 * size}, testTime)
+
+	for _, k := range []string{"a", "b", "c"} {
+		_ = cache.Set(ctx, k, newItem(strings.Repeat(k, 100)))
+	}
+	if stats := cache.Stats(); stats.Bytes != 3*size || stats.Evictions != 0 {
+		t.Fatalf("expected 3 items of %d bytes and no eviction, got %+v", size, stats)
+	}
+
+	// a response twice as large evicts the two least recently used items
+	_ = cache.Set(ctx, "d", newItem(strings.Repeat("d", 200)))
+	if found := keys(t, cache); !reflect.DeepEqual(found, []string{"c", "d"}) {
+		t.Errorf("expected c and d to be stored, got %v", found)
+	}
+
+	expected := local.Stats{Entries: 2, Bytes: 2*size + 100, Evictions: 2, EvictedBytes: uint64(2 * size)}
+	if stats := cache.Stats(); stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+}
+
+func TestOversizedItem(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var evicted []string
+	cache := local.New(&local.Config{
+		MaxBytes: 1000,
+		OnEvict: func(key string, _ *gocondcache.CacheItem) {
+			evicted = append(evicted, key)
+		},
+	}, testTime)
+
+	_ = cache.Set(ctx, "a", newItem("a"))
+	_ = cache.Set(ctx, "b", newItem(strings.Repeat("b", 1000)))
+
+	if found := keys(t, cache); !reflect.DeepEqual(found, []string{"a"}) {
+		t.Errorf("expected the oversized item not to replace a, got %v", found)
+	}
+	if !reflect.DeepEqual(evicted, []string{"b"}) {
+		t.Errorf("expected the oversized item to be reported as evicted, got %v", evicted)
+	}
+}
+
+func TestSizeAccounting(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := local.New(&local.Config{MaxEntries: 10}, testTime)
+
+	_ = cache.Set(ctx, "a", newItem("first"))
+	_ = cache.Set(ctx, "a", newItem("second", "t"))
+	_ = cache.Set(ctx, "b", newItem("b", "t"))
+	_ = cache.Set(ctx, "c", newItem("c"))
+	_ = cache.Update(ctx, "c", testTime())
+	if stats := cache.Stats(); stats.Entries != 3 || stats.Bytes != int64(3+6+1+1+3*local.EntryOverhead) {
+		t.Errorf("unexpected stats after writes %+v", stats)
+	}
+
+	_, _ = cache.PurgeTag(ctx, "t")
+	_ = cache.Delete(ctx, "c")
+	if stats := cache.Stats(); stats != (local.Stats{}) {
+		t.Errorf("expected an empty cache, got %+v", stats)
+	}
+}
+
+func TestBoundedConcurrency(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := local.New(&local.Config{MaxEntries: 50}, testTime)
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 500 {
+				k := fmt.Sprintf("%d-%d", w, i%100)
+				_ = cache.Set(ctx, k, newItem(k))
+				_, _ = cache.Get(ctx, k)
+				_ = cache.Update(ctx, k, testTime().Add(time.Hour))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if stats := cache.Stats(); stats.Entries != 50 || stats.Evictions == 0 {
+		t.Errorf("expected the cache to stay at 50 entries by evicting, got %+v", stats)
+	}
+}
+
+func TestBoundedConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(_ *testing.T, now func() time.Time) gocondcache.Cache {
+		return local.New(&local.Config{MaxEntries: 1000, MaxBytes: 1 << 20}, now)
+	})
+}
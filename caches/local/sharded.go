@@ -0,0 +1,239 @@
+package local
+
+import (
+	"context"
+	"hash/maphash"
+	"math/bits"
+	"slices"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+)
+
+// DefaultShards is the default number of shards of a ShardedCache.
+const DefaultShards = 64
+
+// The number of shards of a bounded ShardedCache is reduced so that each shard keeps at
+// least minShardEntries items and minShardBytes bytes.
+const (
+	minShardEntries = 64
+	minShardBytes   = 1 << 20
+)
+
+// ShardedCache is an in-memory cache split into shards, each a BasicCache with its own
+// lock, so that concurrent operations on different keys rarely wait for each other.
+//
+// Items are copied when they are stored and when they are returned by Get and Range,
+// so that readers never observe a change made to an item by the cache or by another
+// reader. The Response of an item is shared and must not be modified.
+type ShardedCache struct {
+	shards  []*BasicCache
+	mask    uint64
+	seed    maphash.Seed
+	janitor *task // nil if expired items are not removed
+
+	snapshotter  *task // nil if the cache is not saved
+	snapshotPath string
+}
+
+// NewSharded returns a ShardedCache made of the given number of shards, rounded up to a
+// power of two, or of DefaultShards if it is not positive. The limits of config are
+// divided evenly between the shards, whose number is reduced so that each keeps at least
+// minShardEntries items and minShardBytes bytes, or is a single shard enforcing the
+// limits exactly. The limits are thus approximate: items may be evicted from a shard
+// before the cache as a whole reaches them, and an item larger than the byte limit of its
+// shard is not stored. A nil now defaults to time.Now. If config.DeleteExpiredItems or
+// config.SnapshotPath is set, Close must be called to stop the background goroutines
+// once the cache is no longer used.
+func NewSharded(shards int, config *Config, now func() time.Time) *ShardedCache {
+	c := Config{}
+	if config != nil {
+		c = *config
+	}
+
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	n := 1 << bits.Len(uint(shards-1)) //nolint:gosec // shards is positive
+	for n > 1 && (underBudget(c.MaxEntries, n, minShardEntries) ||
+		underBudget(c.MaxBytes, int64(n), minShardBytes)) {
+		n /= 2
+	}
+
+	// a single janitor sweeps every shard, and the shards are saved to a single snapshot
+	shardConfig := c
+	shardConfig.DeleteExpiredItems = false
+	shardConfig.SnapshotPath = ""
+	shardConfig.MaxEntries = ceilDiv(c.MaxEntries, n)
+	shardConfig.MaxBytes = ceilDiv(c.MaxBytes, int64(n))
+
+	sc := &ShardedCache{
+		shards: make([]*BasicCache, n),
+		mask:   uint64(n - 1), //nolint:gosec // n is positive
+		seed:   maphash.MakeSeed(),
+
+		snapshotPath: c.SnapshotPath,
+	}
+	for i := range sc.shards {
+		sc.shards[i] = New(&shardConfig, now)
+	}
+
+	if c.DeleteExpiredItems {
+		sc.janitor = startTask(c.expiredTaskTimer(), func() { sc.RemoveExpired() })
+	}
+
+	nowFunc := now
+	if nowFunc == nil {
+		nowFunc = time.Now
+	}
+	sc.snapshotter = snapshots(sc, &c, nowFunc)
+
+	return sc
+}
+
+func ceilDiv[T int | int64](a, b T) T {
+	return (a + b - 1) / b
+}
+
+// underBudget reports whether dividing limit between shards leaves less than minimum to
+// each. A zero limit is no limit.
+func underBudget[T int | int64](limit, shards, minimum T) bool {
+	return limit > 0 && limit/shards < minimum
+}
+
+func (sc *ShardedCache) shard(key string) *BasicCache {
+	return sc.shards[maphash.String(sc.seed, key)&sc.mask]
+}
+
+// Get returns a copy of the item stored under key.
+func (sc *ShardedCache) Get(ctx context.Context, key string) (*gocondcache.CacheItem, error) {
+	item, err := sc.shard(key).Get(ctx, key)
+	if item != nil {
+		item = copyItem(item)
+	}
+
+	return item, err
+}
+
+// Peek returns a copy of the item stored under key without recording the access.
+func (sc *ShardedCache) Peek(ctx context.Context, key string) (*gocondcache.CacheItem, error) {
+	item, err := sc.shard(key).Peek(ctx, key)
+	if item != nil {
+		item = copyItem(item)
+	}
+
+	return item, err
+}
+
+// Set stores a copy of item under key, unless it exceeds Config.MaxBytes or the byte
+// limit of its shard.
+func (sc *ShardedCache) Set(ctx context.Context, key string, item *gocondcache.CacheItem) error {
+	return sc.shard(key).Set(ctx, key, copyItem(item))
+}
+
+// Update sets the expiration of the item stored under key, under the lock of its shard.
+func (sc *ShardedCache) Update(ctx context.Context, key string, expiration time.Time) error {
+	return sc.shard(key).Update(ctx, key, expiration)
+}
+
+// Range calls f with a copy of every item in the cache until f returns false. The items
+// of a shard are collected before f is first called with them, so f may safely use the
+// cache.
+func (sc *ShardedCache) Range(ctx context.Context, f func(k string, v *gocondcache.CacheItem) bool) error {
+	more := true
+	for _, shard := range sc.shards {
+		err := shard.Range(ctx, func(k string, v *gocondcache.CacheItem) bool {
+			more = f(k, copyItem(v))
+			return more
+		})
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sc *ShardedCache) Delete(ctx context.Context, key string) error {
+	return sc.shard(key).Delete(ctx, key)
+}
+
+// PurgePrefix removes every item whose key starts with prefix.
+func (sc *ShardedCache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	return sc.purge(func(shard *BasicCache) (int, error) {
+		return shard.PurgePrefix(ctx, prefix)
+	})
+}
+
+// PurgeHost removes every item cached for host.
+func (sc *ShardedCache) PurgeHost(ctx context.Context, host string) (int, error) {
+	return sc.purge(func(shard *BasicCache) (int, error) {
+		return shard.PurgeHost(ctx, host)
+	})
+}
+
+// PurgeTag removes every item stored with tag.
+func (sc *ShardedCache) PurgeTag(ctx context.Context, tag string) (int, error) {
+	return sc.purge(func(shard *BasicCache) (int, error) {
+		return shard.PurgeTag(ctx, tag)
+	})
+}
+
+// SoftPurge sets the expiration of the item stored under key to now.
+func (sc *ShardedCache) SoftPurge(ctx context.Context, key string) error {
+	return sc.shard(key).SoftPurge(ctx, key)
+}
+
+func (sc *ShardedCache) purge(f func(shard *BasicCache) (int, error)) (int, error) {
+	purged := 0
+	for _, shard := range sc.shards {
+		n, err := f(shard)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	return purged, nil
+}
+
+// Stats returns the size and eviction counters of the cache, summed over its shards.
+func (sc *ShardedCache) Stats() Stats {
+	var stats Stats
+	for _, shard := range sc.shards {
+		s := shard.Stats()
+		stats.Entries += s.Entries
+		stats.Bytes += s.Bytes
+		stats.Evictions += s.Evictions
+		stats.EvictedBytes += s.EvictedBytes
+		stats.Expired += s.Expired
+	}
+
+	return stats
+}
+
+// Close stops the janitor removing expired items and the periodic snapshots, if they
+// run, and waits for them to return. If the cache is configured with a snapshot path, it
+// is then saved a last time. The cache can still be used once closed.
+func (sc *ShardedCache) Close() error {
+	sc.janitor.close()
+	sc.snapshotter.close()
+
+	if sc.snapshotPath == "" {
+		return nil
+	}
+
+	return sc.SaveSnapshot(context.Background(), sc.snapshotPath)
+}
+
+// copyItem returns a copy of item sharing its Response.
+func copyItem(item *gocondcache.CacheItem) *gocondcache.CacheItem {
+	c := *item
+	if item.LastModified != nil {
+		lastModified := *item.LastModified
+		c.LastModified = &lastModified
+	}
+	c.Tags = slices.Clone(item.Tags)
+
+	return &c
+}
@@ -0,0 +1,211 @@
+//go:build !integration
+
+package local_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches/cachetest"
+	local "github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+func TestShardedConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(_ *testing.T, now func() time.Time) gocondcache.Cache {
+		return local.NewSharded(8, nil, now)
+	})
+}
+
+func TestShardedBoundedConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(_ *testing.T, now func() time.Time) gocondcache.Cache {
+		return local.NewSharded(4, &local.Config{MaxEntries: 4000, MaxBytes: 4 << 20, Admission: local.AdmitTinyLFU}, now)
+	})
+}
+
+func TestShardedCopies(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := local.NewSharded(0, nil, testTime)
+
+	lastModified := testTime().Add(-time.Hour)
+	item := &gocondcache.CacheItem{ETAG: `"v1"`, LastModified: &lastModified, Expiration: testTime(), Tags: []string{"a"}}
+	_ = cache.Set(ctx, "k", item)
+	// changes made by the writer after Set are not stored
+	item.ETAG, item.Tags[0] = `"v2"`, "b"
+	*item.LastModified = testTime()
+
+	read, _ := cache.Get(ctx, "k")
+	if read.ETAG != `"v1"` || read.Tags[0] != "a" || !read.LastModified.Equal(testTime().Add(-time.Hour)) {
+		t.Fatalf("expected the stored item to be unchanged, got %+v", read)
+	}
+
+	// changes made by a reader are not seen by the others
+	read.Tags[0] = "c"
+	if other, _ := cache.Get(ctx, "k"); other.Tags[0] != "a" {
+		t.Errorf("expected a reader not to observe another's change, got %v", other.Tags)
+	}
+
+	// nor are the ones made by Update
+	_ = cache.Update(ctx, "k", testTime().Add(time.Hour))
+	if !read.Expiration.Equal(testTime()) {
+		t.Errorf("expected Update not to change an item already read, got %v", read.Expiration)
+	}
+	if updated, _ := cache.Get(ctx, "k"); !updated.Expiration.Equal(testTime().Add(time.Hour)) {
+		t.Errorf("expected the expiration to be updated, got %v", updated.Expiration)
+	}
+}
+
+func TestShardedLimits(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var lock sync.Mutex
+	evicted := 0
+	cache := local.NewSharded(8, &local.Config{
+		MaxEntries: 64,
+		OnEvict: func(string, *gocondcache.CacheItem) {
+			lock.Lock()
+			defer lock.Unlock()
+			evicted++
+		},
+	}, testTime)
+
+	for i := range 1000 {
+		_ = cache.Set(ctx, fmt.Sprintf("k-%d", i), newItem("v"))
+	}
+
+	// each of the 8 shards holds at most 8 items
+	stats := cache.Stats()
+	if stats.Entries > 64 || stats.Entries+int(stats.Evictions) != 1000 || evicted != int(stats.Evictions) {
+		t.Errorf("expected at most 64 entries and the others evicted, got %+v and %d evictions reported", stats, evicted)
+	}
+}
+
+func TestShardedSmallLimits(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		config     local.Config
+		items      int
+		size       int
+		maxEntries int
+	}{
+		{name: "few entries", config: local.Config{MaxEntries: 10}, items: 100, size: 1, maxEntries: 10},
+		{name: "few bytes", config: local.Config{MaxBytes: 1 << 20}, items: 1, size: 100 << 10, maxEntries: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := local.NewSharded(local.DefaultShards, &tt.config, testTime)
+			response := strings.Repeat("x", tt.size)
+			for i := range tt.items {
+				_ = cache.Set(ctx, fmt.Sprintf("k-%d", i), newItem(response))
+			}
+
+			// the shards are reduced so that the limits are not divided to uselessly small ones
+			if stats := cache.Stats(); stats.Entries != tt.maxEntries {
+				t.Errorf("expected %d entries, got %+v", tt.maxEntries, stats)
+			}
+		})
+	}
+}
+
+func TestShardedJanitor(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := gocondcachetest.NewClock(testTime())
+	cache := local.NewSharded(4, &local.Config{DeleteExpiredItems: true, ExpiredTaskTimer: time.Hour}, clock.Now)
+	defer cache.Close()
+
+	for i := range 20 {
+		_ = cache.Set(ctx, fmt.Sprintf("k-%d", i), &gocondcache.CacheItem{Expiration: testTime().Add(time.Duration(i%2) * time.Hour)})
+	}
+	clock.Advance(time.Minute)
+
+	if removed := cache.RemoveExpired(); removed != 10 {
+		t.Fatalf("expected the 10 expired items to be removed, got %d", removed)
+	}
+	if stats := cache.Stats(); stats.Entries != 10 || stats.Expired != 10 {
+		t.Errorf("expected the 10 fresh items to be kept, got %+v", stats)
+	}
+}
+
+func TestShardedRangeStops(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := local.NewSharded(8, nil, testTime)
+	for i := range 100 {
+		_ = cache.Set(ctx, fmt.Sprintf("k-%d", i), newItem("v"))
+	}
+
+	calls := 0
+	_ = cache.Range(ctx, func(string, *gocondcache.CacheItem) bool {
+		calls++
+		return calls < 10
+	})
+	if calls != 10 {
+		t.Errorf("expected Range to stop after 10 calls, got %d", calls)
+	}
+}
+
+// BenchmarkConcurrentAccess compares the caches under a read-mostly parallel workload.
+// Run it with several -cpu values, e.g. -cpu 1,2,4,8, to see how each scales with
+// GOMAXPROCS.
+func BenchmarkConcurrentAccess(b *testing.B) {
+	const keyCount = 10_000
+
+	keyNames := make([]string, keyCount)
+	for i := range keyNames {
+		keyNames[i] = fmt.Sprintf("GET#https://example.com/%d", i)
+	}
+
+	caches := []struct {
+		name string
+		new  func() gocondcache.Cache
+	}{
+		{name: "basic", new: func() gocondcache.Cache { return local.New(nil, testTime) }},
+		{name: "basic bounded", new: func() gocondcache.Cache { return local.New(&local.Config{MaxEntries: keyCount}, testTime) }},
+		{name: "sharded", new: func() gocondcache.Cache { return local.NewSharded(0, nil, testTime) }},
+		{
+			name: "sharded bounded",
+			new:  func() gocondcache.Cache { return local.NewSharded(0, &local.Config{MaxEntries: keyCount}, testTime) },
+		},
+	}
+
+	for _, c := range caches {
+		b.Run(c.name, func(b *testing.B) {
+			ctx := context.Background()
+			cache := c.new()
+			for _, k := range keyNames {
+				_ = cache.Set(ctx, k, newItem("v"))
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					k := keyNames[i%keyCount]
+					// one write for every 10 reads
+					if i%10 == 0 {
+						_ = cache.Update(ctx, k, testTime().Add(time.Hour))
+					} else {
+						_, _ = cache.Get(ctx, k)
+					}
+					i += 7
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+)
+
+// DefaultSnapshotInterval is the default interval at which a cache configured with a
+// SnapshotPath is saved.
+const DefaultSnapshotInterval = 5 * time.Minute
+
+// A snapshot file holds snapshotMagic, the format version as a big-endian uint32, a gob
+// stream made of a snapshotHeader followed by its Count entries, and finally the
+// CRC-32C of everything before it as a big-endian uint32.
+const (
+	snapshotMagic   = "CONDSNAP"
+	snapshotVersion = 1
+)
+
+// snapshotHeader is the first value of the gob stream of a snapshot.
+type snapshotHeader struct {
+	Created time.Time
+	Count   int
+}
+
+// snapshotEntry is an item of a snapshot. It is independent of gocondcache.CacheItem so
+// that the format only changes with snapshotVersion.
+type snapshotEntry struct {
+	Key          string
+	ETag         string
+	LastModified *time.Time
+	Response     []byte
+	Expiration   time.Time
+	Tags         []string
+	StaleWindow  time.Duration
+}
+
+// snapshotSource is a cache that can be saved to and loaded from a snapshot.
+type snapshotSource interface {
+	gocondcache.Cache
+	gocondcache.Ranger
+}
+
+func (c *Config) snapshotInterval() time.Duration {
+	if c.SnapshotInterval > 0 {
+		return c.SnapshotInterval
+	}
+
+	return DefaultSnapshotInterval
+}
+
+// SaveSnapshot writes every item of the cache to the file at path. The file is written
+// to a temporary file in the same directory first and then renamed, so that it is
+// replaced atomically and a crash never leaves a partial snapshot behind.
+func (bc *BasicCache) SaveSnapshot(ctx context.Context, path string) error {
+	return saveSnapshot(ctx, bc, path, bc.now)
+}
+
+// LoadSnapshot stores the items of the snapshot at path in the cache, and returns their
+// number. Items whose expiration is more than Config.RetentionGrace in the past are
+// skipped. Returns an error wrapping caches.ErrInvalidSnapshot if the file is not a
+// valid snapshot, in which case no item is loaded.
+func (bc *BasicCache) LoadSnapshot(ctx context.Context, path string) (int, error) {
+	return loadSnapshot(ctx, bc, path, bc.now().UTC().Add(-bc.retention))
+}
+
+// SaveSnapshot writes every item of the cache to the file at path, atomically, as
+// BasicCache.SaveSnapshot does.
+func (sc *ShardedCache) SaveSnapshot(ctx context.Context, path string) error {
+	return saveSnapshot(ctx, sc, path, sc.shards[0].now)
+}
+
+// LoadSnapshot stores the items of the snapshot at path in the cache, and returns their
+// number, as BasicCache.LoadSnapshot does.
+func (sc *ShardedCache) LoadSnapshot(ctx context.Context, path string) (int, error) {
+	shard := sc.shards[0]
+
+	return loadSnapshot(ctx, sc, path, shard.now().UTC().Add(-shard.retention))
+}
+
+// snapshots loads the snapshot configured for cache, if any, and starts the task saving
+// it periodically. A missing snapshot is not an error, and an invalid one is logged and
+// ignored so that the cache starts empty.
+func snapshots(cache snapshotSource, config *Config, now func() time.Time) *task {
+	if config.SnapshotPath == "" {
+		return nil
+	}
+
+	ctx := context.Background()
+	cutoff := now().UTC().Add(-config.RetentionGrace)
+	n, err := loadSnapshot(ctx, cache, config.SnapshotPath, cutoff)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		slog.ErrorContext(ctx, "error loading cache snapshot", "path", config.SnapshotPath, "error", err)
+	default:
+		slog.DebugContext(ctx, "loaded cache snapshot", "path", config.SnapshotPath, "items", n)
+	}
+
+	return startTask(config.snapshotInterval(), func() {
+		if err := saveSnapshot(ctx, cache, config.SnapshotPath, now); err != nil {
+			slog.ErrorContext(ctx, "error saving cache snapshot", "path", config.SnapshotPath, "error", err)
+		}
+	})
+}
+
+func saveSnapshot(ctx context.Context, cache gocondcache.Ranger, path string, now func() time.Time) error {
+	var entries []snapshotEntry
+	err := cache.Range(ctx, func(k string, v *gocondcache.CacheItem) bool {
+		entries = append(entries, snapshotEntry{
+			Key:          k,
+			ETag:         v.ETAG,
+			LastModified: v.LastModified,
+			Response:     v.Response,
+			Expiration:   v.Expiration,
+			Tags:         v.Tags,
+			StaleWindow:  v.StaleWindow,
+		})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// the temporary file is only left behind if the rename failed
+	defer os.Remove(tmp.Name()) //nolint:errcheck // best effort cleanup
+
+	if err = writeSnapshot(tmp, snapshotHeader{Created: now().UTC(), Count: len(entries)}, entries); err != nil {
+		return errors.Join(err, tmp.Close())
+	}
+	if err = tmp.Sync(); err != nil {
+		return errors.Join(err, tmp.Close())
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeSnapshot(w io.Writer, header snapshotHeader, entries []snapshotEntry) error {
+	bw := bufio.NewWriter(w)
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	mw := io.MultiWriter(bw, crc)
+
+	if _, err := io.WriteString(mw, snapshotMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(mw, binary.BigEndian, uint32(snapshotVersion)); err != nil {
+		return err
+	}
+
+	enc := gob.NewEncoder(mw)
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := binary.Write(bw, binary.BigEndian, crc.Sum32()); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+func loadSnapshot(ctx context.Context, cache gocondcache.Cache, path string, cutoff time.Time) (int, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // the path is configured by the caller
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := readSnapshot(data)
+	if err != nil {
+		return 0, err
+	}
+
+	loaded := 0
+	for _, e := range entries {
+		if cutoff.After(e.Expiration) {
+			continue
+		}
+
+		item := &gocondcache.CacheItem{
+			ETAG:         e.ETag,
+			LastModified: e.LastModified,
+			Response:     e.Response,
+			Expiration:   e.Expiration,
+			Tags:         e.Tags,
+			StaleWindow:  e.StaleWindow,
+		}
+		if err = cache.Set(ctx, e.Key, item); err != nil {
+			return loaded, err
+		}
+		loaded++
+	}
+
+	return loaded, nil
+}
+
+// readSnapshot verifies the checksum and version of a snapshot and decodes its entries.
+func readSnapshot(data []byte) ([]snapshotEntry, error) {
+	headerLen := len(snapshotMagic) + 4
+	if len(data) < headerLen+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: not a snapshot", caches.ErrInvalidSnapshot)
+	}
+
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, crc32.MakeTable(crc32.Castagnoli)) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", caches.ErrInvalidSnapshot)
+	}
+	if version := binary.BigEndian.Uint32(body[len(snapshotMagic):headerLen]); version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", caches.ErrInvalidSnapshot, version)
+	}
+
+	dec := gob.NewDecoder(bytes.NewReader(body[headerLen:]))
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("%w: reading header: %w", caches.ErrInvalidSnapshot, err)
+	}
+
+	entries := make([]snapshotEntry, 0, min(max(header.Count, 0), len(body)))
+	for i := range header.Count {
+		var e snapshotEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("%w: entry %d: %w", caches.ErrInvalidSnapshot, i+1, err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,208 @@
+//go:build !integration
+
+package local_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	local "github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+func TestSnapshotRestart(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	config := &local.Config{SnapshotPath: path, RetentionGrace: time.Minute}
+
+	lastModified := testTime().Add(-time.Hour)
+	items := map[string]*gocondcache.CacheItem{
+		"fresh": {
+			ETAG:         `"v1"`,
+			LastModified: &lastModified,
+			Response:     []byte("HTTP/1.1 200 OK\r\n\r\nhello"),
+			Expiration:   testTime().Add(time.Hour),
+			Tags:         []string{"a", "b"},
+			StaleWindow:  time.Minute,
+		},
+		"within grace": {ETAG: `"v2"`, Expiration: testTime().Add(-30 * time.Second)},
+		"past grace":   {ETAG: `"v3"`, Expiration: testTime().Add(-2 * time.Minute)},
+	}
+
+	cache := local.New(config, testTime)
+	for k, v := range items {
+		_ = cache.Set(ctx, k, v)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("failed to close the cache: %v", err)
+	}
+
+	restarted := local.New(config, testTime)
+	defer restarted.Close()
+
+	for _, k := range []string{"fresh", "within grace"} {
+		item, _ := restarted.Get(ctx, k)
+		if !reflect.DeepEqual(item, items[k]) {
+			t.Errorf("expected %q to be restored as %+v, got %+v", k, items[k], item)
+		}
+	}
+	if _, err := restarted.Get(ctx, "past grace"); !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected the item past its retention not to be loaded, got %v", err)
+	}
+	if tagged, _ := restarted.PurgeTag(ctx, "b"); tagged != 1 {
+		t.Errorf("expected the tags to be indexed on load, got %d items purged", tagged)
+	}
+}
+
+func TestSnapshotSharded(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	sharded := local.NewSharded(8, nil, testTime)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		_ = sharded.Set(ctx, k, newItem(k))
+	}
+	if err := sharded.SaveSnapshot(ctx, path); err != nil {
+		t.Fatalf("failed to save the snapshot: %v", err)
+	}
+
+	// the format does not depend on the number of shards
+	for _, cache := range []interface {
+		LoadSnapshot(ctx context.Context, path string) (int, error)
+	}{local.New(nil, testTime), local.NewSharded(2, nil, testTime)} {
+		if n, err := cache.LoadSnapshot(ctx, path); err != nil || n != 4 {
+			t.Errorf("expected 4 items loaded, got %d and %v", n, err)
+		}
+	}
+
+	restarted := local.NewSharded(4, &local.Config{SnapshotPath: path}, testTime)
+	defer restarted.Close()
+	if item, err := restarted.Get(ctx, "c"); err != nil || string(item.Response) != "c" {
+		t.Errorf("expected the sharded cache to load its snapshot, got %+v and %v", item, err)
+	}
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid")
+
+	source := local.New(nil, testTime)
+	_ = source.Set(ctx, "k", newItem("value"))
+	if err := source.SaveSnapshot(ctx, valid); err != nil {
+		t.Fatalf("failed to save the snapshot: %v", err)
+	}
+	data, err := os.ReadFile(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := append([]byte(nil), data...)
+	flipped[len(flipped)/2] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "not a snapshot", data: []byte(`{"format":"condcache"}`)},
+		{name: "truncated", data: data[:len(data)-10]},
+		{name: "corrupted", data: flipped},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, tt.data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cache := local.New(nil, testTime)
+			if n, err := cache.LoadSnapshot(ctx, path); !errors.Is(err, caches.ErrInvalidSnapshot) || n != 0 {
+				t.Errorf("expected ErrInvalidSnapshot, got %d items and %v", n, err)
+			}
+
+			// a cache configured with an invalid snapshot starts empty
+			configured := local.New(&local.Config{SnapshotPath: path}, testTime)
+			if stats := configured.Stats(); stats.Entries != 0 {
+				t.Errorf("expected an empty cache, got %+v", stats)
+			}
+			configured.Close()
+		})
+	}
+}
+
+func TestSnapshotMissing(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "missing")
+	if _, err := local.New(nil, testTime).LoadSnapshot(context.Background(), path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing snapshot to be reported, got %v", err)
+	}
+
+	// a cache configured with a missing snapshot creates it on Close
+	cache := local.New(&local.Config{SnapshotPath: path}, testTime)
+	if err := cache.Close(); err != nil {
+		t.Fatalf("failed to close the cache: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the snapshot to be saved, got %v", err)
+	}
+}
+
+func TestSnapshotPeriodic(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.snapshot")
+	clock := gocondcachetest.NewClock(testTime())
+	cache := local.New(&local.Config{SnapshotPath: path, SnapshotInterval: time.Millisecond}, clock.Now)
+	defer cache.Close()
+
+	_ = cache.Set(ctx, "k", newItem("value"))
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if n, _ := local.New(nil, clock.Now).LoadSnapshot(ctx, path); n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the snapshot to be saved periodically")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// snapshots are renamed into place, so no temporary file is left behind
+	if err := cache.Close(); err != nil {
+		t.Fatalf("failed to close the cache: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "cache.snapshot" {
+		t.Errorf("expected only the snapshot in its directory, got %v", entries)
+	}
+}
+
+func TestSnapshotSaveError(t *testing.T) {
+	t.Parallel()
+
+	cache := local.New(&local.Config{SnapshotPath: filepath.Join(t.TempDir(), "missing", "cache.snapshot")}, testTime)
+	if err := cache.Close(); err == nil {
+		t.Error("expected Close to report the failed snapshot")
+	}
+}
@@ -0,0 +1,240 @@
+package local
+
+import (
+	"container/list"
+	"hash/maphash"
+	"math/bits"
+)
+
+const (
+	windowPercent    = 1  // share of the limits given to the window
+	protectedPercent = 80 // share of the main limits given to the protected segment
+
+	sketchDepth        = 4
+	sketchMinWidth     = 64
+	sketchWidthFactor  = 4       // counters per row for each item expected, limiting collisions
+	sketchMaxCount     = 15      // counters saturate like 4-bit counters
+	sketchResetFactor  = 10      // counters are halved every sketchResetFactor increments per item expected
+	sketchBytesPerItem = 4 << 10 // expected size of an item, for caches limited by size only
+)
+
+// tinyLFU is a W-TinyLFU policy. New items enter a small LRU window. The items leaving
+// the window are only admitted into the main segmented LRU if their estimated access
+// frequency is higher than the one of the item they would evict, so that a sweep over
+// many items seen once does not push out the items read often.
+//
+// The main segmented LRU keeps the items read again after their admission in a
+// protected segment, and the others in a probation segment evicted first.
+type tinyLFU struct {
+	sketch *sketch
+
+	window    *segment
+	probation *segment
+	protected *segment
+
+	// the limits of probation and protected combined
+	mainEntries int
+	mainBytes   int64
+
+	elems map[string]*list.Element
+}
+
+func newTinyLFUPolicy(maxEntries int, maxBytes int64) *tinyLFU {
+	windowEntries, mainEntries := split(int64(maxEntries), windowPercent)
+	windowBytes, mainBytes := split(maxBytes, windowPercent)
+	_, protectedEntries := split(mainEntries, 100-protectedPercent)
+	_, protectedBytes := split(mainBytes, 100-protectedPercent)
+
+	expected := maxEntries
+	if expected == 0 {
+		expected = int(maxBytes / sketchBytesPerItem)
+	}
+
+	return &tinyLFU{
+		sketch:    newSketch(expected),
+		window:    newSegment(int(windowEntries), windowBytes),
+		probation: newSegment(0, 0),
+		protected: newSegment(int(protectedEntries), protectedBytes),
+
+		mainEntries: int(mainEntries),
+		mainBytes:   mainBytes,
+
+		elems: make(map[string]*list.Element),
+	}
+}
+
+// split returns percent of limit, and the rest of it. Each part of a limit is at least
+// 1, and both are 0 for no limit.
+func split(limit int64, percent int64) (int64, int64) {
+	if limit <= 0 {
+		return 0, 0
+	}
+
+	part := max(limit*percent/100, 1)
+	return part, max(limit-part, 1)
+}
+
+func (p *tinyLFU) access(key string) {
+	p.sketch.increment(key)
+
+	elem, found := p.elems[key]
+	if !found {
+		return
+	}
+
+	e := entryOf(elem)
+	switch e.segment {
+	case p.probation:
+		// a second read promotes the item to the protected segment, whose least
+		// recently used items go back to probation when it is full
+		p.move(elem, p.protected)
+		for p.protected.over() && p.protected.order.Len() > 1 {
+			p.move(p.protected.back(), p.probation)
+		}
+	default:
+		e.segment.order.MoveToFront(elem)
+	}
+}
+
+func (p *tinyLFU) refresh(key string) {
+	if elem, found := p.elems[key]; found {
+		entryOf(elem).segment.order.MoveToFront(elem)
+	}
+}
+
+func (p *tinyLFU) add(key string, size int64) []string {
+	p.elems[key] = p.window.pushFront(&policyEntry{key: key, size: size})
+
+	var evicted []string
+	for p.window.over() {
+		evicted = append(evicted, p.admit(p.window.remove(p.window.back()))...)
+	}
+
+	return evicted
+}
+
+// admit moves the candidate that just left the window to the probation segment if the
+// main segments have room for it, or if it is read more often than the item it would
+// evict first. Otherwise the candidate is evicted. It returns the keys of the items
+// evicted.
+func (p *tinyLFU) admit(candidate *policyEntry) []string {
+	if !p.mainFits(candidate.size, 1) {
+		victim := p.victim()
+		if victim == nil || p.sketch.estimate(candidate.key) <= p.sketch.estimate(victim.key) {
+			delete(p.elems, candidate.key)
+			return []string{candidate.key}
+		}
+	}
+
+	p.elems[candidate.key] = p.probation.pushFront(candidate)
+
+	var evicted []string
+	for !p.mainFits(0, 0) {
+		victim := p.victim()
+		evicted = append(evicted, victim.key)
+		p.remove(victim.key)
+	}
+
+	return evicted
+}
+
+// mainFits reports whether the main segments respect their limits with the given number
+// of items of the given total size added.
+func (p *tinyLFU) mainFits(size int64, count int) bool {
+	entries := p.probation.order.Len() + p.protected.order.Len() + count
+	bytes := p.probation.bytes + p.protected.bytes + size
+
+	return (p.mainEntries <= 0 || entries <= p.mainEntries) && (p.mainBytes <= 0 || bytes <= p.mainBytes)
+}
+
+// victim returns the item the main segments evict first, or nil if they are empty.
+func (p *tinyLFU) victim() *policyEntry {
+	elem := p.probation.back()
+	if elem == nil {
+		elem = p.protected.back()
+	}
+	if elem == nil {
+		return nil
+	}
+
+	return entryOf(elem)
+}
+
+// move moves an item to the front of another segment.
+func (p *tinyLFU) move(elem *list.Element, to *segment) {
+	e := entryOf(elem).segment.remove(elem)
+	p.elems[e.key] = to.pushFront(e)
+}
+
+func (p *tinyLFU) remove(key string) {
+	if elem, found := p.elems[key]; found {
+		entryOf(elem).segment.remove(elem)
+		delete(p.elems, key)
+	}
+}
+
+// sketch is a count-min sketch estimating how often keys were read recently. Counters
+// are halved periodically so that old reads weigh less than recent ones.
+type sketch struct {
+	rows  [sketchDepth][]uint8
+	mask  uint64
+	seed  maphash.Seed
+	count int
+	reset int
+}
+
+func newSketch(expected int) *sketch {
+	expected = max(expected, sketchMinWidth)
+	width := uint64(1) << bits.Len64(uint64(sketchWidthFactor*expected-1)) //nolint:gosec // never negative
+
+	s := &sketch{mask: width - 1, seed: maphash.MakeSeed(), reset: sketchResetFactor * expected}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+
+	return s
+}
+
+// indexes returns the counters of key in each row.
+func (s *sketch) indexes(key string) [sketchDepth]uint64 {
+	h := maphash.String(s.seed, key)
+	h1, h2 := h&0xffffffff, h>>32|1
+
+	var idx [sketchDepth]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & s.mask //nolint:gosec // i is below sketchDepth
+	}
+
+	return idx
+}
+
+func (s *sketch) increment(key string) {
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < sketchMaxCount {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.count++
+	if s.count >= s.reset {
+		s.halve()
+	}
+}
+
+func (s *sketch) estimate(key string) uint8 {
+	estimate := uint8(sketchMaxCount)
+	for i, idx := range s.indexes(key) {
+		estimate = min(estimate, s.rows[i][idx])
+	}
+
+	return estimate
+}
+
+func (s *sketch) halve() {
+	for _, row := range s.rows {
+		for i := range row {
+			row[i] /= 2
+		}
+	}
+	s.count /= 2
+}
@@ -0,0 +1,241 @@
+//go:build !integration
+
+package local_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/cachetest"
+	local "github.com/dgduncan/go-cond-cache/caches/local"
+)
+
+const traceCapacity = 1000
+
+// recordedTrace holds the import paths looked up while reading the imports of every Go
+// file under GOROOT/src of Go 1.27.1, one per line in lexical walk order, skipping the
+// testdata and vendor directories. Imports are resolved through a cache by build tools,
+// and files of the same package import similar sets, so it has the skew and locality of
+// a real workload: 24227 lookups of 610 distinct keys. Its reuse is mostly recent, which
+// favors LRU over the small admission window of W-TinyLFU, so W-TinyLFU is only expected
+// to stay close to LRU on it.
+const (
+	recordedTrace         = "testdata/goroot-imports.trace.gz"
+	recordedTraceCapacity = 50
+	recordedTraceMaxLoss  = 0.05
+)
+
+// trace is a sequence of keys replayed against a cache holding at most capacity items.
+// The hit ratio of W-TinyLFU must be above the one of LRU minus maxLoss.
+type trace struct {
+	name     string
+	keys     []string
+	capacity int
+	maxLoss  float64
+}
+
+// newTrace returns reads of hot keys following a Zipf distribution. Every sweepEvery
+// reads, when it is not zero, a sweep reads sweepLen keys once, as a batch job would.
+func newTrace(name string, reads, hot, sweepEvery, sweepLen int) trace {
+	r := rand.New(rand.NewSource(1)) //nolint:gosec // deterministic traces
+	zipf := rand.NewZipf(r, 1.1, 1, uint64(hot-1))
+
+	t := trace{name: name, keys: make([]string, 0, reads), capacity: traceCapacity}
+	sweeps := 0
+	for len(t.keys) < reads {
+		if sweepEvery > 0 && len(t.keys) > 0 && len(t.keys)%sweepEvery == 0 {
+			for i := 0; i < sweepLen && len(t.keys) < reads; i++ {
+				t.keys = append(t.keys, fmt.Sprintf("GET#https://example.com/sweep/%d/%d", sweeps, i))
+			}
+			sweeps++
+		}
+		t.keys = append(t.keys, fmt.Sprintf("GET#https://example.com/hot/%d", zipf.Uint64()))
+	}
+
+	return t
+}
+
+// loadTrace reads a trace of one key per line from a gzip compressed file.
+func loadTrace(tb testing.TB, name, path string, capacity int, maxLoss float64) trace {
+	tb.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	t := trace{name: name, capacity: capacity, maxLoss: maxLoss}
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		t.keys = append(t.keys, scanner.Text())
+	}
+	if err = scanner.Err(); err != nil {
+		tb.Fatal(err)
+	}
+
+	return t
+}
+
+func traces(tb testing.TB) []trace {
+	tb.Helper()
+
+	return []trace{
+		newTrace("zipf", 100_000, 20_000, 0, 0),
+		newTrace("zipf with sweeps", 100_000, 5_000, 5_000, 5_000),
+		loadTrace(tb, "recorded goroot imports", recordedTrace, recordedTraceCapacity, recordedTraceMaxLoss),
+	}
+}
+
+// replay reads key from cache, storing it on a miss, and reports whether it was a hit.
+func replay(cache *local.BasicCache, key string) bool {
+	ctx := context.Background()
+	if _, err := cache.Get(ctx, key); !errors.Is(err, caches.ErrNoCacheItem) {
+		return true
+	}
+
+	_ = cache.Set(ctx, key, &gocondcache.CacheItem{Expiration: testTime().Add(time.Hour)})
+	return false
+}
+
+func hitRatio(cache *local.BasicCache, keys []string) float64 {
+	hits := 0
+	for _, k := range keys {
+		if replay(cache, k) {
+			hits++
+		}
+	}
+
+	return float64(hits) / float64(len(keys))
+}
+
+func TestTinyLFUSweepResistance(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		admission       local.Admission
+		expectedMinKept int
+		expectedMaxKept int
+	}{
+		{name: "LRU", admission: local.AdmitAll, expectedMinKept: 0, expectedMaxKept: 0},
+		// the hot item still in the window when the sweep starts competes with the
+		// sweep through frequency estimates, which may collide
+		{name: "W-TinyLFU", admission: local.AdmitTinyLFU, expectedMinKept: 49, expectedMaxKept: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := local.New(&local.Config{MaxEntries: 100, Admission: tt.admission}, testTime)
+			hot := make([]string, 50)
+			for i := range hot {
+				hot[i] = fmt.Sprintf("hot-%d", i)
+			}
+			for range 5 {
+				hitRatio(cache, hot)
+			}
+
+			sweep := make([]string, 1000)
+			for i := range sweep {
+				sweep[i] = fmt.Sprintf("sweep-%d", i)
+			}
+			hitRatio(cache, sweep)
+
+			kept := 0
+			for _, k := range hot {
+				if _, err := cache.Get(context.Background(), k); err == nil {
+					kept++
+				}
+			}
+			if kept < tt.expectedMinKept || kept > tt.expectedMaxKept {
+				t.Errorf("expected %d to %d hot items to survive the sweep, got %d",
+					tt.expectedMinKept, tt.expectedMaxKept, kept)
+			}
+			if stats := cache.Stats(); stats.Entries != 100 {
+				t.Errorf("expected the cache to be full, got %+v", stats)
+			}
+		})
+	}
+}
+
+func TestTinyLFUMaxBytes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	size := int64(len("k-00") + 100 + local.EntryOverhead)
+	cache := local.New(&local.Config{MaxBytes: 10 * size, Admission: local.AdmitTinyLFU}, testTime)
+
+	for i := range 30 {
+		_ = cache.Set(ctx, fmt.Sprintf("k-%02d", i), newItem(fmt.Sprintf("%0100d", i)))
+	}
+
+	// the window is smaller than an item, so only the main segments hold items
+	if stats := cache.Stats(); stats.Bytes > 10*size || stats.Entries != 9 || stats.Evictions != 21 {
+		t.Errorf("expected 9 items within %d bytes, got %+v", 10*size, stats)
+	}
+}
+
+func TestAdmissionHitRatio(t *testing.T) {
+	t.Parallel()
+
+	for _, tr := range traces(t) {
+		lru := hitRatio(local.New(&local.Config{MaxEntries: tr.capacity}, testTime), tr.keys)
+		tinyLFU := hitRatio(local.New(&local.Config{MaxEntries: tr.capacity, Admission: local.AdmitTinyLFU}, testTime), tr.keys)
+
+		t.Logf("%s: LRU %.3f, W-TinyLFU %.3f", tr.name, lru, tinyLFU)
+		if tinyLFU <= lru-tr.maxLoss {
+			t.Errorf("%s: expected W-TinyLFU to hit more often than LRU minus %.2f, got %.3f and %.3f",
+				tr.name, tr.maxLoss, tinyLFU, lru)
+		}
+	}
+}
+
+func TestTinyLFUConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(_ *testing.T, now func() time.Time) gocondcache.Cache {
+		return local.New(&local.Config{MaxEntries: 1000, MaxBytes: 1 << 20, Admission: local.AdmitTinyLFU}, now)
+	})
+}
+
+// BenchmarkAdmission replays traces against each policy and reports their hit ratio.
+func BenchmarkAdmission(b *testing.B) {
+	policies := []struct {
+		name      string
+		admission local.Admission
+	}{
+		{name: "LRU", admission: local.AdmitAll},
+		{name: "W-TinyLFU", admission: local.AdmitTinyLFU},
+	}
+
+	for _, tr := range traces(b) {
+		for _, p := range policies {
+			b.Run(tr.name+"/"+p.name, func(b *testing.B) {
+				cache := local.New(&local.Config{MaxEntries: tr.capacity, Admission: p.admission}, testTime)
+
+				hits := 0
+				for i := range b.N {
+					if replay(cache, tr.keys[i%len(tr.keys)]) {
+						hits++
+					}
+				}
+
+				b.ReportMetric(100*float64(hits)/float64(b.N), "hit%")
+			})
+		}
+	}
+}
@@ -24,12 +24,32 @@ var (
 var (
 	//go:embed create_table.sql
 	queryCreateTable string
+	//go:embed create_tags_index.sql
+	queryCreateTagsIndex string
+	//go:embed create_tags_table.sql
+	queryCreateTagsTable string
 	//go:embed delete_expired.sql
 	queryDeleteExpired string
+	//go:embed delete_host.sql
+	queryDeleteHost string
+	//go:embed delete_item.sql
+	queryDeleteItem string
+	//go:embed delete_item_tags.sql
+	queryDeleteItemTags string
+	//go:embed delete_prefix.sql
+	queryDeletePrefix string
+	//go:embed delete_tag.sql
+	queryDeleteTag string
+	//go:embed fetch_all.sql
+	queryFetchAll string
 	//go:embed fetch_by_id.sql
 	queryFetchByID string
+	//go:embed fetch_for_update.sql
+	queryFetchForUpdate string
 	//go:embed insert_item.sql
 	queryInsertItem string
+	//go:embed insert_item_tag.sql
+	queryInsertItemTag string
 	//go:embed update_item.sql
 	queryUpdateItem string
 )
@@ -47,6 +67,9 @@ type Config struct {
 	// ItemExpiration defines how long items remain valid in the database.
 	// This is separate from the expiration time derived from conditional response headers.
 	ItemExpiration time.Duration
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
 }
 
 // Cache implements the gocondcache.Cache interface using PostgreSQL as the storage backend.
@@ -54,12 +77,14 @@ type Config struct {
 type Cache struct {
 	db *sql.DB
 
-	now func() time.Time
+	expiration time.Duration
+	now        func() time.Time
 }
 
 // Get retrieves a cache item from PostgreSQL by its key. It returns the cached item
 // if found and not expired, or an appropriate error otherwise.
-// Returns caches.ErrNoCacheItem if the item doesn't exist.
+// Returns caches.ErrNoCacheItem if the item doesn't exist, and the item along with
+// caches.ErrCacheItemExpired if it has expired.
 func (p *Cache) Get(ctx context.Context, k string) (*gocondcache.CacheItem, error) {
 	stmt, err := p.db.PrepareContext(ctx, queryFetchByID)
 	if err != nil {
@@ -67,91 +92,217 @@ func (p *Cache) Get(ctx context.Context, k string) (*gocondcache.CacheItem, erro
 	}
 	defer stmt.Close()
 
-	row := stmt.QueryRowContext(ctx, k, p.now().UTC())
-	if rowErr := row.Err(); rowErr != nil {
-		if errors.Is(rowErr, sql.ErrNoRows) {
-			return nil, caches.ErrNoCacheItem
-		}
-		return nil, rowErr
-	}
-
 	var url string
 	var response []byte
-	if scanErr := row.Scan(&url, &response); scanErr != nil {
+	if scanErr := stmt.QueryRowContext(ctx, k, p.now().UTC()).Scan(&url, &response); scanErr != nil {
+		if errors.Is(scanErr, sql.ErrNoRows) {
+			return nil, caches.ErrNoCacheItem
+		}
 		return nil, scanErr
 	}
 
-	buff := bytes.NewBuffer(response)
-	dec := gob.NewDecoder(buff)
+	item, err := decodeItem(response)
+	if err != nil {
+		return nil, err
+	}
 
-	var item gocondcache.CacheItem
-	if decErr := dec.Decode(&item); decErr != nil {
-		return nil, decErr
+	if p.now().UTC().After(item.Expiration) {
+		return item, caches.ErrCacheItemExpired
 	}
 
-	return &item, nil
+	return item, nil
 }
 
-// Set stores a new cache item in PostgreSQL with the provided key and value.
+// Set stores a cache item in PostgreSQL with the provided key and value, replacing any
+// item already stored under it, and indexes it by its tags.
 // It handles the serialization of the cache item using gob encoding.
 func (p *Cache) Set(ctx context.Context, k string, v *gocondcache.CacheItem) error {
-	stmt, err := p.db.PrepareContext(ctx, queryInsertItem)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-
 	var buff bytes.Buffer
 	enc := gob.NewEncoder(&buff)
 	if encErr := enc.Encode(v); encErr != nil {
 		return encErr
 	}
 
-	_, err = stmt.ExecContext(ctx, k, buff.Bytes(), p.now().UTC().Add(caches.DefaultExpiredDuration))
-	return err
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.ExecContext(ctx, queryInsertItem, k, buff.Bytes(), p.now().UTC().Add(p.expiration)); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, queryDeleteItemTags, k); err != nil {
+		return err
+	}
+	for _, tag := range v.Tags {
+		if _, err = tx.ExecContext(ctx, queryInsertItemTag, tag, k); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // Update modifies the expiration time of an existing cache item in PostgreSQL.
 // This is typically used when a cached response is revalidated with the origin server.
+// Returns caches.ErrNoCacheItem if the item doesn't exist.
 func (p *Cache) Update(ctx context.Context, key string, expiration time.Time) error {
-	stmt, err := p.db.PrepareContext(ctx, queryUpdateItem)
+	tx, err := p.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	defer func() { _ = tx.Rollback() }()
 
-	_, execErr := stmt.ExecContext(ctx, key, expiration, p.now().UTC())
-	return execErr
+	now := p.now().UTC()
+
+	var response []byte
+	if err = tx.QueryRowContext(ctx, queryFetchForUpdate, key, now).Scan(&response); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return caches.ErrNoCacheItem
+		}
+		return err
+	}
+
+	item, err := decodeItem(response)
+	if err != nil {
+		return err
+	}
+	item.Expiration = expiration
+
+	var buff bytes.Buffer
+	if err = gob.NewEncoder(&buff).Encode(item); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, queryUpdateItem, key, buff.Bytes(), now.Add(p.expiration), now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func createTable(ctx context.Context, db *sql.DB) error {
-	stmt, err := db.PrepareContext(ctx, queryCreateTable)
+// Range calls f for every item stored in PostgreSQL until f returns false.
+func (p *Cache) Range(ctx context.Context, f func(k string, v *gocondcache.CacheItem) bool) error {
+	stmt, err := p.db.PrepareContext(ctx, queryFetchAll)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.ExecContext(ctx)
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url string
+		var response []byte
+		if scanErr := rows.Scan(&url, &response); scanErr != nil {
+			return scanErr
+		}
+
+		item, decErr := decodeItem(response)
+		if decErr != nil {
+			return decErr
+		}
+
+		if !f(url, item) {
+			return nil
+		}
+	}
+
+	return rows.Err()
+}
+
+// Delete removes the cache item stored under key from PostgreSQL.
+func (p *Cache) Delete(ctx context.Context, key string) error {
+	stmt, err := p.db.PrepareContext(ctx, queryDeleteItem)
 	if err != nil {
 		return err
 	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, key)
+	return err
+}
+
+// PurgePrefix removes every cache item whose key starts with prefix from PostgreSQL.
+func (p *Cache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	return p.deleteWhere(ctx, queryDeletePrefix, prefix)
+}
+
+// PurgeHost removes every cache item cached for host from PostgreSQL.
+func (p *Cache) PurgeHost(ctx context.Context, host string) (int, error) {
+	return p.deleteWhere(ctx, queryDeleteHost, host)
+}
+
+// PurgeTag removes every cache item stored with tag from PostgreSQL, using the
+// condcache_tags table. Their tags are removed along with them.
+func (p *Cache) PurgeTag(ctx context.Context, tag string) (int, error) {
+	return p.deleteWhere(ctx, queryDeleteTag, tag)
+}
+
+// SoftPurge sets the expiration of the cache item stored under key to now, keeping it
+// in PostgreSQL so it can be revalidated.
+// Returns caches.ErrNoCacheItem if the item doesn't exist.
+func (p *Cache) SoftPurge(ctx context.Context, key string) error {
+	return p.Update(ctx, key, p.now().UTC())
+}
+
+func (p *Cache) deleteWhere(ctx context.Context, query string, arg string) (int, error) {
+	stmt, err := p.db.PrepareContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, arg)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func decodeItem(response []byte) (*gocondcache.CacheItem, error) {
+	buff := bytes.NewBuffer(response)
+	dec := gob.NewDecoder(buff)
+
+	var item gocondcache.CacheItem
+	if err := dec.Decode(&item); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func createTable(ctx context.Context, db *sql.DB) error {
+	for _, query := range []string{queryCreateTable, queryCreateTagsTable, queryCreateTagsIndex} {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func deleteExpiredItems(ctx context.Context, db *sql.DB) error {
-	stmt, err := db.PrepareContext(ctx, queryDeleteExpired)
+func (p *Cache) deleteExpiredItems(ctx context.Context) error {
+	stmt, err := p.db.PrepareContext(ctx, queryDeleteExpired)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.ExecContext(ctx)
+	_, err = stmt.ExecContext(ctx, p.now().UTC())
 	return err
 }
 
-func expiredTask(ctx context.Context, db *sql.DB) {
-	t := time.NewTimer(caches.DefaultExpiredTaskTimer)
+func (p *Cache) expiredTask(ctx context.Context, interval time.Duration) {
+	t := time.NewTimer(interval)
 
 	for {
 		select {
@@ -159,17 +310,18 @@ func expiredTask(ctx context.Context, db *sql.DB) {
 			slog.DebugContext(ctx, "context is done")
 			return
 		case <-t.C:
-			if err := deleteExpiredItems(ctx, db); err != nil {
+			if err := p.deleteExpiredItems(ctx); err != nil {
 				slog.ErrorContext(ctx, "error deleting expired items", "error", err)
 			}
-			_ = t.Reset(caches.DefaultExpiredTaskTimer)
+			_ = t.Reset(interval)
 		}
 	}
 }
 
 // New creates a new PostgreSQL cache instance with the provided configuration.
 // It verifies the database connection, creates the necessary table structure, and
-// optionally starts the cleanup task for expired items.
+// optionally starts the cleanup task for expired items, which runs until ctx is done.
+// If config is nil, the defaults are used.
 //
 // Returns an error if:
 // - The database connection test fails
@@ -184,15 +336,31 @@ func New(ctx context.Context, db *sql.DB, config *Config) (*Cache, error) {
 		return nil, err
 	}
 
+	c := Config{}
 	if config != nil {
-		if config.DeleteExpiredItems {
-			go expiredTask(ctx, db)
-		}
+		c = *config
 	}
 
-	return &Cache{
+	p := &Cache{
 		db: db,
 
-		now: time.Now,
-	}, nil
+		expiration: caches.DefaultExpiredDuration,
+		now:        time.Now,
+	}
+	if c.ItemExpiration > 0 {
+		p.expiration = c.ItemExpiration
+	}
+	if c.Now != nil {
+		p.now = c.Now
+	}
+
+	if c.DeleteExpiredItems {
+		interval := caches.DefaultExpiredTaskTimer
+		if c.ExpiredTaskTimer > 0 {
+			interval = c.ExpiredTaskTimer
+		}
+		go p.expiredTask(ctx, interval)
+	}
+
+	return p, nil
 }
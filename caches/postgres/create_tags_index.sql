@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS condcache_tags_url ON condcache_tags (url);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS condcache_tags (
+    tag text,
+    url text REFERENCES condcache (url) ON DELETE CASCADE,
+    PRIMARY KEY (tag, url)
+);
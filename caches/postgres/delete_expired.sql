@@ -1,3 +1,3 @@
 DELETE FROM condcache
 WHERE
-    expired_at < (now () at time zone 'utc');
+    expired_at < $1;
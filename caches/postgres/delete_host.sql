@@ -0,0 +1,3 @@
+DELETE FROM condcache
+WHERE
+    substring(url FROM '^[^#]*#[^:/?#]+://(?:[^/?#@]*@)?([^/?#]*)') = $1;
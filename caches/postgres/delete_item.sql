@@ -0,0 +1,3 @@
+DELETE FROM condcache
+WHERE
+    url = $1;
@@ -0,0 +1,3 @@
+DELETE FROM condcache_tags
+WHERE
+    url = $1;
@@ -0,0 +1,3 @@
+DELETE FROM condcache
+WHERE
+    left (url, length ($1)) = $1;
@@ -0,0 +1,10 @@
+DELETE FROM condcache
+WHERE
+    url IN (
+        SELECT
+            url
+        FROM
+            condcache_tags
+        WHERE
+            tag = $1
+    );
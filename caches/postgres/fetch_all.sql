@@ -0,0 +1,5 @@
+SELECT
+    url,
+    item
+FROM
+    condcache;
@@ -0,0 +1,6 @@
+SELECT
+    item
+FROM
+    condcache
+WHERE
+    url = $1 AND expired_at >= $2 FOR UPDATE;
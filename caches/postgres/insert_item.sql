@@ -1,4 +1,7 @@
-BEGIN;
 INSERT INTO condcache (url, item, expired_at)
-VALUES ($1, $2, $3);
-COMMIT;
+VALUES ($1, $2, $3)
+ON CONFLICT (url) DO UPDATE
+SET
+    item = EXCLUDED.item,
+    expired_at = EXCLUDED.expired_at,
+    updated_at = (now () at time zone 'utc');
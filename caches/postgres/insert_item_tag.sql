@@ -0,0 +1,3 @@
+INSERT INTO condcache_tags (tag, url)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
@@ -1,3 +1,7 @@
-BEGIN;
-UPDATE condcache SET expired_at = $2, updated_at = $3 WHERE url = $1;
-COMMIT;
+UPDATE condcache
+SET
+    item = $2,
+    expired_at = $3,
+    updated_at = $4
+WHERE
+    url = $1;
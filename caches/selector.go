@@ -0,0 +1,43 @@
+package caches
+
+import (
+	"slices"
+	"strings"
+)
+
+// Selector selects cache items by exact key, key prefix, URL host or tag. Every field
+// that is set must match. The zero Selector selects every item.
+type Selector struct {
+	Key    string `json:"key,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Host   string `json:"host,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+}
+
+// Match reports whether the item stored under k with tags is selected by s.
+func (s Selector) Match(k string, tags []string) bool {
+	switch {
+	case s.Key != "" && k != s.Key:
+		return false
+	case s.Prefix != "" && !strings.HasPrefix(k, s.Prefix):
+		return false
+	case s.Host != "" && KeyHost(k) != s.Host:
+		return false
+	case s.Tag != "" && !slices.Contains(tags, s.Tag):
+		return false
+	}
+
+	return true
+}
+
+// Single reports whether exactly one field of s is set, as purges require.
+func (s Selector) Single() bool {
+	set := 0
+	for _, v := range []string{s.Key, s.Prefix, s.Host, s.Tag} {
+		if v != "" {
+			set++
+		}
+	}
+
+	return set == 1
+}
@@ -0,0 +1,385 @@
+// Command condcache-proxy runs a caching proxy so that services and tools not written in
+// Go can share a go-cond-cache cache. In reverse mode it sits in front of one or more
+// upstream servers. In forward mode clients use it through HTTP_PROXY and HTTPS_PROXY.
+//
+// Usage:
+//
+//	condcache-proxy -route /github/=https://api.github.com -backend postgres -postgres-dsn postgres://...
+//	condcache-proxy -mode forward -mitm-ca-cert ca.pem -mitm-ca-key ca-key.pem
+//	condcache-proxy -mode forward -listen :3128 -allow 10.0.0.0/8,192.168.1.20
+//	condcache-proxy -route /=https://example.com -local-max-bytes 268435456 -local-snapshot cache.snapshot
+//	condcache-proxy -config proxy.json
+//
+// In forward mode, HTTPS traffic is only cached when -mitm-ca-cert and -mitm-ca-key are
+// set. The certificate authority is generated and written to those paths if they do not
+// exist yet, and must be trusted by the clients of the proxy.
+//
+// A forward proxy reachable by anyone is an open proxy, so forward mode listens on the
+// loopback interface by default. Listening on any other address requires -allow, the
+// addresses and networks of the clients allowed to use the proxy; other clients are
+// answered with 403 Forbidden.
+//
+// Flags given on the command line take precedence over the configuration file.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/internal/backend"
+	"github.com/dgduncan/go-cond-cache/proxy"
+)
+
+const (
+	modeReverse = "reverse"
+	modeForward = "forward"
+
+	caFileMode = 0o600
+	healthPath = "/healthz"
+
+	defaultListen          = ":8080"
+	defaultForwardListen   = "127.0.0.1:8080"
+	defaultStatusHeader    = "X-Cache-Status"
+	defaultShutdownTimeout = 10 * time.Second
+	readHeaderTimeout      = 10 * time.Second
+)
+
+type config struct {
+	Mode            string         `json:"mode"`
+	MITMCACert      string         `json:"mitm_ca_cert"`
+	MITMCAKey       string         `json:"mitm_ca_key"`
+	Listen          string         `json:"listen"`
+	Allow           []string       `json:"allow"`
+	StatusHeader    string         `json:"status_header"`
+	ShutdownTimeout string         `json:"shutdown_timeout"`
+	Backend         backend.Config `json:"backend"`
+	Routes          []proxy.Route  `json:"routes"`
+}
+
+// routeFlag collects repeated -route prefix=upstream flags.
+type routeFlag []proxy.Route
+
+func (rf *routeFlag) String() string {
+	routes := make([]string, 0, len(*rf))
+	for _, r := range *rf {
+		routes = append(routes, r.Prefix+"="+r.Upstream)
+	}
+	return strings.Join(routes, ",")
+}
+
+func (rf *routeFlag) Set(value string) error {
+	prefix, upstream, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("route %q is not of the form prefix=upstream", value)
+	}
+	*rf = append(*rf, proxy.Route{Prefix: prefix, Upstream: upstream, StripPrefix: prefix != "/"})
+	return nil
+}
+
+func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	if err := run(logger); err != nil {
+		logger.Error("proxy failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(logger *slog.Logger) error {
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cache, closeCache, err := backend.Open(ctx, cfg.Backend)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeCache(); closeErr != nil {
+			logger.Warn("error closing cache", "error", closeErr)
+		}
+	}()
+
+	transport := gocondcache.New(cache, &gocondcache.Config{StatusHeader: cfg.StatusHeader}, nil, logger)(
+		http.DefaultTransport)
+
+	handler, err := newHandler(cfg, transport, logger)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           withHealth(handler),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	shutdownTimeout, err := time.ParseDuration(cfg.ShutdownTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid shutdown timeout: %w", err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		logger.InfoContext(ctx, "proxy listening", "addr", cfg.Listen, "mode", cfg.Mode, "backend", cfg.Backend.Type)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.InfoContext(ctx, "shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err = <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func newHandler(cfg config, transport http.RoundTripper, logger *slog.Logger) (http.Handler, error) {
+	switch cfg.Mode {
+	case modeReverse:
+		reverse, err := proxy.NewReverseProxy(cfg.Routes, transport, logger)
+		if err != nil {
+			return nil, err
+		}
+		return proxy.AccessLog(reverse, logger, cfg.StatusHeader), nil
+	case modeForward:
+		var ca *proxy.CertificateAuthority
+		if cfg.MITMCACert != "" || cfg.MITMCAKey != "" {
+			var err error
+			if ca, err = loadOrCreateCA(cfg.MITMCACert, cfg.MITMCAKey); err != nil {
+				return nil, err
+			}
+		}
+		allowed, err := allowedClients(cfg.Listen, cfg.Allow)
+		if err != nil {
+			return nil, err
+		}
+		forward := proxy.NewForwardProxy(transport, &proxy.ForwardConfig{CA: ca}, logger)
+		return proxy.AccessLog(allowClients(forward, allowed), logger, cfg.StatusHeader), nil
+	}
+
+	return nil, fmt.Errorf("unknown mode %q", cfg.Mode)
+}
+
+// allowedClients parses the allow-list of a forward proxy listening on listen. The list
+// may only be empty when the proxy listens on a loopback address.
+func allowedClients(listen string, allow []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(allow))
+	for _, a := range allow {
+		a = strings.TrimSpace(a)
+		prefix, err := netip.ParsePrefix(a)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(a)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid allowed client %q: %w", a, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if len(prefixes) > 0 {
+		return prefixes, nil
+	}
+
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address: %w", err)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && addr.IsLoopback() {
+		return nil, nil
+	}
+	if host == "localhost" {
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("forward mode listening on %q requires -allow, or it is an open proxy", listen)
+}
+
+// allowClients answers 403 Forbidden to clients outside of allowed. A nil list allows
+// every client.
+func allowClients(next http.Handler, allowed []netip.Prefix) http.Handler {
+	if allowed == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err == nil {
+			addr := addrPort.Addr().Unmap()
+			for _, prefix := range allowed {
+				if prefix.Contains(addr) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	})
+}
+
+// withHealth answers health checks sent to the proxy itself. Requests proxied in forward
+// mode carry absolute URLs, so they never reach the health endpoint.
+func withHealth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == healthPath && !r.URL.IsAbs() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loadOrCreateCA loads the interception certificate authority from disk, generating
+// and saving a new one if the certificate does not exist yet.
+func loadOrCreateCA(certPath, keyPath string) (*proxy.CertificateAuthority, error) {
+	if certPath == "" || keyPath == "" {
+		return nil, errors.New("both -mitm-ca-cert and -mitm-ca-key are required")
+	}
+
+	certPEM, err := os.ReadFile(filepath.Clean(certPath))
+	if err == nil {
+		keyPEM, keyErr := os.ReadFile(filepath.Clean(keyPath))
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		return proxy.LoadCertificateAuthority(certPEM, keyPEM)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ca, err := proxy.NewCertificateAuthority()
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ca.KeyPEM()
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(keyPath, keyPEM, caFileMode); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(certPath, ca.CertificatePEM(), caFileMode); err != nil {
+		return nil, err
+	}
+
+	return ca, nil
+}
+
+func loadConfig(args []string) (config, error) {
+	cfg := config{
+		Mode:            modeReverse,
+		StatusHeader:    defaultStatusHeader,
+		ShutdownTimeout: defaultShutdownTimeout.String(),
+		Backend:         backend.Config{Type: backend.TypeLocal},
+	}
+
+	fs := flag.NewFlagSet("condcache-proxy", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to a JSON configuration file")
+	mode := fs.String("mode", "", "proxy mode: reverse or forward (default reverse)")
+	caCert := fs.String("mitm-ca-cert", "", "forward mode: path of the interception CA certificate")
+	caKey := fs.String("mitm-ca-key", "", "forward mode: path of the interception CA private key")
+	listen := fs.String("listen", "",
+		"address to listen on (default "+defaultListen+", or "+defaultForwardListen+" in forward mode)")
+	allow := fs.String("allow", "", "forward mode: comma separated addresses and networks of the allowed clients")
+	backendType := fs.String("backend", "", "cache backend: local, postgres, dynamodb or disk (default local)")
+	postgresDSN := fs.String("postgres-dsn", "", "PostgreSQL connection string")
+	dynamoTable := fs.String("dynamodb-table", "", "DynamoDB table name")
+	dynamoRegion := fs.String("dynamodb-region", "", "DynamoDB region")
+	dynamoEndpoint := fs.String("dynamodb-endpoint", "", "DynamoDB endpoint override, eg. for DynamoDB local")
+	localMaxEntries := fs.Int("local-max-entries", 0, "local backend: maximum number of entries, zero for no limit")
+	localMaxBytes := fs.Int64("local-max-bytes", 0, "local backend: maximum size of the entries in bytes, zero for no limit")
+	diskDir := fs.String("disk-dir", "", "disk backend: directory of the cache")
+	diskMaxBytes := fs.Int64("disk-max-bytes", 0, "disk backend: maximum size of the cache in bytes, zero for no limit")
+	localSnapshot := fs.String("local-snapshot", "", "local backend: file the cache is saved to and restored from")
+	var routes routeFlag
+	fs.Var(&routes, "route", "upstream route of the form prefix=upstream, may be repeated")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return cfg, err
+		}
+		if err = json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid configuration file: %w", err)
+		}
+	}
+
+	overrides := []struct {
+		flag   string
+		target *string
+	}{
+		{*mode, &cfg.Mode},
+		{*caCert, &cfg.MITMCACert},
+		{*caKey, &cfg.MITMCAKey},
+		{*listen, &cfg.Listen},
+		{*backendType, &cfg.Backend.Type},
+		{*postgresDSN, &cfg.Backend.PostgresDSN},
+		{*dynamoTable, &cfg.Backend.DynamoDBTable},
+		{*dynamoRegion, &cfg.Backend.DynamoDBRegion},
+		{*dynamoEndpoint, &cfg.Backend.DynamoDBEndpoint},
+		{*localSnapshot, &cfg.Backend.LocalSnapshotPath},
+		{*diskDir, &cfg.Backend.DiskDir},
+	}
+	for _, o := range overrides {
+		if o.flag != "" {
+			*o.target = o.flag
+		}
+	}
+	if *localMaxEntries > 0 {
+		cfg.Backend.LocalMaxEntries = *localMaxEntries
+	}
+	if *localMaxBytes > 0 {
+		cfg.Backend.LocalMaxBytes = *localMaxBytes
+	}
+	if *diskMaxBytes > 0 {
+		cfg.Backend.DiskMaxBytes = *diskMaxBytes
+	}
+	if len(routes) > 0 {
+		cfg.Routes = routes
+	}
+	if *allow != "" {
+		cfg.Allow = strings.Split(*allow, ",")
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = defaultListen
+		if cfg.Mode == modeForward {
+			cfg.Listen = defaultForwardListen
+		}
+	}
+
+	return cfg, nil
+}
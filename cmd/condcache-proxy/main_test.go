@@ -0,0 +1,250 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAllowedClients(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		listen  string
+		allow   []string
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		{
+			name:   "loopback without allow-list",
+			listen: "127.0.0.1:8080",
+		},
+		{
+			name:   "ipv6 loopback without allow-list",
+			listen: "[::1]:8080",
+		},
+		{
+			name:   "localhost without allow-list",
+			listen: "localhost:8080",
+		},
+		{
+			name:    "all interfaces without allow-list",
+			listen:  ":8080",
+			wantErr: true,
+		},
+		{
+			name:    "public address without allow-list",
+			listen:  "192.0.2.1:8080",
+			wantErr: true,
+		},
+		{
+			name:    "invalid listen address",
+			listen:  "8080",
+			wantErr: true,
+		},
+		{
+			name:   "addresses and networks",
+			listen: ":8080",
+			allow:  []string{"10.0.0.0/8", " 192.168.1.20", "2001:db8::/32"},
+			want: []netip.Prefix{
+				netip.MustParsePrefix("10.0.0.0/8"),
+				netip.MustParsePrefix("192.168.1.20/32"),
+				netip.MustParsePrefix("2001:db8::/32"),
+			},
+		},
+		{
+			name:    "invalid entry",
+			listen:  ":8080",
+			allow:   []string{"10.0.0.0/8", "not-an-address"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := allowedClients(tt.listen, tt.allow)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("allowedClients() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("allowedClients() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowClients(t *testing.T) {
+	t.Parallel()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := allowClients(next, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       int
+	}{
+		{name: "inside network", remoteAddr: "10.1.2.3:51000", want: http.StatusNoContent},
+		{name: "ipv4-mapped ipv6", remoteAddr: "[::ffff:10.1.2.3]:51000", want: http.StatusNoContent},
+		{name: "ipv6 inside network", remoteAddr: "[2001:db8::1]:51000", want: http.StatusNoContent},
+		{name: "outside network", remoteAddr: "192.168.1.20:51000", want: http.StatusForbidden},
+		{name: "unparsable address", remoteAddr: "pipe", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithHealth(t *testing.T) {
+	t.Parallel()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := withHealth(next)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   int
+	}{
+		{name: "health check", method: http.MethodGet, target: healthPath, want: http.StatusOK},
+		{name: "other path", method: http.MethodGet, target: "/other", want: http.StatusNoContent},
+		{name: "other method", method: http.MethodPost, target: healthPath, want: http.StatusNoContent},
+		{
+			name:   "proxied request",
+			method: http.MethodGet,
+			target: "http://example.com" + healthPath,
+			want:   http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "proxy.json")
+	err := os.WriteFile(configPath, []byte(`{
+		"mode": "forward",
+		"listen": "127.0.0.1:3128",
+		"allow": ["10.0.0.0/8"],
+		"routes": [{"prefix": "/", "upstream": "https://example.com"}]
+	}`), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write configuration file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantMode   string
+		wantListen string
+		wantAllow  []string
+		wantErr    bool
+	}{
+		{
+			name:       "defaults",
+			wantMode:   modeReverse,
+			wantListen: defaultListen,
+		},
+		{
+			name:       "forward mode listens on loopback",
+			args:       []string{"-mode", "forward"},
+			wantMode:   modeForward,
+			wantListen: defaultForwardListen,
+		},
+		{
+			name:       "allow-list flag",
+			args:       []string{"-mode", "forward", "-listen", ":3128", "-allow", "10.0.0.0/8,192.168.1.20"},
+			wantMode:   modeForward,
+			wantListen: ":3128",
+			wantAllow:  []string{"10.0.0.0/8", "192.168.1.20"},
+		},
+		{
+			name:       "configuration file",
+			args:       []string{"-config", configPath},
+			wantMode:   modeForward,
+			wantListen: "127.0.0.1:3128",
+			wantAllow:  []string{"10.0.0.0/8"},
+		},
+		{
+			name:       "flags override the configuration file",
+			args:       []string{"-config", configPath, "-listen", ":3128", "-allow", "192.168.1.20"},
+			wantMode:   modeForward,
+			wantListen: ":3128",
+			wantAllow:  []string{"192.168.1.20"},
+		},
+		{
+			name:    "malformed route",
+			args:    []string{"-route", "https://example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "missing configuration file",
+			args:    []string{"-config", filepath.Join(t.TempDir(), "missing.json")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := loadConfig(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if cfg.Mode != tt.wantMode {
+				t.Errorf("mode = %q, want %q", cfg.Mode, tt.wantMode)
+			}
+			if cfg.Listen != tt.wantListen {
+				t.Errorf("listen = %q, want %q", cfg.Listen, tt.wantListen)
+			}
+			if !slices.Equal(cfg.Allow, tt.wantAllow) {
+				t.Errorf("allow = %v, want %v", cfg.Allow, tt.wantAllow)
+			}
+		})
+	}
+}
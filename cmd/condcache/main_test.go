@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+func testTime() time.Time {
+	return time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func newTestCache(t *testing.T) *local.BasicCache {
+	t.Helper()
+
+	entries := map[string]time.Duration{
+		"GET#https://a.example.com/1":     time.Hour,
+		"GET#https://a.example.com/2":     -time.Hour,
+		"GET#https://b.example.com/1":     time.Hour,
+		"GET#https://b.example.com/2?x=1": time.Hour,
+	}
+	items := make(map[string]*gocondcache.CacheItem, len(entries))
+	for k, ttl := range entries {
+		items[k] = &gocondcache.CacheItem{
+			ETAG:       `"` + k + `"`,
+			Response:   []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"),
+			Expiration: testTime().Add(ttl),
+		}
+	}
+
+	cache := gocondcachetest.NewLocalCache(gocondcachetest.NewClock(testTime()))
+	gocondcachetest.Seed(t, cache, items)
+
+	return cache
+}
+
+func TestCommands(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		command  string
+		args     []string
+		contains []string
+		remains  int
+	}{
+		{
+			name:     "list by host",
+			command:  "list",
+			args:     []string{"-host", "a.example.com"},
+			contains: []string{"GET#https://a.example.com/1", "expired", "fresh"},
+			remains:  4,
+		},
+		{
+			name:     "show",
+			command:  "show",
+			args:     []string{"GET#https://a.example.com/2"},
+			contains: []string{"expired", "HTTP/1.1 200 OK"},
+			remains:  4,
+		},
+		{
+			name:     "purge by prefix",
+			command:  "purge",
+			args:     []string{"-prefix", "GET#https://b.example.com/"},
+			contains: []string{"purged 2 entries"},
+			remains:  2,
+		},
+		{
+			name:     "purge by key",
+			command:  "purge",
+			args:     []string{"-key", "GET#https://a.example.com/1"},
+			contains: []string{"purged 1 entries"},
+			remains:  3,
+		},
+		{
+			name:     "purge by missing key",
+			command:  "purge",
+			args:     []string{"-key", "GET#https://c.example.com/1"},
+			contains: []string{"purged 0 entries"},
+			remains:  4,
+		},
+		{
+			name:     "stats",
+			command:  "stats",
+			contains: []string{"Entries: 4 (3 fresh, 1 expired)", "Hosts:   2"},
+			remains:  4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := newTestCache(t)
+			var out bytes.Buffer
+			if err := runCommand(context.Background(), cache, tt.command, tt.args, &out, testTime); err != nil {
+				t.Fatalf("command failed: %v", err)
+			}
+
+			for _, want := range tt.contains {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("expected output to contain %q, got:\n%s", want, out.String())
+				}
+			}
+
+			count := 0
+			_ = cache.Range(context.Background(), func(string, *gocondcache.CacheItem) bool {
+				count++
+				return true
+			})
+			if count != tt.remains {
+				t.Errorf("expected %d remaining entries, got %d", tt.remains, count)
+			}
+		})
+	}
+}
+
+func TestStaleCommand(t *testing.T) {
+	t.Parallel()
+
+	cache := newTestCache(t)
+	var out bytes.Buffer
+	err := runCommand(context.Background(), cache, "stale", []string{"-host", "b.example.com"}, &out, testTime)
+	if err != nil {
+		t.Fatalf("command failed: %v", err)
+	}
+
+	// the local cache treats an item expiring exactly now as fresh, a second later it is stale
+	later := local.NewBasicCacheWithTimeFunc(func() time.Time { return testTime().Add(time.Second) })
+	_ = cache.Range(context.Background(), func(k string, v *gocondcache.CacheItem) bool {
+		_ = later.Set(context.Background(), k, v)
+		return true
+	})
+	if _, err = later.Get(context.Background(), "GET#https://b.example.com/1"); !errors.Is(err, caches.ErrCacheItemExpired) {
+		t.Errorf("expected entry to be stale, got %v", err)
+	}
+	if _, err = later.Get(context.Background(), "GET#https://a.example.com/1"); err != nil {
+		t.Errorf("expected other host to stay fresh, got %v", err)
+	}
+}
+
+func TestWarmCommand(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("warm"))
+	}))
+	defer origin.Close()
+
+	list := filepath.Join(t.TempDir(), "urls.txt")
+	if err := os.WriteFile(list, []byte(origin.URL+"/a\n"+origin.URL+"/b\n"), 0o600); err != nil {
+		t.Fatalf("failed to write url list: %v", err)
+	}
+
+	cache := local.NewBasicCache()
+	var out bytes.Buffer
+	if err := runCommand(context.Background(), &cache, "warm", []string{"-urls", list}, &out, time.Now); err != nil {
+		t.Fatalf("command failed: %v", err)
+	}
+	if strings.Count(out.String(), "MISS") != 2 {
+		t.Errorf("expected both URLs to be fetched, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := runCommand(context.Background(), &cache, "warm", []string{"-urls", list}, &out, time.Now); err != nil {
+		t.Fatalf("command failed: %v", err)
+	}
+	if strings.Count(out.String(), "FRESH") != 2 {
+		t.Errorf("expected both URLs to be skipped, got:\n%s", out.String())
+	}
+}
+
+func TestExportImportCommands(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cache.jsonl")
+	var out bytes.Buffer
+	if err := runCommand(context.Background(), newTestCache(t), "export", []string{"-o", path}, &out, testTime); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "exported 4 entries") {
+		t.Errorf("unexpected export output:\n%s", out.String())
+	}
+
+	cache := local.NewBasicCacheWithTimeFunc(testTime)
+	out.Reset()
+	if err := runCommand(context.Background(), &cache, "import", []string{path}, &out, testTime); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "imported 4 entries") {
+		t.Errorf("unexpected import output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := runCommand(context.Background(), &cache, "export", nil, &out, testTime); err != nil {
+		t.Fatalf("export to stdout failed: %v", err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 5 {
+		t.Errorf("expected a header and 4 entries, got %d lines:\n%s", lines, out.String())
+	}
+}
+
+func TestHARCommands(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cache.har")
+	var out bytes.Buffer
+	err := runCommand(context.Background(), newTestCache(t), "har", []string{"-host", "b.example.com", "-o", path}, &out, testTime)
+	if err != nil {
+		t.Fatalf("har failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "exported 2 entries") {
+		t.Errorf("unexpected har output:\n%s", out.String())
+	}
+
+	cache := local.NewBasicCacheWithTimeFunc(testTime)
+	out.Reset()
+	if err = runCommand(context.Background(), &cache, "import-har", []string{path}, &out, testTime); err != nil {
+		t.Fatalf("import-har failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "imported 2 entries") {
+		t.Errorf("unexpected import-har output:\n%s", out.String())
+	}
+	if _, err = cache.Get(context.Background(), "GET#https://b.example.com/2?x=1"); err != nil {
+		t.Errorf("expected the imported entry to be fresh, got %v", err)
+	}
+
+	out.Reset()
+	if err = runCommand(context.Background(), &cache, "har", []string{"-key", "GET#https://b.example.com/1"}, &out, testTime); err != nil {
+		t.Fatalf("har to stdout failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `"url": "https://b.example.com/1"`) {
+		t.Errorf("expected a HAR document, got:\n%s", out.String())
+	}
+}
+
+func TestLocalSnapshot(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	source := local.New(nil, nil)
+	_ = source.Set(ctx, "GET#https://a.example.com/1", &gocondcache.CacheItem{
+		ETAG:       `"a"`,
+		Response:   []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"),
+		Expiration: time.Now().Add(time.Hour),
+	})
+	if err := source.SaveSnapshot(ctx, path); err != nil {
+		t.Fatalf("failed to save the snapshot: %v", err)
+	}
+	before, _ := os.ReadFile(path)
+
+	var out bytes.Buffer
+	if err := run(ctx, []string{"-backend", "local", "-local-snapshot", path, "list"}, &out); err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "GET#https://a.example.com/1") {
+		t.Errorf("expected the entry of the snapshot to be listed, got:\n%s", out.String())
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "missing snapshot flag", args: []string{"-backend", "local", "list"}},
+		{name: "missing snapshot", args: []string{"-backend", "local", "-local-snapshot", path + ".missing", "list"}},
+		{
+			name: "command modifying the cache",
+			args: []string{"-backend", "local", "-local-snapshot", path, "purge", "-host", "a.example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		if err := run(ctx, tt.args, &out); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	// the snapshot is never written back
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Error("expected the snapshot to be left unchanged")
+	}
+}
@@ -0,0 +1,314 @@
+package gocondcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxBufferSize is the default largest response body buffered to generate an ETag.
+const DefaultMaxBufferSize = 8 << 20
+
+// ConditionalConfig defines the configuration options of the conditional request middleware.
+type ConditionalConfig struct {
+	// WeakETags generates weak validators (W/"...") instead of strong ones. Weak validators
+	// suit handlers whose output is semantically but not byte-for-byte stable.
+	WeakETags bool
+
+	// MaxBufferSize is the largest response body, in bytes, that is buffered to generate
+	// an ETag. Larger responses are streamed to the client without validators.
+	// Defaults to DefaultMaxBufferSize.
+	MaxBufferSize int
+
+	// Validators returns the current validators of the resource targeted by a request with
+	// an unsafe method, such as PUT or DELETE, so that If-Match and If-Unmodified-Since can
+	// be evaluated before the handler runs. If nil, preconditions of unsafe requests are
+	// left to the handler.
+	Validators func(r *http.Request) (etag string, lastModified *time.Time, ok bool)
+}
+
+// NewConditionalHandler creates a server-side middleware that adds validators to the
+// responses of an http.Handler and answers conditional requests on its behalf.
+//
+// Responses to GET and HEAD requests are buffered and hashed to generate an ETag, unless
+// the handler already set one. A Last-Modified header set by the handler is kept and used
+// for date based preconditions. The preconditions are then evaluated in the order defined
+// by RFC 9110 section 13.2.2, answering with 304 Not Modified or 412 Precondition Failed
+// when they apply. Range requests are served from the buffered representation when
+// If-Range, if present, matches it.
+func NewConditionalHandler(config *ConditionalConfig) func(http.Handler) http.Handler {
+	c := ConditionalConfig{}
+	if config != nil {
+		c = *config
+	}
+	if c.MaxBufferSize <= 0 {
+		c.MaxBufferSize = DefaultMaxBufferSize
+	}
+
+	return func(next http.Handler) http.Handler {
+		return &conditionalHandler{next: next, c: c}
+	}
+}
+
+type conditionalHandler struct {
+	next http.Handler
+
+	c ConditionalConfig
+}
+
+func (h *conditionalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.serveUnsafe(w, r)
+		return
+	}
+
+	// the handler always renders the full representation, ranges are applied afterwards.
+	// HEAD requests are served as GET so that both share the same validators, and the body
+	// is dropped when the response is written.
+	rangeHeader := r.Header.Get(headerRange)
+	ifRange := r.Header.Get(headerIfRange)
+	head := r.Method == http.MethodHead
+	inner := r
+	if rangeHeader != "" || head {
+		inner = r.Clone(r.Context())
+		inner.Method = http.MethodGet
+		inner.Header.Del(headerRange)
+		inner.Header.Del(headerIfRange)
+	}
+
+	bw := &bufferedResponseWriter{w: w, status: http.StatusOK, limit: h.c.MaxBufferSize, head: head}
+	h.next.ServeHTTP(bw, inner)
+	if bw.passthrough {
+		return
+	}
+
+	if bw.status != http.StatusOK {
+		bw.flush()
+		return
+	}
+
+	header := w.Header()
+	etag := header.Get(headerETAG)
+	if etag == "" {
+		etag = generateETag(bw.body.Bytes(), h.c.WeakETags)
+		header.Set(headerETAG, etag)
+	}
+	lastModified := parseHTTPDate(header.Get(headerLastModified))
+
+	switch checkPreconditions(r, etag, lastModified) {
+	case http.StatusNotModified:
+		writeNotModified(w)
+		return
+	case http.StatusPreconditionFailed:
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	if rangeHeader != "" && (ifRange == "" || ifRangeMatches(ifRange, etag, lastModified)) {
+		var modTime time.Time
+		if lastModified != nil {
+			modTime = *lastModified
+		}
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(bw.body.Bytes()))
+		return
+	}
+
+	bw.flush()
+}
+
+// serveUnsafe evaluates the preconditions of a state-changing request against the
+// validators returned by the Validators hook before running the handler.
+func (h *conditionalHandler) serveUnsafe(w http.ResponseWriter, r *http.Request) {
+	if h.c.Validators == nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	etag, lastModified, ok := h.c.Validators(r)
+	if !ok {
+		// the resource does not exist, so only If-Match: * style preconditions can fail
+		if r.Header.Get(headerIfMatch) != "" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	if checkPreconditions(r, etag, lastModified) != 0 {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// checkPreconditions evaluates the conditional headers of r against the validators of
+// the selected representation, following RFC 9110 section 13.2.2. It returns 0 when the
+// request should proceed, http.StatusNotModified or http.StatusPreconditionFailed.
+func checkPreconditions(r *http.Request, etag string, lastModified *time.Time) int {
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	if ifMatch := r.Header.Get(headerIfMatch); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := parseHTTPDate(r.Header.Get(headerIfUnmodifiedSince)); ius != nil && lastModified != nil {
+		if lastModified.Truncate(time.Second).After(*ius) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := r.Header.Get(headerIfNoneMatch); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, etag, false) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := parseHTTPDate(r.Header.Get(headerIfModifiedSince)); safe && ims != nil && lastModified != nil {
+		if !lastModified.Truncate(time.Second).After(*ims) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// ifRangeMatches reports whether an If-Range value, either an entity-tag or a date,
+// matches the selected representation. Entity-tags use the strong comparison function.
+func ifRangeMatches(ifRange, etag string, lastModified *time.Time) bool {
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etagsMatch(ifRange, etag, true)
+	}
+
+	date := parseHTTPDate(ifRange)
+	return date != nil && lastModified != nil && lastModified.Truncate(time.Second).Equal(*date)
+}
+
+// etagListMatches reports whether etag matches one of the entity-tags of an If-Match or
+// If-None-Match header, or the header is "*".
+func etagListMatches(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return etag != ""
+	}
+
+	for _, candidate := range strings.Split(list, ",") {
+		if etagsMatch(strings.TrimSpace(candidate), etag, strong) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// etagsMatch compares two entity-tags with the strong or weak comparison function of
+// RFC 9110 section 8.8.3.2.
+func etagsMatch(a, b string, strong bool) bool {
+	if a == "" || b == "" {
+		return false
+	}
+
+	if strong {
+		return !strings.HasPrefix(a, "W/") && a == b
+	}
+
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+func generateETag(body []byte, weak bool) string {
+	digest := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(digest[:16]) + `"`
+	if weak {
+		return "W/" + etag
+	}
+
+	return etag
+}
+
+func parseHTTPDate(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return nil
+	}
+
+	return &t
+}
+
+// writeNotModified writes a 304 response, dropping the representation metadata that
+// RFC 9110 section 15.4.5 says it should not carry.
+func writeNotModified(w http.ResponseWriter) {
+	header := w.Header()
+	header.Del("Content-Type")
+	header.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// bufferedResponseWriter holds back the status and body written by a handler so that
+// they can be hashed before anything is sent. Handlers write headers straight to the
+// wrapped writer. Once the body grows past limit everything is streamed as is.
+type bufferedResponseWriter struct {
+	w http.ResponseWriter
+
+	status      int
+	body        bytes.Buffer
+	limit       int
+	passthrough bool
+	// head drops the body, which is only buffered to generate the ETag of a HEAD response
+	head bool
+}
+
+func (bw *bufferedResponseWriter) Header() http.Header {
+	return bw.w.Header()
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(status int) {
+	if bw.passthrough {
+		return
+	}
+	bw.status = status
+}
+
+func (bw *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if bw.passthrough {
+		if bw.head {
+			return len(p), nil
+		}
+		return bw.w.Write(p)
+	}
+
+	if bw.body.Len()+len(p) > bw.limit {
+		bw.passthrough = true
+		bw.w.WriteHeader(bw.status)
+		if bw.head {
+			return len(p), nil
+		}
+		if _, err := bw.w.Write(bw.body.Bytes()); err != nil {
+			return 0, err
+		}
+		return bw.w.Write(p)
+	}
+
+	return bw.body.Write(p)
+}
+
+func (bw *bufferedResponseWriter) flush() {
+	if bw.head {
+		if bw.w.Header().Get("Content-Length") == "" {
+			bw.w.Header().Set("Content-Length", strconv.Itoa(bw.body.Len()))
+		}
+		bw.w.WriteHeader(bw.status)
+		return
+	}
+
+	bw.w.WriteHeader(bw.status)
+	_, _ = bw.w.Write(bw.body.Bytes())
+}
@@ -0,0 +1,209 @@
+package gocondcache_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+)
+
+func TestConditionalHandler(t *testing.T) {
+	t.Parallel()
+
+	lastModified := "Wed, 21 Oct 2015 07:28:00 GMT"
+	handler := gocondcache.NewConditionalHandler(nil)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Last-Modified", lastModified)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello world"))
+	}))
+
+	// the first request discovers the generated ETag
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag to be generated")
+	}
+	if rec.Header().Get("Last-Modified") != lastModified {
+		t.Errorf("expected Last-Modified %s, got %s", lastModified, rec.Header().Get("Last-Modified"))
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		headers        map[string]string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "unconditional request",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "hello world",
+		},
+		{
+			name:           "If-None-Match matching",
+			method:         http.MethodGet,
+			headers:        map[string]string{"If-None-Match": `"other", ` + etag},
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:           "If-None-Match weak comparison",
+			method:         http.MethodGet,
+			headers:        map[string]string{"If-None-Match": "W/" + etag},
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:           "If-None-Match not matching",
+			method:         http.MethodGet,
+			headers:        map[string]string{"If-None-Match": `"other"`},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "hello world",
+		},
+		{
+			name:           "If-Modified-Since not modified",
+			method:         http.MethodGet,
+			headers:        map[string]string{"If-Modified-Since": lastModified},
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:   "If-None-Match takes precedence over If-Modified-Since",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"If-None-Match":     `"other"`,
+				"If-Modified-Since": lastModified,
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "hello world",
+		},
+		{
+			name:           "If-Match not matching",
+			method:         http.MethodGet,
+			headers:        map[string]string{"If-Match": `"other"`},
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "If-Match weak etag fails strong comparison",
+			method:         http.MethodGet,
+			headers:        map[string]string{"If-Match": "W/" + etag},
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "If-Unmodified-Since before modification",
+			method:         http.MethodGet,
+			headers:        map[string]string{"If-Unmodified-Since": "Tue, 20 Oct 2015 07:28:00 GMT"},
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Range with matching If-Range",
+			method:         http.MethodGet,
+			headers:        map[string]string{"Range": "bytes=0-4", "If-Range": etag},
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   "hello",
+		},
+		{
+			name:           "Range with stale If-Range returns full representation",
+			method:         http.MethodGet,
+			headers:        map[string]string{"Range": "bytes=0-4", "If-Range": `"other"`},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "hello world",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(tt.method, "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if body, _ := io.ReadAll(rec.Body); string(body) != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, string(body))
+			}
+		})
+	}
+}
+
+func TestConditionalHandlerUnsafeMethods(t *testing.T) {
+	t.Parallel()
+
+	handler := gocondcache.NewConditionalHandler(&gocondcache.ConditionalConfig{
+		Validators: func(*http.Request) (string, *time.Time, bool) {
+			return `"v1"`, nil, true
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name           string
+		ifMatch        string
+		expectedStatus int
+	}{
+		{name: "matching If-Match runs handler", ifMatch: `"v1"`, expectedStatus: http.StatusNoContent},
+		{name: "stale If-Match fails", ifMatch: `"v0"`, expectedStatus: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodPut, "/", nil)
+			req.Header.Set("If-Match", tt.ifMatch)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestConditionalHandlerHead(t *testing.T) {
+	t.Parallel()
+
+	handler := gocondcache.NewConditionalHandler(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// like http.ServeContent, the handler writes no body for HEAD requests
+		if r.Method != http.MethodHead {
+			w.Write([]byte("hello world"))
+		}
+	}))
+
+	get := httptest.NewRecorder()
+	handler.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/", nil))
+	head := httptest.NewRecorder()
+	handler.ServeHTTP(head, httptest.NewRequest(http.MethodHead, "/", nil))
+
+	if etag := get.Header().Get("ETag"); etag == "" || head.Header().Get("ETag") != etag {
+		t.Errorf("expected HEAD to have the ETag %s of GET, got %s", etag, head.Header().Get("ETag"))
+	}
+	if head.Body.Len() != 0 {
+		t.Errorf("expected no body for HEAD, got %q", head.Body.String())
+	}
+	if head.Header().Get("Content-Length") != "11" {
+		t.Errorf("expected the Content-Length of the GET representation, got %q", head.Header().Get("Content-Length"))
+	}
+
+	// a HEAD request matching the ETag is not modified
+	req := httptest.NewRequest(http.MethodHead, "/", nil)
+	req.Header.Set("If-None-Match", get.Header().Get("ETag"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected status %d, got %d", http.StatusNotModified, rec.Code)
+	}
+}
@@ -2,14 +2,66 @@ package gocondcache
 
 import "time"
 
+// BackendErrorPolicy controls how the transport reacts when the Cache returns an error
+// other than a missing or expired item, e.g. because the backend is down or timed out.
+type BackendErrorPolicy int
+
+const (
+	// FailOpen treats backend errors as a cache miss and sends the request to the origin.
+	FailOpen BackendErrorPolicy = iota
+	// FailClosed returns the backend error to the caller without contacting the origin.
+	FailClosed
+)
+
 type Config struct {
 	// DomainOverrides allow for users to override the caching-directive responses from
 	// upstream servers and cache for an arbitrary amount of time. Once expired, will attempt
 	// to revalidate the cached item with a conditional request. If upstream server does not return
 	// a cache-control header Expires, or Etag header, caching will be completely bypassed.
 	DomainOverrides []DomainOverride
+
+	// BackendErrorPolicy controls how errors returned by Cache.Get are handled.
+	// Errors returned by Cache.Set and Cache.Update are always logged and ignored.
+	BackendErrorPolicy BackendErrorPolicy
+
+	// ReadTimeout bounds each call to Cache.Get. Zero means the call is only bounded
+	// by the request context.
+	ReadTimeout time.Duration
+
+	// WriteTimeout bounds each call to Cache.Set and Cache.Update. Zero means the call
+	// is only bounded by the request context.
+	WriteTimeout time.Duration
+
+	// BodyCaching enables caching of requests that carry a body, such as POST or QUERY
+	// requests used for idempotent reads, keyed on a digest of their body. Requests with
+	// methods other than GET and HEAD bypass the cache when it is nil. Earlier versions
+	// cached them keyed on their method and URL alone, ignoring the body, so it must be
+	// set to keep caching them.
+	BodyCaching *BodyCachingConfig
+
+	// StatusHeader, when set, is the name of a response header the transport sets to the
+	// CacheStatus of each response, e.g. X-Cache-Status: HIT.
+	StatusHeader string
+
+	// RefreshAhead enables revalidating frequently requested entries in the background
+	// before they expire, so that requests to them keep being served from the cache.
+	RefreshAhead *RefreshAheadConfig
+}
+
+// BodyCachingConfig defines which requests are cached keyed on a digest of their body.
+type BodyCachingConfig struct {
+	Methods []string // eg. POST, QUERY
+
+	Hosts []string // eg. api.example.com, matched against the request host. Empty matches every host.
+
+	// MaxBodySize is the largest request body, in bytes, that is buffered and cached.
+	// Requests with larger bodies are sent upstream uncached. Defaults to DefaultMaxBodySize.
+	MaxBodySize int64
 }
 
+// DefaultMaxBodySize is the default maximum size of a request body cached with BodyCaching.
+const DefaultMaxBodySize = 1 << 20
+
 type DomainOverride struct {
 	URI string // eg. misbehaving_caching_domain.com
 
@@ -19,6 +71,7 @@ type DomainOverride struct {
 // DefaultConfig returns a configuration with sensible defaults.
 func DefaultConfig() Config {
 	return Config{
-		DomainOverrides: nil,
+		DomainOverrides:    nil,
+		BackendErrorPolicy: FailOpen,
 	}
 }
@@ -0,0 +1,103 @@
+package gocondcache
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey int
+
+const (
+	contextKeyTTL contextKey = iota
+	contextKeyStaleWindow
+	contextKeyTags
+	contextKeyRevalidate
+	contextKeyStatus
+	contextKeyBypass
+	contextKeyShared
+)
+
+// WithTTL returns a copy of ctx that overrides the time to cache of the response
+// to the request carrying it. The override takes precedence over both the
+// Cache-Control header of the response and any DomainOverride in the Config.
+func WithTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, contextKeyTTL, ttl)
+}
+
+// WithStaleWindow returns a copy of ctx that sets how long past its expiration the
+// response to the request carrying it may be served if revalidation fails.
+func WithStaleWindow(ctx context.Context, window time.Duration) context.Context {
+	return context.WithValue(ctx, contextKeyStaleWindow, window)
+}
+
+// WithTags returns a copy of ctx that attaches tags to the response cached for the
+// request carrying it. Tags already present in ctx are kept.
+func WithTags(ctx context.Context, tags ...string) context.Context {
+	existing := tagsFromContext(ctx)
+	merged := make([]string, 0, len(existing)+len(tags))
+	merged = append(merged, existing...)
+	merged = append(merged, tags...)
+
+	return context.WithValue(ctx, contextKeyTags, merged)
+}
+
+// WithBypass returns a copy of ctx that sends the request carrying it straight to the
+// wrapped transport, neither reading nor storing its response in the cache.
+func WithBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyBypass, true)
+}
+
+// WithSharedCache returns a copy of ctx that makes the transport store the response to
+// the request carrying it only if a shared cache may: responses marked no-store or
+// private, setting a cookie, or answering a request with an Authorization header without
+// being marked public or s-maxage are not stored.
+func WithSharedCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyShared, true)
+}
+
+func ttlFromContext(ctx context.Context) (time.Duration, bool) {
+	ttl, ok := ctx.Value(contextKeyTTL).(time.Duration)
+	return ttl, ok
+}
+
+func staleWindowFromContext(ctx context.Context) time.Duration {
+	window, _ := ctx.Value(contextKeyStaleWindow).(time.Duration)
+	return window
+}
+
+func tagsFromContext(ctx context.Context) []string {
+	tags, _ := ctx.Value(contextKeyTags).([]string)
+	return tags
+}
+
+// withRevalidate returns a copy of ctx that makes the transport revalidate a fresh
+// cache item as if it had expired.
+func withRevalidate(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyRevalidate, true)
+}
+
+// withStatus returns a copy of ctx into which the transport records the CacheStatus
+// of the response to the request carrying it.
+func withStatus(ctx context.Context, status *CacheStatus) context.Context {
+	return context.WithValue(ctx, contextKeyStatus, status)
+}
+
+func revalidateFromContext(ctx context.Context) bool {
+	revalidate, _ := ctx.Value(contextKeyRevalidate).(bool)
+	return revalidate
+}
+
+func statusFromContext(ctx context.Context) *CacheStatus {
+	status, _ := ctx.Value(contextKeyStatus).(*CacheStatus)
+	return status
+}
+
+func bypassFromContext(ctx context.Context) bool {
+	bypass, _ := ctx.Value(contextKeyBypass).(bool)
+	return bypass
+}
+
+func sharedCacheFromContext(ctx context.Context) bool {
+	shared, _ := ctx.Value(contextKeyShared).(bool)
+	return shared
+}
@@ -0,0 +1,157 @@
+package gocondcache_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+)
+
+func TestContextOverridesAreStored(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("ETag", `"report"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("report"))
+	}))
+	defer server.Close()
+
+	baseTime := testTime()
+	cache := local.NewBasicCacheWithTimeFunc(func() time.Time { return baseTime })
+	transport := gocondcache.New(
+		&cache,
+		nil,
+		func() time.Time { return baseTime },
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+	)(http.DefaultTransport)
+
+	ctx := gocondcache.WithTTL(context.Background(), 7*24*time.Hour)
+	ctx = gocondcache.WithStaleWindow(ctx, time.Hour)
+	ctx = gocondcache.WithTags(ctx, "reports")
+	ctx = gocondcache.WithTags(ctx, "weekly")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	item, err := cache.Get(context.Background(), fmt.Sprintf("GET#%s", server.URL))
+	if err != nil {
+		t.Fatalf("expected cache hit but got error: %v", err)
+	}
+
+	if want := baseTime.Add(7 * 24 * time.Hour); !item.Expiration.Equal(want) {
+		t.Errorf("expected expiration %s, got %s", want, item.Expiration)
+	}
+	if item.StaleWindow != time.Hour {
+		t.Errorf("expected stale window %s, got %s", time.Hour, item.StaleWindow)
+	}
+	if want := []string{"reports", "weekly"}; !reflect.DeepEqual(item.Tags, want) {
+		t.Errorf("expected tags %v, got %v", want, item.Tags)
+	}
+}
+
+func TestStaleWindowOnOriginError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		advance        time.Duration
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "stale item served within window",
+			advance:        30 * time.Minute,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "content",
+		},
+		{
+			name:           "origin error returned after window",
+			advance:        2 * time.Hour,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			requestCount := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				requestCount++
+				if requestCount > 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					w.Write([]byte("unavailable"))
+					return
+				}
+
+				w.Header().Set("ETag", `"abc"`)
+				w.Header().Set("Cache-Control", "max-age=1")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("content"))
+			}))
+			defer server.Close()
+
+			currentTime := testTime()
+			timeFunc := func() time.Time { return currentTime }
+
+			cache := local.NewBasicCacheWithTimeFunc(timeFunc)
+			client := &http.Client{Transport: gocondcache.New(
+				&cache,
+				nil,
+				timeFunc,
+				slog.New(slog.NewTextHandler(io.Discard, nil)),
+			)(http.DefaultTransport)}
+
+			ctx := gocondcache.WithStaleWindow(context.Background(), time.Hour)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+
+			resp1, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("first request failed: %v", err)
+			}
+			resp1.Body.Close()
+
+			currentTime = currentTime.Add(tt.advance)
+
+			resp2, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatalf("second request failed: %v", err)
+			}
+			defer resp2.Body.Close()
+
+			body, err := io.ReadAll(resp2.Body)
+			if err != nil {
+				t.Fatalf("failed to read response body: %v", err)
+			}
+
+			if resp2.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp2.StatusCode)
+			}
+			if string(body) != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, string(body))
+			}
+		})
+	}
+}
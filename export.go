@@ -0,0 +1,141 @@
+package gocondcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dgduncan/go-cond-cache/caches"
+)
+
+const (
+	// ExportFormat identifies the data written by Export.
+	ExportFormat = "go-cond-cache"
+	// ExportVersion is the version of the format written by Export. Import reads this
+	// version and the ones before it.
+	ExportVersion = 1
+)
+
+// ExportHeader is the first line of an export.
+type ExportHeader struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// ExportEntry is a line of an export, holding a cache item and its key.
+type ExportEntry struct {
+	Key          string     `json:"key"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	Expiration   time.Time  `json:"expiration"`
+	StaleWindow  string     `json:"stale_window,omitempty"` // in time.Duration notation, e.g. 1m30s
+	Tags         []string   `json:"tags,omitempty"`
+	Response     []byte     `json:"response"` // the HTTP/1.1 dump of the response, base64 encoded
+}
+
+// Export writes every item of cache to w as JSON Lines, so that they can be imported
+// into any other cache with Import. The first line is an ExportHeader, and each of the
+// following lines an ExportEntry. Times are written in RFC 3339 format, e.g.
+//
+//	{"format":"go-cond-cache","version":1,"exported_at":"2024-03-01T12:00:00Z"}
+//	{"key":"GET#https://example.com/","etag":"\"v1\"","expiration":"2024-03-01T13:00:00Z","response":"SFRUUC8xLjEgMjAwIE9LDQoNCg=="}
+//
+// It returns the number of items written.
+// Returns caches.ErrNotSupported if cache is not a Ranger.
+func Export(ctx context.Context, cache Cache, w io.Writer) (int, error) {
+	ranger, ok := cache.(Ranger)
+	if !ok {
+		return 0, caches.ErrNotSupported
+	}
+
+	enc := json.NewEncoder(w)
+	err := enc.Encode(ExportHeader{Format: ExportFormat, Version: ExportVersion, ExportedAt: time.Now().UTC()})
+	if err != nil {
+		return 0, err
+	}
+
+	exported := 0
+	var writeErr error
+	err = ranger.Range(ctx, func(k string, v *CacheItem) bool {
+		entry := ExportEntry{
+			Key:          k,
+			ETag:         v.ETAG,
+			LastModified: v.LastModified,
+			Expiration:   v.Expiration,
+			Tags:         v.Tags,
+			Response:     v.Response,
+		}
+		if v.StaleWindow > 0 {
+			entry.StaleWindow = v.StaleWindow.String()
+		}
+
+		if writeErr = enc.Encode(entry); writeErr != nil {
+			return false
+		}
+		exported++
+		return true
+	})
+
+	return exported, errors.Join(writeErr, err)
+}
+
+// Import reads an export written by Export from r and stores its items in cache,
+// replacing the items already stored under the same keys. Expired items are imported
+// too, so that they can be revalidated. It returns the number of items stored.
+// Returns an error wrapping caches.ErrInvalidExport if r is not an export or was
+// written by a newer version.
+func Import(ctx context.Context, cache Cache, r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+
+	var header ExportHeader
+	if err := dec.Decode(&header); err != nil {
+		return 0, fmt.Errorf("%w: reading header: %w", caches.ErrInvalidExport, err)
+	}
+	if header.Format != ExportFormat {
+		return 0, fmt.Errorf("%w: unknown format %q", caches.ErrInvalidExport, header.Format)
+	}
+	if header.Version < 1 || header.Version > ExportVersion {
+		return 0, fmt.Errorf("%w: unsupported version %d", caches.ErrInvalidExport, header.Version)
+	}
+
+	imported := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return imported, err
+		}
+
+		var entry ExportEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return imported, nil
+		}
+		if err != nil {
+			return imported, fmt.Errorf("%w: reading entry %d: %w", caches.ErrInvalidExport, imported+1, err)
+		}
+		if entry.Key == "" {
+			return imported, fmt.Errorf("%w: entry %d has no key", caches.ErrInvalidExport, imported+1)
+		}
+
+		item := &CacheItem{
+			ETAG:         entry.ETag,
+			LastModified: entry.LastModified,
+			Response:     entry.Response,
+			Expiration:   entry.Expiration,
+			Tags:         entry.Tags,
+		}
+		if entry.StaleWindow != "" {
+			if item.StaleWindow, err = time.ParseDuration(entry.StaleWindow); err != nil {
+				return imported, fmt.Errorf("%w: entry %d: %w", caches.ErrInvalidExport, imported+1, err)
+			}
+		}
+
+		if err = cache.Set(ctx, entry.Key, item); err != nil {
+			return imported, fmt.Errorf("storing %s: %w", entry.Key, err)
+		}
+		imported++
+	}
+}
@@ -0,0 +1,127 @@
+package gocondcache_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+func TestExportImport(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	lastModified := testTime().Add(-time.Hour)
+	items := map[string]*gocondcache.CacheItem{
+		"GET#https://example.com/": {
+			ETAG:         `"v1"`,
+			LastModified: &lastModified,
+			Response:     []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"),
+			Expiration:   testTime().Add(time.Hour),
+			Tags:         []string{"home", "pages"},
+			StaleWindow:  90 * time.Second,
+		},
+		"POST#https://example.com/graphql#abc": {
+			ETAG:       `W/"v2"`,
+			Response:   []byte{0, 1, 2, 255},
+			Expiration: testTime().Add(-time.Hour),
+		},
+	}
+
+	source := local.NewBasicCacheWithTimeFunc(testTime)
+	gocondcachetest.Seed(t, &source, items)
+
+	var buf bytes.Buffer
+	exported, err := gocondcache.Export(ctx, &source, &buf)
+	if err != nil || exported != len(items) {
+		t.Fatalf("expected %d items exported, got %d and error %v", len(items), exported, err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != len(items)+1 {
+		t.Errorf("expected a header and one line per item, got %d lines", lines)
+	}
+
+	target := local.NewBasicCacheWithTimeFunc(testTime)
+	imported, err := gocondcache.Import(ctx, &target, &buf)
+	if err != nil || imported != len(items) {
+		t.Fatalf("expected %d items imported, got %d and error %v", len(items), imported, err)
+	}
+
+	for k, expected := range items {
+		got, getErr := target.Get(ctx, k)
+		if getErr != nil && !errors.Is(getErr, caches.ErrCacheItemExpired) {
+			t.Fatalf("failed to get imported item %s: %v", k, getErr)
+		}
+		// times are compared after the round trip through RFC 3339
+		if !got.Expiration.Equal(expected.Expiration) ||
+			(expected.LastModified != nil && !got.LastModified.Equal(*expected.LastModified)) {
+			t.Errorf("%s: expected times %v and %v, got %v and %v",
+				k, expected.Expiration, expected.LastModified, got.Expiration, got.LastModified)
+		}
+		got.Expiration, got.LastModified = expected.Expiration, expected.LastModified
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected %+v, got %+v", k, expected, got)
+		}
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		export           string
+		expectedImported int
+	}{
+		{name: "empty", export: ""},
+		{name: "missing header", export: `{"key":"GET#https://example.com/","expiration":"2023-01-01T12:00:00Z"}` + "\n"},
+		{name: "unknown format", export: `{"format":"other","version":1}` + "\n"},
+		{name: "newer version", export: `{"format":"go-cond-cache","version":2}` + "\n"},
+		{
+			name: "malformed entry",
+			export: `{"format":"go-cond-cache","version":1}` + "\n" +
+				`{"key":"GET#https://example.com/a","expiration":"2023-01-01T12:00:00Z"}` + "\n" +
+				`{"key":` + "\n",
+			expectedImported: 1,
+		},
+		{
+			name:   "entry without key",
+			export: `{"format":"go-cond-cache","version":1}` + "\n" + `{"etag":"\"v1\""}` + "\n",
+		},
+		{
+			name:   "invalid stale window",
+			export: `{"format":"go-cond-cache","version":1}` + "\n" + `{"key":"GET#https://example.com/","stale_window":"soon"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := local.NewBasicCacheWithTimeFunc(testTime)
+			imported, err := gocondcache.Import(context.Background(), &cache, strings.NewReader(tt.export))
+			if !errors.Is(err, caches.ErrInvalidExport) {
+				t.Errorf("expected ErrInvalidExport, got %v", err)
+			}
+			if imported != tt.expectedImported {
+				t.Errorf("expected %d items imported, got %d", tt.expectedImported, imported)
+			}
+		})
+	}
+}
+
+func TestExportNotSupported(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	if _, err := gocondcache.Export(context.Background(), &failingCache{}, &buf); !errors.Is(err, caches.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+// Package gocondcachetest provides utilities for testing code that uses a
+// gocondcache.CacheTransport: a programmable origin server and a fake clock.
+//
+// The clock drives expiration. Pass its Now method as the time function of both the
+// transport and the cache, e.g. to local.NewBasicCacheWithTimeFunc or the Now field of
+// the postgres and dynamodb configurations, or use NewLocalCache and NewClient which do
+// so.
+package gocondcachetest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+)
+
+// Clock is a fake clock that only moves when it is advanced. It is safe for concurrent
+// use.
+type Clock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// NewClock returns a clock set to start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d and returns the new current time.
+func (c *Clock) Advance(d time.Duration) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// Set sets the current time of the clock to t.
+func (c *Clock) Set(t time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = t
+}
+
+// NewLocalCache returns an empty local cache whose items expire according to clock.
+func NewLocalCache(clock *Clock) *local.BasicCache {
+	cache := local.NewBasicCacheWithTimeFunc(clock.Now)
+	return &cache
+}
+
+// Seed stores items in cache under their keys, failing the test if one cannot be stored.
+func Seed(tb testing.TB, cache gocondcache.Cache, items map[string]*gocondcache.CacheItem) {
+	tb.Helper()
+
+	for k, v := range items {
+		if err := cache.Set(context.Background(), k, v); err != nil {
+			tb.Fatalf("failed to seed cache: %v", err)
+		}
+	}
+}
+
+// NewClient returns a client sending requests through a CacheTransport storing responses
+// in cache, using clock as its time function and http.DefaultTransport to reach the
+// origin. If config is nil, the defaults are used.
+func NewClient(cache gocondcache.Cache, config *gocondcache.Config, clock *Clock) *http.Client {
+	return &http.Client{Transport: gocondcache.New(cache, config, clock.Now, nil)(http.DefaultTransport)}
+}
@@ -0,0 +1,241 @@
+package gocondcachetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response describes a response served by an Origin.
+type Response struct {
+	// StatusCode is the status of the response. Defaults to 200.
+	StatusCode int
+
+	Body string
+
+	// ETag, LastModified and CacheControl set the headers of the same name when not
+	// empty. ETag must be quoted, e.g. `"v1"`.
+	ETag         string
+	LastModified time.Time
+	CacheControl string
+
+	// Header holds additional headers of the response.
+	Header http.Header
+
+	// IgnoreConditional makes the origin send the full response even if the validators
+	// of the request match it. By default a matching request is answered with a 304.
+	IgnoreConditional bool
+
+	// Latency delays the response by the given duration of real time.
+	Latency time.Duration
+
+	// Fail makes the origin close the connection without responding, so that the
+	// request fails with a transport error.
+	Fail bool
+}
+
+// Stats counts the requests an Origin received for a path.
+type Stats struct {
+	// Requests is the number of requests received.
+	Requests int
+	// Conditional is the number of requests carrying If-None-Match or If-Modified-Since.
+	Conditional int
+	// NotModified is the number of requests answered with a 304.
+	NotModified int
+	// Failures is the number of requests whose connection was closed by Fail.
+	Failures int
+}
+
+// Origin is an HTTP server answering requests with scripted responses. It is safe for
+// concurrent use.
+type Origin struct {
+	// URL is the base URL of the origin, of the form http://ipaddr:port with no
+	// trailing slash.
+	URL string
+
+	server *httptest.Server
+	clock  *Clock
+
+	lock    sync.Mutex
+	scripts map[string][]Response
+	served  map[string]int
+	stats   map[string]*Stats
+}
+
+// NewOrigin starts and returns a new Origin, which must be closed when done. Requests
+// to paths without a script are answered with a 404. The Date header of responses is
+// set from clock, or from time.Now if clock is nil.
+func NewOrigin(clock *Clock) *Origin {
+	o := &Origin{
+		clock:   clock,
+		scripts: make(map[string][]Response),
+		served:  make(map[string]int),
+		stats:   make(map[string]*Stats),
+	}
+
+	o.server = httptest.NewUnstartedServer(http.HandlerFunc(o.serve))
+	// every request gets its own connection, so that the client does not retry requests
+	// whose connection was closed by Fail
+	o.server.Config.SetKeepAlivesEnabled(false)
+	o.server.Start()
+	o.URL = o.server.URL
+
+	return o
+}
+
+// Close shuts down the origin and blocks until all requests to it have completed.
+func (o *Origin) Close() {
+	o.server.Close()
+}
+
+// Handle scripts the responses to requests for path. Each request is answered with the
+// next response, and the last response is repeated once all have been served, e.g.
+// Handle("/a", Response{ETag: `"v1"`}, Response{ETag: `"v2"`}) changes the ETag of /a
+// after its first request. Handle replaces any script of path, but not its Stats.
+func (o *Origin) Handle(path string, responses ...Response) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.scripts[path] = responses
+	o.served[path] = 0
+}
+
+// Stats returns the requests received for path.
+func (o *Origin) Stats(path string) Stats {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if s, found := o.stats[path]; found {
+		return *s
+	}
+
+	return Stats{}
+}
+
+// Requests returns the number of requests received for every path.
+func (o *Origin) Requests() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	total := 0
+	for _, s := range o.stats {
+		total += s.Requests
+	}
+
+	return total
+}
+
+// next returns the response to r, whether the path of r has a script, and whether r is
+// answered with a 304. The request is recorded in the stats of its path.
+func (o *Origin) next(r *http.Request) (Response, bool, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	path := r.URL.Path
+	s, found := o.stats[path]
+	if !found {
+		s = &Stats{}
+		o.stats[path] = s
+	}
+	s.Requests++
+	if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		s.Conditional++
+	}
+
+	script := o.scripts[path]
+	if len(script) == 0 {
+		return Response{}, false, false
+	}
+	resp := script[min(o.served[path], len(script)-1)]
+	o.served[path]++
+
+	notModified := !resp.Fail && !resp.IgnoreConditional && validatorsMatch(r, resp)
+	switch {
+	case resp.Fail:
+		s.Failures++
+	case notModified:
+		s.NotModified++
+	}
+
+	return resp, true, notModified
+}
+
+func (o *Origin) serve(w http.ResponseWriter, r *http.Request) {
+	resp, found, notModified := o.next(r)
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	if resp.Latency > 0 {
+		timer := time.NewTimer(resp.Latency)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if resp.Fail {
+		panic(http.ErrAbortHandler)
+	}
+
+	header := w.Header()
+	for k, v := range resp.Header {
+		header[k] = v
+	}
+	if o.clock != nil {
+		header.Set("Date", o.clock.Now().UTC().Format(http.TimeFormat))
+	}
+	if resp.ETag != "" {
+		header.Set("ETag", resp.ETag)
+	}
+	if !resp.LastModified.IsZero() {
+		header.Set("Last-Modified", resp.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if resp.CacheControl != "" {
+		header.Set("Cache-Control", resp.CacheControl)
+	}
+
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if resp.StatusCode != 0 {
+		w.WriteHeader(resp.StatusCode)
+	}
+	_, _ = w.Write([]byte(resp.Body))
+}
+
+// validatorsMatch reports whether the validators of r match resp, following RFC 9110
+// section 13.2.2: If-Modified-Since is only evaluated without If-None-Match.
+func validatorsMatch(r *http.Request, resp Response) bool {
+	if resp.StatusCode != 0 && resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if resp.ETag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(resp.ETag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !resp.LastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		return err == nil && !resp.LastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
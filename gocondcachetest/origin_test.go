@@ -0,0 +1,197 @@
+package gocondcachetest_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+func testTime() time.Time {
+	return time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func get(t *testing.T, client *http.Client, url string, header http.Header) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request to %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	return resp, string(body)
+}
+
+func TestOriginScript(t *testing.T) {
+	t.Parallel()
+
+	clock := gocondcachetest.NewClock(testTime())
+	origin := gocondcachetest.NewOrigin(clock)
+	defer origin.Close()
+
+	v1 := gocondcachetest.Response{Body: "one", ETag: `"v1"`, CacheControl: "max-age=60"}
+	v2 := gocondcachetest.Response{Body: "two", ETag: `"v2"`, CacheControl: "max-age=60"}
+	origin.Handle("/a", v1, v1, v2)
+
+	cache := gocondcachetest.NewLocalCache(clock)
+	client := gocondcachetest.NewClient(cache, &gocondcache.Config{StatusHeader: "X-Cache"}, clock)
+
+	steps := []struct {
+		advance        time.Duration
+		expectedStatus gocondcache.CacheStatus
+		expectedBody   string
+	}{
+		{expectedStatus: gocondcache.CacheStatusMiss, expectedBody: "one"},
+		{advance: 30 * time.Second, expectedStatus: gocondcache.CacheStatusHit, expectedBody: "one"},
+		{advance: time.Minute, expectedStatus: gocondcache.CacheStatusRevalidated, expectedBody: "one"},
+		{advance: 2 * time.Minute, expectedStatus: gocondcache.CacheStatusMiss, expectedBody: "two"},
+		{expectedStatus: gocondcache.CacheStatusHit, expectedBody: "two"},
+	}
+
+	for i, step := range steps {
+		clock.Advance(step.advance)
+
+		resp, body := get(t, client, origin.URL+"/a", nil)
+		if status := gocondcache.CacheStatus(resp.Header.Get("X-Cache")); status != step.expectedStatus {
+			t.Errorf("step %d: expected status %s, got %s", i, step.expectedStatus, status)
+		}
+		if body != step.expectedBody {
+			t.Errorf("step %d: expected body %q, got %q", i, step.expectedBody, body)
+		}
+	}
+
+	expected := gocondcachetest.Stats{Requests: 3, Conditional: 2, NotModified: 1}
+	if stats := origin.Stats("/a"); stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+	if date := clock.Now(); !date.Equal(testTime().Add(3*time.Minute + 30*time.Second)) {
+		t.Errorf("unexpected clock time %v", date)
+	}
+}
+
+func TestOriginConditional(t *testing.T) {
+	t.Parallel()
+
+	lastModified := testTime().Add(-time.Hour)
+	origin := gocondcachetest.NewOrigin(nil)
+	t.Cleanup(origin.Close)
+
+	origin.Handle("/both", gocondcachetest.Response{Body: "both", ETag: `"v1"`, LastModified: lastModified})
+	origin.Handle("/ignore", gocondcachetest.Response{Body: "ignore", ETag: `"v1"`, IgnoreConditional: true})
+	origin.Handle("/error", gocondcachetest.Response{StatusCode: http.StatusServiceUnavailable, ETag: `"v1"`})
+
+	tests := []struct {
+		name           string
+		path           string
+		header         http.Header
+		expectedStatus int
+	}{
+		{name: "unconditional", path: "/both", expectedStatus: http.StatusOK},
+		{name: "matching etag", path: "/both", header: http.Header{"If-None-Match": {`"v0", W/"v1"`}}, expectedStatus: http.StatusNotModified},
+		{name: "wildcard etag", path: "/both", header: http.Header{"If-None-Match": {"*"}}, expectedStatus: http.StatusNotModified},
+		{name: "changed etag", path: "/both", header: http.Header{"If-None-Match": {`"v0"`}}, expectedStatus: http.StatusOK},
+		{
+			name:           "not modified since",
+			path:           "/both",
+			header:         http.Header{"If-Modified-Since": {lastModified.Format(http.TimeFormat)}},
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:           "modified since",
+			path:           "/both",
+			header:         http.Header{"If-Modified-Since": {lastModified.Add(-time.Second).Format(http.TimeFormat)}},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "etag takes precedence",
+			path: "/both",
+			header: http.Header{
+				"If-None-Match":     {`"v0"`},
+				"If-Modified-Since": {lastModified.Format(http.TimeFormat)},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{name: "ignored validators", path: "/ignore", header: http.Header{"If-None-Match": {`"v1"`}}, expectedStatus: http.StatusOK},
+		{name: "error status", path: "/error", header: http.Header{"If-None-Match": {`"v1"`}}, expectedStatus: http.StatusServiceUnavailable},
+		{name: "unscripted path", path: "/missing", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, _ := get(t, http.DefaultClient, origin.URL+tt.path, tt.header)
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestOriginFailure(t *testing.T) {
+	t.Parallel()
+
+	clock := gocondcachetest.NewClock(testTime())
+	origin := gocondcachetest.NewOrigin(clock)
+	defer origin.Close()
+
+	origin.Handle("/a",
+		gocondcachetest.Response{Body: "one", ETag: `"v1"`, CacheControl: "max-age=60"},
+		gocondcachetest.Response{Fail: true},
+	)
+
+	cache := gocondcachetest.NewLocalCache(clock)
+	client := gocondcachetest.NewClient(cache, nil, clock)
+
+	get(t, client, origin.URL+"/a", nil)
+	clock.Advance(2 * time.Minute)
+
+	resp, err := client.Get(origin.URL + "/a")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected the request to fail")
+	}
+
+	expected := gocondcachetest.Stats{Requests: 2, Conditional: 1, Failures: 1}
+	if stats := origin.Stats("/a"); stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+	if origin.Requests() != 2 {
+		t.Errorf("expected 2 requests, got %d", origin.Requests())
+	}
+}
+
+func TestOriginLatency(t *testing.T) {
+	t.Parallel()
+
+	origin := gocondcachetest.NewOrigin(nil)
+	defer origin.Close()
+
+	latency := 50 * time.Millisecond
+	origin.Handle("/slow", gocondcachetest.Response{Body: "slow", Latency: latency})
+
+	start := time.Now()
+	if _, body := get(t, http.DefaultClient, origin.URL+"/slow", nil); body != "slow" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Errorf("expected the response to take at least %v, took %v", latency, elapsed)
+	}
+}
@@ -0,0 +1,388 @@
+package gocondcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dgduncan/go-cond-cache/caches"
+)
+
+const (
+	headerAuthorization = "Authorization"
+	headerSetCookie     = "Set-Cookie"
+)
+
+const (
+	directiveCacheControlSMaxAge = "s-maxage"
+	directiveCacheControlNoStore = "no-store"
+	directiveCacheControlNoCache = "no-cache"
+	directiveCacheControlPrivate = "private"
+	directiveCacheControlPublic  = "public"
+)
+
+// HandlerCacheConfig defines the configuration options of the output caching middleware.
+type HandlerCacheConfig struct {
+	// DefaultTTL is how long responses that carry no max-age or s-maxage directive are
+	// cached. Zero means such responses are not cached.
+	DefaultTTL time.Duration
+}
+
+// NewHandlerCache creates a server-side middleware that caches the rendered responses of
+// an http.Handler in the provided Cache, using the same CacheItem format as the transport.
+//
+// If the 'now' function is nil, time.Now will be used as the default time provider.
+// If the 'logger' is nil, a no-op logger writing to io.Discard will be used.
+//
+// The returned function wraps the given http.Handler with output caching:
+//   - Only GET and HEAD requests are served from the cache, and only GET responses are
+//     stored. A HEAD miss is rendered as a GET so that it carries the same ETag
+//   - Responses marked no-store or private, setting cookies, or without freshness, are not cached
+//   - Responses to requests with an Authorization header are only cached when marked public
+//     or s-maxage, as RFC 9111 section 3.5 requires
+//   - Responses marked no-cache are cached but revalidated with the handler on every request
+//   - s-maxage takes precedence over max-age for the time to cache
+//   - Responses are stored per variant when the handler sets a Vary header
+//   - Conditional requests from downstream clients are answered with 304 or 412
+func NewHandlerCache(
+	cache Cache,
+	opts *HandlerCacheConfig,
+	now func() time.Time,
+	logger *slog.Logger,
+) func(http.Handler) http.Handler {
+	nowFunc := now
+	if nowFunc == nil {
+		nowFunc = time.Now
+	}
+
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	c := HandlerCacheConfig{}
+	if opts != nil {
+		c = *opts
+	}
+
+	return func(next http.Handler) http.Handler {
+		return &handlerCache{next: next, cache: cache, now: nowFunc, logger: logger, c: c}
+	}
+}
+
+type handlerCache struct {
+	next http.Handler
+
+	cache  Cache
+	logger *slog.Logger
+	now    func() time.Time
+
+	c HandlerCacheConfig
+}
+
+func (h *handlerCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	base := handlerKey(r)
+
+	var rec *responseRecorder
+	requestDirectives := cacheControlDirectives(r.Header.Get(headerCacheControl))
+	if _, noCache := requestDirectives[directiveCacheControlNoCache]; !noCache {
+		if item, key := h.lookup(ctx, base, r); item != nil {
+			if requiresRevalidation(item) {
+				rec = h.revalidate(r, item)
+			}
+			if rec == nil || rec.status == http.StatusNotModified {
+				h.logger.DebugContext(ctx, "serving cached handler response", "key", key)
+				if err := serveCachedItem(w, r, item); err != nil {
+					h.logger.WarnContext(ctx, "error serving cached handler response", "key", key, "error", err)
+				}
+				return
+			}
+		}
+	}
+
+	if rec == nil {
+		// a HEAD response carries the validators of the GET one
+		inner := r
+		if r.Method == http.MethodHead {
+			inner = r.Clone(ctx)
+			inner.Method = http.MethodGet
+		}
+		rec = &responseRecorder{header: make(http.Header), status: http.StatusOK}
+		h.next.ServeHTTP(rec, inner)
+	}
+
+	if rec.status == http.StatusOK && rec.header.Get(headerETAG) == "" {
+		rec.header.Set(headerETAG, generateETag(rec.body.Bytes(), false))
+	}
+
+	if r.Method == http.MethodGet {
+		h.store(ctx, base, r, rec)
+	}
+
+	for k, v := range rec.header {
+		w.Header()[k] = v
+	}
+
+	if rec.status == http.StatusOK {
+		switch checkPreconditions(r, rec.header.Get(headerETAG), parseHTTPDate(rec.header.Get(headerLastModified))) {
+		case http.StatusNotModified:
+			writeNotModified(w)
+			return
+		case http.StatusPreconditionFailed:
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+	}
+
+	w.WriteHeader(rec.status)
+	_, _ = w.Write(rec.body.Bytes())
+}
+
+// lookup returns the fresh cached item for r, following the base entry to the variant
+// selected by the request when the response varies.
+func (h *handlerCache) lookup(ctx context.Context, base string, r *http.Request) (*CacheItem, string) {
+	item, err := h.cache.Get(ctx, base)
+	if err != nil {
+		if !errors.Is(err, caches.ErrNoCacheItem) && !errors.Is(err, caches.ErrCacheItemExpired) {
+			h.logger.WarnContext(ctx, "error reading cached handler response", "key", base, "error", err)
+		}
+		return nil, base
+	}
+
+	resp, err := readCachedResponse(item)
+	if err != nil {
+		return nil, base
+	}
+	resp.Body.Close()
+
+	vary := parseVary(resp.Header)
+	if len(vary) == 0 {
+		return item, base
+	}
+
+	key := varyKey(base, vary, r.Header)
+	variant, err := h.cache.Get(ctx, key)
+	if err != nil {
+		return nil, key
+	}
+
+	return variant, key
+}
+
+// revalidate renders the response to r on the condition that it changed since item was
+// cached. The handler answers 304 Not Modified when item is still current.
+func (h *handlerCache) revalidate(r *http.Request, item *CacheItem) *responseRecorder {
+	conditional := r.Clone(r.Context())
+	conditional.Header.Del(headerIfNoneMatch)
+	conditional.Header.Del(headerIfModifiedSince)
+	if item.ETAG != "" {
+		conditional.Header.Set(headerIfNoneMatch, item.ETAG)
+	} else if item.LastModified != nil {
+		conditional.Header.Set(headerIfModifiedSince, item.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+	h.next.ServeHTTP(rec, conditional)
+
+	return rec
+}
+
+// store caches the recorded response if its Cache-Control directives allow it. Responses
+// that vary are stored under their variant key, with a body-less entry under the base
+// key recording which request headers select the variant.
+func (h *handlerCache) store(ctx context.Context, base string, r *http.Request, rec *responseRecorder) {
+	if rec.status != http.StatusOK {
+		return
+	}
+
+	ttl, ok := h.timeToCache(r, rec.header)
+	if !ok {
+		return
+	}
+
+	vary := parseVary(rec.header)
+	if len(vary) == 1 && vary[0] == varyWildcard {
+		return
+	}
+
+	expiration := h.now().UTC().Add(ttl)
+	item := &CacheItem{
+		ETAG:         rec.header.Get(headerETAG),
+		LastModified: parseHTTPDate(rec.header.Get(headerLastModified)),
+		Response:     dumpRecordedResponse(rec.status, rec.header, rec.body.Bytes()),
+		Expiration:   expiration,
+		Tags:         itemTags(ctx, rec.header),
+	}
+
+	key := base
+	if len(vary) > 0 {
+		key = varyKey(base, vary, r.Header)
+
+		stubHeader := http.Header{}
+		stubHeader[headerVary] = rec.header.Values(headerVary)
+		stub := &CacheItem{Response: dumpRecordedResponse(http.StatusOK, stubHeader, nil), Expiration: expiration}
+		if err := h.cache.Set(ctx, base, stub); err != nil {
+			h.logger.WarnContext(ctx, "error caching handler response", "key", base, "error", err)
+			return
+		}
+	}
+
+	h.logger.DebugContext(ctx, "caching handler response", "key", key, "expiration", expiration)
+	if err := h.cache.Set(ctx, key, item); err != nil {
+		h.logger.WarnContext(ctx, "error caching handler response", "key", key, "error", err)
+	}
+}
+
+// timeToCache returns how long a response with the given headers to r may be cached by
+// a shared cache, and false if it must not be cached.
+func (h *handlerCache) timeToCache(r *http.Request, header http.Header) (time.Duration, bool) {
+	directives := cacheControlDirectives(header.Get(headerCacheControl))
+	if !sharedStorable(r, header, directives) {
+		return 0, false
+	}
+
+	for _, directive := range []string{directiveCacheControlSMaxAge, directiveCacheControlMaxAge} {
+		if value, found := directives[directive]; found {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	return h.c.DefaultTTL, h.c.DefaultTTL > 0
+}
+
+// sharedStorable reports whether a response with the given headers and Cache-Control
+// directives to r may be stored by a cache shared between clients.
+func sharedStorable(r *http.Request, header http.Header, directives map[string]string) bool {
+	for _, directive := range []string{directiveCacheControlNoStore, directiveCacheControlPrivate} {
+		if _, found := directives[directive]; found {
+			return false
+		}
+	}
+
+	// cookies are set for a single client
+	if header.Get(headerSetCookie) != "" {
+		return false
+	}
+
+	if r.Header.Get(headerAuthorization) != "" {
+		_, public := directives[directiveCacheControlPublic]
+		_, shared := directives[directiveCacheControlSMaxAge]
+		if !public && !shared {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requiresRevalidation reports whether the cached response is marked no-cache, in which
+// case it may only be served once the handler confirmed it is current.
+func requiresRevalidation(item *CacheItem) bool {
+	resp, err := readCachedResponse(item)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	_, noCache := cacheControlDirectives(resp.Header.Get(headerCacheControl))[directiveCacheControlNoCache]
+	return noCache
+}
+
+// serveCachedItem writes a cached response to w, answering the request's preconditions
+// against the validators stored with the item.
+func serveCachedItem(w http.ResponseWriter, r *http.Request, item *CacheItem) error {
+	resp, err := readCachedResponse(item)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+
+	switch checkPreconditions(r, item.ETAG, item.LastModified) {
+	case http.StatusNotModified:
+		writeNotModified(w)
+		return nil
+	case http.StatusPreconditionFailed:
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return nil
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// handlerKey returns the cache key of an incoming request. HEAD requests share the key
+// of GET requests so they can be answered from cached GET responses.
+func handlerKey(r *http.Request) string {
+	u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+
+	return caches.Key(http.Request{Method: http.MethodGet, URL: &u})
+}
+
+func dumpRecordedResponse(status int, header http.Header, body []byte) []byte {
+	resp := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	resp.Header.Del("Content-Length")
+
+	dump, _ := httputil.DumpResponse(resp, true)
+	return dump
+}
+
+// responseRecorder captures everything a handler writes so it can be cached before
+// being sent to the client.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+
+	wroteHeader bool
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.wroteHeader {
+		return
+	}
+	rr.status = status
+	rr.wroteHeader = true
+}
+
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	rr.wroteHeader = true
+	return rr.body.Write(p)
+}
@@ -0,0 +1,306 @@
+package gocondcache_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+)
+
+func TestHandlerCache(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		cacheControl    string
+		requests        []map[string]string
+		expectedRenders int
+		expectedStatus  []int
+	}{
+		{
+			name:            "cacheable response rendered once",
+			cacheControl:    "max-age=60",
+			requests:        []map[string]string{nil, nil, nil},
+			expectedRenders: 1,
+			expectedStatus:  []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:            "no-store response rendered every time",
+			cacheControl:    "no-store",
+			requests:        []map[string]string{nil, nil},
+			expectedRenders: 2,
+			expectedStatus:  []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			name:            "private response rendered every time",
+			cacheControl:    "private, max-age=60",
+			requests:        []map[string]string{nil, nil},
+			expectedRenders: 2,
+			expectedStatus:  []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			name:         "variants stored separately",
+			cacheControl: "max-age=60",
+			requests: []map[string]string{
+				{"Accept-Language": "en"},
+				{"Accept-Language": "fr"},
+				{"Accept-Language": "en"},
+				{"Accept-Language": "fr"},
+			},
+			expectedRenders: 2,
+			expectedStatus:  []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:         "request no-cache forces a render",
+			cacheControl: "max-age=60",
+			requests: []map[string]string{
+				nil,
+				{"Cache-Control": "no-cache"},
+			},
+			expectedRenders: 2,
+			expectedStatus:  []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			name:         "conditional request answered from cache",
+			cacheControl: "max-age=60",
+			requests: []map[string]string{
+				nil,
+				{"If-Modified-Since": "Wed, 21 Oct 2015 07:28:00 GMT"},
+			},
+			expectedRenders: 1,
+			expectedStatus:  []int{http.StatusOK, http.StatusNotModified},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			renders := 0
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				renders++
+				w.Header().Set("Cache-Control", tt.cacheControl)
+				w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+				w.Header().Set("Vary", "Accept-Language")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("hello " + r.Header.Get("Accept-Language")))
+			})
+
+			cache := local.NewBasicCacheWithTimeFunc(testTime)
+			handler := gocondcache.NewHandlerCache(&cache, nil, testTime, nil)(next)
+
+			for i, headers := range tt.requests {
+				req := httptest.NewRequest(http.MethodGet, "/slow", nil)
+				for k, v := range headers {
+					req.Header.Set(k, v)
+				}
+
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != tt.expectedStatus[i] {
+					t.Errorf("request %d: expected status %d, got %d", i, tt.expectedStatus[i], rec.Code)
+				}
+				if rec.Code != http.StatusOK {
+					continue
+				}
+				body, _ := io.ReadAll(rec.Body)
+				if want := "hello " + headers["Accept-Language"]; string(body) != want {
+					t.Errorf("request %d: expected body %q, got %q", i, want, string(body))
+				}
+			}
+
+			if renders != tt.expectedRenders {
+				t.Errorf("expected %d renders, got %d", tt.expectedRenders, renders)
+			}
+		})
+	}
+}
+
+func TestHandlerCacheExpiration(t *testing.T) {
+	t.Parallel()
+
+	renders := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		renders++
+		w.Header().Set("Cache-Control", "s-maxage=10, max-age=3600")
+		w.Write([]byte("hello"))
+	})
+
+	currentTime := testTime()
+	timeFunc := func() time.Time { return currentTime }
+	cache := local.NewBasicCacheWithTimeFunc(timeFunc)
+	handler := gocondcache.NewHandlerCache(&cache, nil, timeFunc, nil)(next)
+
+	for _, advance := range []time.Duration{0, 5 * time.Second, 10 * time.Second} {
+		currentTime = currentTime.Add(advance)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	// s-maxage takes precedence, so the third request is past the 10 second freshness
+	if renders != 2 {
+		t.Errorf("expected 2 renders, got %d", renders)
+	}
+}
+
+func TestHandlerCacheSharedResponses(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		header          map[string]string
+		authorization   string
+		expectedRenders int
+	}{
+		{
+			name:            "response setting a cookie rendered every time",
+			header:          map[string]string{"Cache-Control": "max-age=60", "Set-Cookie": "session=abc"},
+			expectedRenders: 2,
+		},
+		{
+			name:            "authorized request rendered every time",
+			header:          map[string]string{"Cache-Control": "max-age=60"},
+			authorization:   "Bearer abc",
+			expectedRenders: 2,
+		},
+		{
+			name:            "authorized request with a public response cached",
+			header:          map[string]string{"Cache-Control": "public, max-age=60"},
+			authorization:   "Bearer abc",
+			expectedRenders: 1,
+		},
+		{
+			name:            "authorized request with an s-maxage response cached",
+			header:          map[string]string{"Cache-Control": "s-maxage=60"},
+			authorization:   "Bearer abc",
+			expectedRenders: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			renders := 0
+			next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				renders++
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				w.Write([]byte("hello"))
+			})
+
+			cache := local.NewBasicCacheWithTimeFunc(testTime)
+			handler := gocondcache.NewHandlerCache(&cache, nil, testTime, nil)(next)
+
+			for range 2 {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				if tt.authorization != "" {
+					req.Header.Set("Authorization", tt.authorization)
+				}
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			if renders != tt.expectedRenders {
+				t.Errorf("expected %d renders, got %d", tt.expectedRenders, renders)
+			}
+		})
+	}
+}
+
+func TestHandlerCacheNoCache(t *testing.T) {
+	t.Parallel()
+
+	version := "v1"
+	renders, revalidations := 0, 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache, max-age=60")
+		w.Header().Set("ETag", `"`+version+`"`)
+		if r.Header.Get("If-None-Match") == `"`+version+`"` {
+			revalidations++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		renders++
+		w.Write([]byte(version))
+	})
+
+	cache := local.NewBasicCacheWithTimeFunc(testTime)
+	handler := gocondcache.NewHandlerCache(&cache, nil, testTime, nil)(next)
+
+	get := func() string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	// the stored response is revalidated, and served while the handler confirms it
+	for range 2 {
+		if body := get(); body != "v1" {
+			t.Errorf("expected body %q, got %q", "v1", body)
+		}
+	}
+	if renders != 1 || revalidations != 1 {
+		t.Errorf("expected 1 render and 1 revalidation, got %d and %d", renders, revalidations)
+	}
+
+	// a changed response replaces the stored one
+	version = "v2"
+	if body := get(); body != "v2" {
+		t.Errorf("expected body %q, got %q", "v2", body)
+	}
+	if body := get(); body != "v2" || renders != 2 || revalidations != 2 {
+		t.Errorf("expected the new response to be stored, got %q, %d renders and %d revalidations",
+			body, renders, revalidations)
+	}
+}
+
+func TestHandlerCacheHead(t *testing.T) {
+	t.Parallel()
+
+	renders := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renders++
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.Method != http.MethodHead {
+			w.Write([]byte("hello"))
+		}
+	})
+	serve := func(handler http.Handler, method, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	getCache := local.NewBasicCacheWithTimeFunc(testTime)
+	etag := serve(gocondcache.NewHandlerCache(&getCache, nil, testTime, nil)(next), http.MethodGet, "").
+		Header().Get("ETag")
+
+	cache := local.NewBasicCacheWithTimeFunc(testTime)
+	handler := gocondcache.NewHandlerCache(&cache, nil, testTime, nil)(next)
+
+	// a HEAD miss carries the ETag of the GET response and answers its preconditions
+	if rec := serve(handler, http.MethodHead, ""); rec.Code != http.StatusOK || rec.Header().Get("ETag") != etag {
+		t.Errorf("expected status 200 with ETag %s, got %d with %q", etag, rec.Code, rec.Header().Get("ETag"))
+	}
+	if rec := serve(handler, http.MethodHead, etag); rec.Code != http.StatusNotModified {
+		t.Errorf("expected status 304, got %d", rec.Code)
+	}
+
+	// only GET responses are stored
+	serve(handler, http.MethodGet, "")
+	if renders != 4 {
+		t.Errorf("expected 4 renders, got %d", renders)
+	}
+}
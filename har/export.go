@@ -0,0 +1,323 @@
+package har
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+)
+
+const (
+	httpVersion = "HTTP/1.1"
+	unknownSize = -1
+)
+
+// headers a stored response is rewritten with by Import.
+var rewrittenHeaders = []string{ //nolint:gochecknoglobals // read only list of header names
+	"Content-Length", "Content-Encoding", "Transfer-Encoding",
+}
+
+// Build returns a HAR document holding the items of cache selected by s, sorted by
+// key, with now used to report whether they are expired.
+// Returns caches.ErrNotSupported if s has no Key and cache is not a Ranger, and
+// caches.ErrNoCacheItem if the item selected by Key does not exist.
+func Build(ctx context.Context, cache gocondcache.Cache, s caches.Selector, now time.Time) (*HAR, error) {
+	items := make(map[string]*gocondcache.CacheItem)
+	if s.Key != "" {
+		item, err := cache.Get(ctx, s.Key)
+		if err != nil && !errors.Is(err, caches.ErrCacheItemExpired) {
+			return nil, err
+		}
+		if s.Match(s.Key, item.Tags) {
+			items[s.Key] = item
+		}
+	} else {
+		ranger, ok := cache.(gocondcache.Ranger)
+		if !ok {
+			return nil, caches.ErrNotSupported
+		}
+
+		err := ranger.Range(ctx, func(k string, v *gocondcache.CacheItem) bool {
+			if s.Match(k, v.Tags) {
+				items[k] = v
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	doc := &HAR{Log: Log{
+		Version: Version,
+		Creator: Creator{Name: gocondcache.ExportFormat, Version: strconv.Itoa(gocondcache.ExportVersion)},
+		Entries: make([]Entry, 0, len(keys)),
+	}}
+	for _, k := range keys {
+		doc.Log.Entries = append(doc.Log.Entries, newEntry(k, items[k], now))
+	}
+
+	return doc, nil
+}
+
+// Export writes a HAR document holding the items of cache selected by s to w, and
+// returns the number of entries written. See Build for the errors returned.
+func Export(ctx context.Context, cache gocondcache.Cache, s caches.Selector, w io.Writer) (int, error) {
+	doc, err := Build(ctx, cache, s, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(doc); err != nil {
+		return 0, err
+	}
+
+	return len(doc.Log.Entries), nil
+}
+
+// Import reads a HAR document from r and stores its entries in cache, replacing the
+// items already stored under the same keys. It returns the number of items stored.
+//
+// Entries exported by Export are restored with their metadata. Other entries, such as
+// the ones of a browser capture, are stored under the key of their request method and
+// URL, with the validators of their response headers and an expiration in the past,
+// so that they are revalidated when first used. Entries without a response, whose
+// status is 0, are skipped.
+// Returns an error wrapping caches.ErrInvalidExport if r is not a HAR document.
+func Import(ctx context.Context, cache gocondcache.Cache, r io.Reader) (int, error) {
+	var doc HAR
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return 0, fmt.Errorf("%w: reading HAR: %w", caches.ErrInvalidExport, err)
+	}
+	if doc.Log.Version == "" {
+		return 0, fmt.Errorf("%w: not a HAR document", caches.ErrInvalidExport)
+	}
+
+	imported := 0
+	for i, entry := range doc.Log.Entries {
+		if err := ctx.Err(); err != nil {
+			return imported, err
+		}
+		if entry.Response.Status == 0 {
+			continue
+		}
+
+		k, item, err := entry.item()
+		if err != nil {
+			return imported, fmt.Errorf("%w: entry %d: %w", caches.ErrInvalidExport, i+1, err)
+		}
+		if err = cache.Set(ctx, k, item); err != nil {
+			return imported, fmt.Errorf("storing %s: %w", k, err)
+		}
+		imported++
+	}
+
+	return imported, nil
+}
+
+// newEntry returns the HAR entry of the item v stored under k. A response that cannot
+// be parsed is reported in the entry's comment.
+func newEntry(k string, v *gocondcache.CacheItem, now time.Time) Entry {
+	method, reqURL := splitKey(k)
+	entry := Entry{
+		StartedDateTime: now.UTC(),
+		Request: Request{
+			Method:      method,
+			URL:         reqURL.String(),
+			HTTPVersion: httpVersion,
+			Cookies:     []NameValue{},
+			Headers:     []NameValue{},
+			QueryString: nameValues(reqURL.Query()),
+			HeadersSize: unknownSize,
+			BodySize:    unknownSize,
+		},
+		Response: Response{
+			HTTPVersion: httpVersion,
+			Cookies:     []NameValue{},
+			Headers:     []NameValue{},
+			HeadersSize: unknownSize,
+			BodySize:    unknownSize,
+		},
+		CondCache: &Metadata{
+			Key:          k,
+			ETag:         v.ETAG,
+			LastModified: v.LastModified,
+			Expiration:   v.Expiration,
+			Tags:         v.Tags,
+			Expired:      now.After(v.Expiration),
+		},
+	}
+	if v.StaleWindow > 0 {
+		entry.CondCache.StaleWindow = v.StaleWindow.String()
+	}
+
+	resp, body, err := parseResponse(v.Response)
+	if err != nil {
+		entry.Comment = fmt.Sprintf("unparsable response: %v", err)
+		return entry
+	}
+
+	if date, dateErr := http.ParseTime(resp.Header.Get("Date")); dateErr == nil {
+		entry.StartedDateTime = date.UTC()
+	}
+	entry.Response.Status = resp.StatusCode
+	entry.Response.StatusText = strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)+" ")
+	entry.Response.HTTPVersion = resp.Proto
+	entry.Response.Headers = nameValues(resp.Header)
+	entry.Response.RedirectURL = resp.Header.Get("Location")
+	entry.Response.BodySize = len(body)
+	entry.Response.Content = Content{Size: len(body), MimeType: resp.Header.Get("Content-Type")}
+	if utf8.Valid(body) {
+		entry.Response.Content.Text = string(body)
+	} else {
+		entry.Response.Content.Text = base64.StdEncoding.EncodeToString(body)
+		entry.Response.Content.Encoding = "base64"
+	}
+
+	return entry
+}
+
+// item returns the key and cache item of e.
+func (e *Entry) item() (string, *gocondcache.CacheItem, error) {
+	body := []byte(e.Response.Content.Text)
+	if e.Response.Content.Encoding == "base64" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(e.Response.Content.Text); err != nil {
+			return "", nil, err
+		}
+	}
+
+	header := make(http.Header)
+	for _, h := range e.Response.Headers {
+		// HTTP/2 captures list pseudo-headers such as :status
+		if strings.HasPrefix(h.Name, ":") {
+			continue
+		}
+		header.Add(h.Name, h.Value)
+	}
+	// bodies are captured decoded, and written again with their length
+	for _, name := range rewrittenHeaders {
+		header.Del(name)
+	}
+
+	// HTTP/2 captures have no status text
+	statusText := e.Response.StatusText
+	if statusText == "" {
+		statusText = http.StatusText(e.Response.Status)
+	}
+
+	resp := &http.Response{
+		Status:        strconv.Itoa(e.Response.Status) + " " + statusText,
+		StatusCode:    e.Response.Status,
+		Proto:         httpVersion,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	item := &gocondcache.CacheItem{Response: dump}
+	if meta := e.CondCache; meta != nil {
+		item.ETAG = meta.ETag
+		item.LastModified = meta.LastModified
+		item.Expiration = meta.Expiration
+		item.Tags = meta.Tags
+		if meta.StaleWindow != "" {
+			if item.StaleWindow, err = time.ParseDuration(meta.StaleWindow); err != nil {
+				return "", nil, err
+			}
+		}
+		if meta.Key != "" {
+			return meta.Key, item, nil
+		}
+	} else {
+		item.ETAG = header.Get("ETag")
+		if lastModified, parseErr := http.ParseTime(header.Get("Last-Modified")); parseErr == nil {
+			item.LastModified = &lastModified
+		}
+	}
+
+	reqURL, err := url.Parse(e.Request.URL)
+	if err != nil {
+		return "", nil, err
+	}
+	if e.Request.Method == "" || reqURL.Host == "" {
+		return "", nil, fmt.Errorf("request %s %q has no method or host", e.Request.Method, e.Request.URL)
+	}
+
+	return caches.Key(http.Request{Method: e.Request.Method, URL: reqURL}), item, nil
+}
+
+// splitKey returns the method and URL a key built by caches.Key or caches.KeyWithBody
+// was made of, without the body digest.
+func splitKey(k string) (string, *url.URL) {
+	method, rawURL, _ := strings.Cut(k, "#")
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return method, &url.URL{Opaque: rawURL}
+	}
+	u.Fragment, u.RawFragment = "", ""
+
+	return method, u
+}
+
+func parseResponse(dump []byte) (*http.Response, []byte, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp, body, nil
+}
+
+// nameValues returns the values of m as name-value pairs, sorted by name.
+func nameValues(m map[string][]string) []NameValue {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	pairs := make([]NameValue, 0, len(m))
+	for _, name := range names {
+		for _, value := range m[name] {
+			pairs = append(pairs, NameValue{Name: name, Value: value})
+		}
+	}
+
+	return pairs
+}
@@ -0,0 +1,109 @@
+// Package har converts cache items to and from HAR 1.2 documents, so that cached
+// responses can be inspected with browser devtools or other HAR tools, and HAR
+// captures can be loaded into a cache as fixtures.
+//
+// Each entry's response is parsed out of the stored HTTP/1.1 dump. The cache metadata
+// of the item is kept in the custom _condcache field of the entry, e.g.
+//
+//	"_condcache": {"key": "GET#https://example.com/", "etag": "\"v1\"", "expiration": "2024-03-01T13:00:00Z", "expired": false}
+//
+// See http://www.softwareishard.com/blog/har-12-spec/ for the format.
+package har
+
+import "time"
+
+// Version is the HAR version written by Export.
+const Version = "1.2"
+
+// HAR is the root of a HAR document.
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+// Log holds the entries of a HAR document.
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+	Comment string  `json:"comment,omitempty"`
+}
+
+// Creator identifies the application that wrote a HAR document.
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is an exchange of a HAR document.
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"` // in milliseconds
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           Cache     `json:"cache"`
+	Timings         Timings   `json:"timings"`
+	Comment         string    `json:"comment,omitempty"`
+
+	// CondCache is the metadata of the cache item the entry was exported from.
+	CondCache *Metadata `json:"_condcache,omitempty"`
+}
+
+// Request is the request of an entry.
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+// Response is the response of an entry.
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+// Content is the body of a response.
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"` // base64 for bodies that are not UTF-8 text
+}
+
+// NameValue is a header, cookie or query string parameter.
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Cache is the browser cache state of an entry, which Export leaves empty.
+type Cache struct{}
+
+// Timings are the phases of an entry's exchange, in milliseconds.
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// Metadata is the cache metadata of an entry, stored in its _condcache field.
+type Metadata struct {
+	Key          string     `json:"key"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Expiration   time.Time  `json:"expiration"`
+	StaleWindow  string     `json:"staleWindow,omitempty"` // in time.Duration notation, e.g. 1m30s
+	Tags         []string   `json:"tags,omitempty"`
+	Expired      bool       `json:"expired"`
+}
@@ -0,0 +1,336 @@
+package har_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+	"github.com/dgduncan/go-cond-cache/har"
+)
+
+func testTime() time.Time {
+	return time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func newTestCache(t *testing.T) *local.BasicCache {
+	t.Helper()
+
+	lastModified := testTime().Add(-time.Hour)
+	items := map[string]*gocondcache.CacheItem{
+		"GET#https://a.example.com/page?b=2&a=1": {
+			ETAG:         `"v1"`,
+			LastModified: &lastModified,
+			Response: []byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/html\r\n" +
+				"Date: Sun, 01 Jan 2023 11:00:00 GMT\r\n\r\nhello"),
+			Expiration:  testTime().Add(time.Hour),
+			Tags:        []string{"pages"},
+			StaleWindow: time.Minute,
+		},
+		"GET#https://a.example.com/logo.png": {
+			ETAG:       `"v2"`,
+			Response:   []byte("HTTP/1.1 200 OK\r\nContent-Length: 4\r\nContent-Type: image/png\r\n\r\n\x89PNG"),
+			Expiration: testTime().Add(-time.Hour),
+		},
+		"POST#https://b.example.com/graphql#" + strings.Repeat("0", 64): {
+			ETAG:       `"v3"`,
+			Response:   []byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\n{}\r\n0\r\n\r\n"),
+			Expiration: testTime().Add(time.Hour),
+			Tags:       []string{"api"},
+		},
+	}
+
+	cache := gocondcachetest.NewLocalCache(gocondcachetest.NewClock(testTime()))
+	gocondcachetest.Seed(t, cache, items)
+
+	return cache
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	doc, err := har.Build(context.Background(), newTestCache(t), caches.Selector{}, testTime())
+	if err != nil {
+		t.Fatalf("failed to build HAR: %v", err)
+	}
+	if doc.Log.Version != har.Version || len(doc.Log.Entries) != 3 {
+		t.Fatalf("expected 3 entries of a version %s log, got %+v", har.Version, doc.Log)
+	}
+
+	logo, page, graphql := doc.Log.Entries[0], doc.Log.Entries[1], doc.Log.Entries[2]
+
+	if page.Request.Method != http.MethodGet || page.Request.URL != "https://a.example.com/page?b=2&a=1" {
+		t.Errorf("unexpected page request %+v", page.Request)
+	}
+	expectedQuery := []har.NameValue{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}
+	if !reflect.DeepEqual(page.Request.QueryString, expectedQuery) {
+		t.Errorf("expected query string %v, got %v", expectedQuery, page.Request.QueryString)
+	}
+	if page.Response.Status != http.StatusOK || page.Response.StatusText != "OK" {
+		t.Errorf("unexpected page status %d %q", page.Response.Status, page.Response.StatusText)
+	}
+	expectedContent := har.Content{Size: 5, MimeType: "text/html", Text: "hello"}
+	if page.Response.Content != expectedContent {
+		t.Errorf("expected content %+v, got %+v", expectedContent, page.Response.Content)
+	}
+	if !page.StartedDateTime.Equal(testTime().Add(-time.Hour)) {
+		t.Errorf("expected the start time to be the response date, got %v", page.StartedDateTime)
+	}
+	if meta := page.CondCache; meta == nil || meta.ETag != `"v1"` || meta.StaleWindow != "1m0s" || meta.Expired {
+		t.Errorf("unexpected page metadata %+v", meta)
+	}
+
+	if logo.Response.Content.Encoding != "base64" || logo.Response.Content.Text != "iVBORw==" {
+		t.Errorf("expected a base64 encoded body, got %+v", logo.Response.Content)
+	}
+	if !logo.CondCache.Expired {
+		t.Error("expected the logo to be reported as expired")
+	}
+
+	if graphql.Request.Method != http.MethodPost || graphql.Request.URL != "https://b.example.com/graphql" {
+		t.Errorf("expected the body digest to be left out of the URL, got %+v", graphql.Request)
+	}
+	if graphql.Response.Content.Text != "{}" {
+		t.Errorf("expected the chunked body to be decoded, got %+v", graphql.Response.Content)
+	}
+}
+
+func TestBuildSelector(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		selector     caches.Selector
+		expectedKeys []string
+		expectedErr  error
+	}{
+		{
+			name:         "key",
+			selector:     caches.Selector{Key: "GET#https://a.example.com/logo.png"},
+			expectedKeys: []string{"GET#https://a.example.com/logo.png"},
+		},
+		{
+			name:         "prefix",
+			selector:     caches.Selector{Prefix: "GET#https://a.example.com/p"},
+			expectedKeys: []string{"GET#https://a.example.com/page?b=2&a=1"},
+		},
+		{
+			name:         "host",
+			selector:     caches.Selector{Host: "b.example.com"},
+			expectedKeys: []string{"POST#https://b.example.com/graphql#" + strings.Repeat("0", 64)},
+		},
+		{
+			name:         "host and tag",
+			selector:     caches.Selector{Host: "a.example.com", Tag: "pages"},
+			expectedKeys: []string{"GET#https://a.example.com/page?b=2&a=1"},
+		},
+		{
+			name:         "key and other host",
+			selector:     caches.Selector{Key: "GET#https://a.example.com/logo.png", Host: "b.example.com"},
+			expectedKeys: []string{},
+		},
+		{
+			name:        "missing key",
+			selector:    caches.Selector{Key: "GET#https://a.example.com/missing"},
+			expectedErr: caches.ErrNoCacheItem,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			doc, err := har.Build(context.Background(), newTestCache(t), tt.selector, testTime())
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			keys := []string{}
+			for _, entry := range doc.Log.Entries {
+				keys = append(keys, entry.CondCache.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.expectedKeys) {
+				t.Errorf("expected keys %v, got %v", tt.expectedKeys, keys)
+			}
+		})
+	}
+}
+
+func TestBuildNotSupported(t *testing.T) {
+	t.Parallel()
+
+	// embedding the interface hides the Ranger implementation
+	cache := struct{ gocondcache.Cache }{newTestCache(t)}
+	if _, err := har.Build(context.Background(), cache, caches.Selector{}, testTime()); !errors.Is(err, caches.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+	if _, err := har.Build(context.Background(), cache, caches.Selector{Key: "GET#https://a.example.com/logo.png"}, testTime()); err != nil {
+		t.Errorf("expected a single key to be exported without Range, got %v", err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	source := newTestCache(t)
+
+	var buf bytes.Buffer
+	exported, err := har.Export(ctx, source, caches.Selector{}, &buf)
+	if err != nil || exported != 3 {
+		t.Fatalf("expected 3 entries exported, got %d and error %v", exported, err)
+	}
+
+	target := local.NewBasicCacheWithTimeFunc(testTime)
+	imported, err := har.Import(ctx, &target, &buf)
+	if err != nil || imported != 3 {
+		t.Fatalf("expected 3 entries imported, got %d and error %v", imported, err)
+	}
+
+	err = source.Range(ctx, func(k string, expected *gocondcache.CacheItem) bool {
+		got, getErr := target.Get(ctx, k)
+		if getErr != nil && !errors.Is(getErr, caches.ErrCacheItemExpired) {
+			t.Errorf("failed to get imported item %s: %v", k, getErr)
+			return true
+		}
+
+		expectedDoc, _ := har.Build(ctx, source, caches.Selector{Key: k}, testTime())
+		gotDoc, _ := har.Build(ctx, &target, caches.Selector{Key: k}, testTime())
+		if !reflect.DeepEqual(gotDoc.Log.Entries[0].Response.Content, expectedDoc.Log.Entries[0].Response.Content) {
+			t.Errorf("%s: expected content %+v, got %+v",
+				k, expectedDoc.Log.Entries[0].Response.Content, gotDoc.Log.Entries[0].Response.Content)
+		}
+
+		if !got.Expiration.Equal(expected.Expiration) {
+			t.Errorf("%s: expected expiration %v, got %v", k, expected.Expiration, got.Expiration)
+		}
+		if got.ETAG != expected.ETAG || got.StaleWindow != expected.StaleWindow || !reflect.DeepEqual(got.Tags, expected.Tags) {
+			t.Errorf("%s: expected metadata %+v, got %+v", k, expected, got)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("failed to range over the source: %v", err)
+	}
+}
+
+func TestImportCapture(t *testing.T) {
+	t.Parallel()
+
+	capture := `{"log": {"version": "1.2", "creator": {"name": "browser", "version": "1"}, "entries": [
+		{
+			"request": {"method": "GET", "url": "https://example.com/app.js"},
+			"response": {
+				"status": 200, "statusText": "",
+				"headers": [
+					{"name": ":status", "value": "200"},
+					{"name": "content-encoding", "value": "gzip"},
+					{"name": "content-length", "value": "31"},
+					{"name": "etag", "value": "\"abc\""},
+					{"name": "last-modified", "value": "Sun, 01 Jan 2023 10:00:00 GMT"}
+				],
+				"content": {"size": 9, "mimeType": "text/javascript", "text": "let a = 1"}
+			}
+		},
+		{
+			"request": {"method": "GET", "url": "https://example.com/blocked.js"},
+			"response": {"status": 0, "statusText": "", "headers": [], "content": {"size": 0, "mimeType": ""}}
+		}
+	]}}`
+
+	cache := local.NewBasicCacheWithTimeFunc(testTime)
+	imported, err := har.Import(context.Background(), &cache, strings.NewReader(capture))
+	if err != nil || imported != 1 {
+		t.Fatalf("expected 1 entry imported, got %d and error %v", imported, err)
+	}
+
+	item, err := cache.Get(context.Background(), "GET#https://example.com/app.js")
+	if !errors.Is(err, caches.ErrCacheItemExpired) {
+		t.Fatalf("expected the imported item to be expired, got %v", err)
+	}
+	if item.ETAG != `"abc"` || item.LastModified == nil || !item.LastModified.Equal(testTime().Add(-2*time.Hour)) {
+		t.Errorf("expected the validators of the response headers, got %q and %v", item.ETAG, item.LastModified)
+	}
+
+	expected := "HTTP/1.1 200 OK\r\nContent-Length: 9\r\nEtag: \"abc\"\r\n" +
+		"Last-Modified: Sun, 01 Jan 2023 10:00:00 GMT\r\n\r\nlet a = 1"
+	if string(item.Response) != expected {
+		t.Errorf("expected response %q, got %q", expected, item.Response)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		har              string
+		expectedImported int
+	}{
+		{name: "empty", har: ""},
+		{name: "not a HAR", har: `{"format":"go-cond-cache","version":1}`},
+		{
+			name: "invalid base64",
+			har: `{"log":{"version":"1.2","entries":[
+				{"request":{"method":"GET","url":"https://example.com/a"},"response":{"status":200,"content":{"text":"ok"}}},
+				{"request":{"method":"GET","url":"https://example.com/b"},"response":{"status":200,"content":{"text":"!","encoding":"base64"}}}
+			]}}`,
+			expectedImported: 1,
+		},
+		{
+			name: "relative URL",
+			har:  `{"log":{"version":"1.2","entries":[{"request":{"method":"GET","url":"/a"},"response":{"status":200}}]}}`,
+		},
+		{
+			name: "invalid stale window",
+			har: `{"log":{"version":"1.2","entries":[{"request":{"method":"GET","url":"https://example.com/"},` +
+				`"response":{"status":200},"_condcache":{"key":"GET#https://example.com/","staleWindow":"soon"}}]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := local.NewBasicCacheWithTimeFunc(testTime)
+			imported, err := har.Import(context.Background(), &cache, strings.NewReader(tt.har))
+			if !errors.Is(err, caches.ErrInvalidExport) {
+				t.Errorf("expected ErrInvalidExport, got %v", err)
+			}
+			if imported != tt.expectedImported {
+				t.Errorf("expected %d entries imported, got %d", tt.expectedImported, imported)
+			}
+		})
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	if _, err := har.Export(context.Background(), newTestCache(t), caches.Selector{Tag: "api"}, &buf); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("export is not JSON: %v", err)
+	}
+	for _, field := range []string{`"startedDateTime"`, `"cookies": []`, `"_condcache"`, `"timings"`} {
+		if !strings.Contains(buf.String(), field) {
+			t.Errorf("expected the export to contain %s:\n%s", field, buf.String())
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package backend opens the Cache implementations selected by the command line tools.
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	awsdynamodb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/disk"
+	"github.com/dgduncan/go-cond-cache/caches/dynamodb"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/caches/postgres"
+)
+
+const (
+	// TypeLocal selects the in-memory local.ShardedCache.
+	TypeLocal = "local"
+	// TypePostgres selects the PostgreSQL backed cache.
+	TypePostgres = "postgres"
+	// TypeDynamoDB selects the DynamoDB backed cache.
+	TypeDynamoDB = "dynamodb"
+	// TypeDisk selects the cache storing items as files under a directory.
+	TypeDisk = "disk"
+)
+
+// ErrUnknownBackend is returned when the configured backend type is not supported.
+var ErrUnknownBackend = errors.New("unknown backend")
+
+// Config selects and configures the cache backend.
+type Config struct {
+	Type string `json:"type"`
+
+	LocalMaxEntries int   `json:"local_max_entries"` // zero means no limit
+	LocalMaxBytes   int64 `json:"local_max_bytes"`   // zero means no limit
+	// LocalSnapshotPath is the file the local cache is saved to and restored from, so
+	// that it survives a restart. Empty means the cache is not saved.
+	LocalSnapshotPath string `json:"local_snapshot_path"`
+	// LocalReadOnly loads the local cache from LocalSnapshotPath, which must exist, and
+	// never saves it back, so that the snapshot of a running process can be inspected.
+	LocalReadOnly bool `json:"-"`
+
+	PostgresDSN string `json:"postgres_dsn"`
+
+	DynamoDBTable    string `json:"dynamodb_table"`
+	DynamoDBRegion   string `json:"dynamodb_region"`
+	DynamoDBEndpoint string `json:"dynamodb_endpoint"`
+
+	DiskDir      string `json:"disk_dir"`
+	DiskMaxBytes int64  `json:"disk_max_bytes"` // zero means no limit
+}
+
+// Open creates the cache described by config. The returned function releases the
+// resources held by the cache and must be called once it is no longer used.
+func Open(ctx context.Context, config Config) (gocondcache.Cache, func() error, error) {
+	switch config.Type {
+	case TypeLocal, "":
+		return openLocal(ctx, config)
+	case TypePostgres:
+		return openPostgres(ctx, config)
+	case TypeDynamoDB:
+		return openDynamoDB(ctx, config)
+	case TypeDisk:
+		cache, err := disk.New(config.DiskDir, &disk.Config{MaxBytes: config.DiskMaxBytes}, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cache, cache.Close, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: %q", ErrUnknownBackend, config.Type)
+}
+
+func openLocal(ctx context.Context, config Config) (gocondcache.Cache, func() error, error) {
+	snapshotPath := config.LocalSnapshotPath
+	if config.LocalReadOnly {
+		snapshotPath = ""
+	}
+
+	cache := local.NewSharded(0, &local.Config{
+		MaxEntries:         config.LocalMaxEntries,
+		MaxBytes:           config.LocalMaxBytes,
+		DeleteExpiredItems: true,
+		RetentionGrace:     caches.DefaultExpiredDuration,
+		SnapshotPath:       snapshotPath,
+	}, nil)
+
+	if config.LocalReadOnly {
+		if _, err := cache.LoadSnapshot(ctx, config.LocalSnapshotPath); err != nil {
+			return nil, nil, errors.Join(err, cache.Close())
+		}
+	}
+
+	return cache, cache.Close, nil
+}
+
+func openPostgres(ctx context.Context, config Config) (gocondcache.Cache, func() error, error) {
+	db, err := sql.Open("postgres", config.PostgresDSN)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cache, err := postgres.New(ctx, db, nil)
+	if err != nil {
+		return nil, nil, errors.Join(err, db.Close())
+	}
+
+	return cache, db.Close, nil
+}
+
+func openDynamoDB(ctx context.Context, config Config) (gocondcache.Cache, func() error, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if config.DynamoDBRegion != "" {
+		opts = append(opts, awsconfig.WithRegion(config.DynamoDBRegion))
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := awsdynamodb.NewFromConfig(awsCfg, func(o *awsdynamodb.Options) {
+		if config.DynamoDBEndpoint != "" {
+			o.BaseEndpoint = aws.String(config.DynamoDBEndpoint)
+		}
+	})
+
+	cache, err := dynamodb.New(client, &dynamodb.Config{Table: config.DynamoDBTable})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cache, func() error { return nil }, nil
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	caValidity   = 365 * 24 * time.Hour
+	leafValidity = 7 * 24 * time.Hour
+	clockSkew    = time.Hour
+	serialBits   = 128
+)
+
+// ErrInvalidCertificateAuthority is returned when a certificate authority cannot be loaded.
+var ErrInvalidCertificateAuthority = errors.New("invalid certificate authority")
+
+// CertificateAuthority issues the certificates a forward proxy presents to its clients
+// when it intercepts their CONNECT tunnels. Clients must trust its certificate.
+type CertificateAuthority struct {
+	cert    *x509.Certificate
+	certDER []byte
+	key     *ecdsa.PrivateKey
+
+	lock   sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// NewCertificateAuthority generates a new self-signed certificate authority.
+func NewCertificateAuthority() (*CertificateAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "go-cond-cache proxy CA"},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCertificateAuthority(der, key)
+}
+
+// LoadCertificateAuthority loads a certificate authority from its PEM encoded
+// certificate and private key, as returned by CertificatePEM and KeyPEM.
+func LoadCertificateAuthority(certPEM, keyPEM []byte) (*CertificateAuthority, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, ErrInvalidCertificateAuthority
+	}
+
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidCertificateAuthority, err)
+	}
+
+	return newCertificateAuthority(certBlock.Bytes, key)
+}
+
+// CertificatePEM returns the PEM encoded certificate of the authority, to be added to
+// the trust store of the proxy's clients.
+func (ca *CertificateAuthority) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.certDER})
+}
+
+// KeyPEM returns the PEM encoded private key of the authority.
+func (ca *CertificateAuthority) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// certificateFor returns a certificate for host signed by the authority, issuing and
+// remembering it on first use.
+func (ca *CertificateAuthority) certificateFor(host string) (*tls.Certificate, error) {
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+
+	if leaf, found := ca.leaves[host]; found && time.Now().Before(leaf.Leaf.NotAfter) {
+		return leaf, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	leafCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := &tls.Certificate{
+		Certificate: [][]byte{der, ca.certDER},
+		PrivateKey:  key,
+		Leaf:        leafCert,
+	}
+	ca.leaves[host] = leaf
+
+	return leaf, nil
+}
+
+func newCertificateAuthority(der []byte, key *ecdsa.PrivateKey) (*CertificateAuthority, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidCertificateAuthority, err)
+	}
+	if !cert.IsCA {
+		return nil, ErrInvalidCertificateAuthority
+	}
+
+	return &CertificateAuthority{
+		cert:    cert,
+		certDER: der,
+		key:     key,
+		leaves:  make(map[string]*tls.Certificate),
+	}, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialBits))
+}
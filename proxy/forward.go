@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+)
+
+// DefaultDialTimeout is the default timeout for connecting to the target of a CONNECT tunnel.
+const DefaultDialTimeout = 10 * time.Second
+
+// hopHeaders are removed from intercepted requests before they are sent upstream.
+var hopHeaders = []string{ //nolint:gochecknoglobals // read only list of header names
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ForwardConfig defines the configuration options of a forward proxy.
+type ForwardConfig struct {
+	// CA enables interception of CONNECT tunnels. When set, the proxy terminates TLS
+	// with a certificate issued by CA and sends the requests of the tunnel through the
+	// transport, so HTTPS traffic is cached too. When nil, tunnels are passed through
+	// uncached.
+	CA *CertificateAuthority
+
+	// DialTimeout bounds connecting to the target of a passed through tunnel.
+	// Defaults to DefaultDialTimeout.
+	DialTimeout time.Duration
+}
+
+// NewForwardProxy creates a forward proxy, for use by clients through HTTP_PROXY and
+// HTTPS_PROXY. Plain HTTP requests are sent through transport, so they are cached. CONNECT
+// requests are tunneled to their target uncached, unless interception is enabled in config.
+// All clients share the cache, intercepted tunnels included, so requests carrying an
+// Authorization or Cookie header bypass it, and responses marked no-store or private, or
+// setting a cookie, are not stored.
+//
+// If the 'logger' is nil, a no-op logger writing to io.Discard will be used.
+func NewForwardProxy(transport http.RoundTripper, config *ForwardConfig, logger *slog.Logger) http.Handler {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	c := ForwardConfig{}
+	if config != nil {
+		c = *config
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = DefaultDialTimeout
+	}
+	transport = sharedCache{next: transport}
+
+	return &forwardProxy{
+		transport: transport,
+		logger:    logger,
+		c:         c,
+		plain: &httputil.ReverseProxy{
+			// the request URL is already absolute, it is forwarded as is
+			Rewrite:   func(*httputil.ProxyRequest) {},
+			Transport: transport,
+			ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+				logger.WarnContext(req.Context(), "upstream request failed", "url", req.URL.String(), "error", err)
+				w.WriteHeader(http.StatusBadGateway)
+			},
+		},
+	}
+}
+
+type forwardProxy struct {
+	transport http.RoundTripper
+	logger    *slog.Logger
+	plain     *httputil.ReverseProxy
+
+	c ForwardConfig
+}
+
+func (fp *forwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		fp.serveConnect(w, r)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a forward proxy, requests must use an absolute URL", http.StatusBadRequest)
+		return
+	}
+
+	fp.plain.ServeHTTP(w, r)
+}
+
+func (fp *forwardProxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	clientConn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		fp.logger.WarnContext(ctx, "connection cannot be hijacked", "host", r.Host, "error", err)
+		http.Error(w, "tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+	defer clientConn.Close()
+
+	if fp.c.CA != nil {
+		fp.intercept(ctx, clientConn, r.Host)
+		return
+	}
+
+	dialer := net.Dialer{Timeout: fp.c.DialTimeout}
+	targetConn, err := dialer.DialContext(ctx, "tcp", r.Host)
+	if err != nil {
+		fp.logger.WarnContext(ctx, "error connecting to tunnel target", "host", r.Host, "error", err)
+		_, _ = io.WriteString(clientConn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer targetConn.Close()
+
+	if _, err = io.WriteString(clientConn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go pipe(&wg, targetConn, clientConn)
+	go pipe(&wg, clientConn, targetConn)
+	wg.Wait()
+}
+
+// intercept terminates TLS on the client side of a CONNECT tunnel and answers the
+// requests sent through it using the transport.
+func (fp *forwardProxy) intercept(ctx context.Context, clientConn net.Conn, host string) {
+	if _, err := io.WriteString(clientConn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = host
+	}
+
+	tlsConn := tls.Server(clientConn, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return fp.c.CA.certificateFor(hello.ServerName)
+			}
+			return fp.c.CA.certificateFor(hostname)
+		},
+	})
+	defer tlsConn.Close()
+
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		fp.logger.WarnContext(ctx, "tls handshake with client failed", "host", host, "error", err)
+		return
+	}
+
+	reader := bufio.NewReader(tlsConn)
+	for {
+		req, readErr := http.ReadRequest(reader)
+		if readErr != nil {
+			if !errors.Is(readErr, io.EOF) {
+				fp.logger.DebugContext(ctx, "error reading intercepted request", "host", host, "error", readErr)
+			}
+			return
+		}
+
+		if !fp.forwardIntercepted(ctx, tlsConn, req, host) {
+			return
+		}
+	}
+}
+
+// forwardIntercepted sends one intercepted request upstream and writes the response
+// back to the client. It reports whether the connection can be reused.
+func (fp *forwardProxy) forwardIntercepted(ctx context.Context, conn net.Conn, req *http.Request, host string) bool {
+	req = req.WithContext(ctx)
+	req.RequestURI = ""
+	req.URL.Scheme = "https"
+	req.URL.Host = host
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+
+	resp, err := fp.transport.RoundTrip(req)
+	if err != nil {
+		fp.logger.WarnContext(ctx, "upstream request failed", "url", req.URL.String(), "error", err)
+		_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+		return false
+	}
+	defer resp.Body.Close()
+
+	if err = resp.Write(conn); err != nil {
+		return false
+	}
+
+	return !req.Close && !resp.Close
+}
+
+func pipe(wg *sync.WaitGroup, dst, src net.Conn) {
+	defer wg.Done()
+
+	_, _ = io.Copy(dst, src)
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+}
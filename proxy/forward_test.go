@@ -0,0 +1,215 @@
+package proxy_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/proxy"
+)
+
+func newOrigin(tlsOrigin bool, count *atomic.Int32) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		count.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("origin"))
+	})
+
+	if tlsOrigin {
+		return httptest.NewTLSServer(handler)
+	}
+	return httptest.NewServer(handler)
+}
+
+// get sends count requests to target through the proxy and checks every response body.
+func get(t *testing.T, client *http.Client, target string, count int) {
+	t.Helper()
+
+	for range count {
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatalf("request through proxy failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(body) != "origin" {
+			t.Fatalf("expected body %q, got %q", "origin", string(body))
+		}
+	}
+}
+
+func TestForwardProxyPlainHTTP(t *testing.T) {
+	t.Parallel()
+
+	var count atomic.Int32
+	origin := newOrigin(false, &count)
+	defer origin.Close()
+
+	cache := local.NewBasicCache()
+	transport := gocondcache.New(&cache, nil, nil, nil)(http.DefaultTransport)
+	proxyServer := httptest.NewServer(proxy.NewForwardProxy(transport, nil, nil))
+	defer proxyServer.Close()
+
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	get(t, client, origin.URL, 2)
+
+	if count.Load() != 1 {
+		t.Errorf("expected 1 request to origin, got %d", count.Load())
+	}
+}
+
+func TestForwardProxyConnect(t *testing.T) {
+	t.Parallel()
+
+	ca, err := proxy.NewCertificateAuthority()
+	if err != nil {
+		t.Fatalf("failed to create certificate authority: %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		ca               *proxy.CertificateAuthority
+		expectedRequests int32
+	}{
+		{name: "tunnel passed through uncached", ca: nil, expectedRequests: 2},
+		{name: "tunnel intercepted and cached", ca: ca, expectedRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var count atomic.Int32
+			origin := newOrigin(true, &count)
+			defer origin.Close()
+
+			// the proxy trusts the origin, the client trusts either the origin or the proxy CA
+			upstream := origin.Client().Transport.(*http.Transport).Clone()
+			cache := local.NewBasicCache()
+			transport := gocondcache.New(&cache, nil, nil, nil)(upstream)
+			proxyServer := httptest.NewServer(proxy.NewForwardProxy(transport, &proxy.ForwardConfig{CA: tt.ca}, nil))
+			defer proxyServer.Close()
+
+			roots := x509.NewCertPool()
+			if tt.ca != nil {
+				roots.AppendCertsFromPEM(tt.ca.CertificatePEM())
+			} else {
+				roots.AddCert(origin.Certificate())
+			}
+
+			proxyURL, _ := url.Parse(proxyServer.URL)
+			client := &http.Client{Transport: &http.Transport{
+				Proxy:           http.ProxyURL(proxyURL),
+				TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+			}}
+
+			get(t, client, origin.URL, 2)
+
+			if count.Load() != tt.expectedRequests {
+				t.Errorf("expected %d requests to origin, got %d", tt.expectedRequests, count.Load())
+			}
+		})
+	}
+}
+
+func TestForwardProxyInterceptedSharedCache(t *testing.T) {
+	t.Parallel()
+
+	ca, err := proxy.NewCertificateAuthority()
+	if err != nil {
+		t.Fatalf("failed to create certificate authority: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		cacheControl string
+		setCookie    bool
+	}{
+		{name: "set-cookie", cacheControl: "max-age=60", setCookie: true},
+		{name: "private", cacheControl: "private, max-age=60"},
+		{name: "no-store", cacheControl: "no-store"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// every response starts a new anonymous session
+			var sessions atomic.Int32
+			origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				session := strconv.Itoa(int(sessions.Add(1)))
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Cache-Control", tt.cacheControl)
+				if tt.setCookie {
+					w.Header().Set("Set-Cookie", "session="+session)
+				}
+				w.Write([]byte(session))
+			}))
+			defer origin.Close()
+
+			upstream := origin.Client().Transport.(*http.Transport).Clone()
+			cache := local.NewBasicCache()
+			transport := gocondcache.New(&cache, nil, nil, nil)(upstream)
+			proxyServer := httptest.NewServer(proxy.NewForwardProxy(transport, &proxy.ForwardConfig{CA: ca}, nil))
+			defer proxyServer.Close()
+
+			roots := x509.NewCertPool()
+			roots.AppendCertsFromPEM(ca.CertificatePEM())
+			proxyURL, _ := url.Parse(proxyServer.URL)
+
+			// two clients, each with its own tunnel, get their own session
+			for _, session := range []string{"1", "2"} {
+				client := &http.Client{Transport: &http.Transport{
+					Proxy:           http.ProxyURL(proxyURL),
+					TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+				}}
+				resp, err := client.Get(origin.URL)
+				if err != nil {
+					t.Fatalf("request through proxy failed: %v", err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				if string(body) != session {
+					t.Errorf("expected the response of session %s, got %q", session, string(body))
+				}
+				if tt.setCookie && resp.Header.Get("Set-Cookie") != "session="+session {
+					t.Errorf("expected the cookie of session %s, got %q", session, resp.Header.Get("Set-Cookie"))
+				}
+			}
+		})
+	}
+}
+
+func TestLoadCertificateAuthority(t *testing.T) {
+	t.Parallel()
+
+	ca, err := proxy.NewCertificateAuthority()
+	if err != nil {
+		t.Fatalf("failed to create certificate authority: %v", err)
+	}
+
+	keyPEM, err := ca.KeyPEM()
+	if err != nil {
+		t.Fatalf("failed to encode key: %v", err)
+	}
+
+	if _, err = proxy.LoadCertificateAuthority(ca.CertificatePEM(), keyPEM); err != nil {
+		t.Errorf("failed to load certificate authority: %v", err)
+	}
+	if _, err = proxy.LoadCertificateAuthority([]byte("garbage"), keyPEM); err == nil {
+		t.Error("expected error loading invalid certificate")
+	}
+}
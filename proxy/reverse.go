@@ -0,0 +1,105 @@
+// Package proxy provides HTTP proxies that send their upstream traffic through a
+// gocondcache.CacheTransport, so that clients not written in Go can share its cache.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrNoRoutes is returned when a reverse proxy is created without any route.
+	ErrNoRoutes = errors.New("no routes configured")
+	// ErrDuplicatePrefix is returned when two routes of a reverse proxy share a prefix.
+	ErrDuplicatePrefix = errors.New("duplicate route prefix")
+)
+
+// Route maps requests whose path starts with Prefix to an upstream server.
+type Route struct {
+	Prefix string `json:"prefix"` // eg. /github/
+
+	Upstream string `json:"upstream"` // eg. https://api.github.com
+
+	// StripPrefix removes Prefix from the request path before it is sent upstream.
+	StripPrefix bool `json:"strip_prefix"`
+}
+
+type route struct {
+	Route
+
+	upstream *url.URL
+}
+
+// NewReverseProxy creates a reverse proxy that forwards each request to the upstream of
+// the route with the longest matching prefix, using transport for the upstream request.
+// Requests matching no route are answered with 404 Not Found. All clients share the
+// cache, so requests carrying an Authorization or Cookie header bypass it, and responses
+// marked no-store or private, or setting a cookie, are not stored.
+//
+// If the 'logger' is nil, a no-op logger writing to io.Discard will be used.
+func NewReverseProxy(routes []Route, transport http.RoundTripper, logger *slog.Logger) (http.Handler, error) {
+	if len(routes) == 0 {
+		return nil, ErrNoRoutes
+	}
+
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	transport = sharedCache{next: transport}
+
+	parsed := make([]route, 0, len(routes))
+	for _, r := range routes {
+		if slices.ContainsFunc(parsed, func(p route) bool { return p.Prefix == r.Prefix }) {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicatePrefix, r.Prefix)
+		}
+		upstream, err := url.Parse(r.Upstream)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream for route %q: %w", r.Prefix, err)
+		}
+		if upstream.Scheme == "" || upstream.Host == "" {
+			return nil, fmt.Errorf("invalid upstream for route %q: %q is not an absolute URL", r.Prefix, r.Upstream)
+		}
+		parsed = append(parsed, route{Route: r, upstream: upstream})
+	}
+
+	// longest prefix first so that the first match is the most specific one
+	slices.SortStableFunc(parsed, func(a, b route) int {
+		return len(b.Prefix) - len(a.Prefix)
+	})
+
+	proxies := make(map[string]*httputil.ReverseProxy, len(parsed))
+	for _, r := range parsed {
+		proxies[r.Prefix] = &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				if r.StripPrefix {
+					pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.In.URL.Path, r.Prefix), "/")
+					pr.Out.URL.RawPath = ""
+				}
+				pr.SetURL(r.upstream)
+				pr.SetXForwarded()
+			},
+			Transport: transport,
+			ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+				logger.WarnContext(req.Context(), "upstream request failed", "url", req.URL.String(), "error", err)
+				w.WriteHeader(http.StatusBadGateway)
+			},
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, r := range parsed {
+			if strings.HasPrefix(req.URL.Path, r.Prefix) {
+				proxies[r.Prefix].ServeHTTP(w, req)
+				return
+			}
+		}
+		http.NotFound(w, req)
+	}), nil
+}
@@ -0,0 +1,229 @@
+package proxy_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/proxy"
+)
+
+func TestReverseProxy(t *testing.T) {
+	t.Parallel()
+
+	requests := map[string]int{}
+	upstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests[name]++
+			w.Header().Set("ETag", `"`+name+`"`)
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte(name + " " + r.URL.Path))
+		}))
+	}
+	users := upstream("users")
+	defer users.Close()
+	orders := upstream("orders")
+	defer orders.Close()
+
+	cache := local.NewBasicCache()
+	transport := gocondcache.New(&cache, &gocondcache.Config{StatusHeader: "X-Cache-Status"}, nil, nil)(
+		http.DefaultTransport)
+
+	handler, err := proxy.NewReverseProxy([]proxy.Route{
+		{Prefix: "/api/", Upstream: users.URL, StripPrefix: true},
+		{Prefix: "/api/orders/", Upstream: orders.URL, StripPrefix: true},
+	}, transport, nil)
+	if err != nil {
+		t.Fatalf("failed to create proxy: %v", err)
+	}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	server := httptest.NewServer(proxy.AccessLog(handler, logger, "X-Cache-Status"))
+	defer server.Close()
+
+	tests := []struct {
+		path           string
+		expectedStatus int
+		expectedBody   string
+		expectedCache  string
+	}{
+		{path: "/api/1", expectedStatus: http.StatusOK, expectedBody: "users /1", expectedCache: "MISS"},
+		{path: "/api/1", expectedStatus: http.StatusOK, expectedBody: "users /1", expectedCache: "HIT"},
+		{path: "/api/orders/7", expectedStatus: http.StatusOK, expectedBody: "orders /7", expectedCache: "MISS"},
+		{path: "/other", expectedStatus: http.StatusNotFound, expectedCache: ""},
+	}
+
+	for _, tt := range tests {
+		resp, err := http.Get(server.URL + tt.path)
+		if err != nil {
+			t.Fatalf("request to %s failed: %v", tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.expectedStatus, resp.StatusCode)
+		}
+		if tt.expectedBody != "" && string(body) != tt.expectedBody {
+			t.Errorf("%s: expected body %q, got %q", tt.path, tt.expectedBody, string(body))
+		}
+		if got := resp.Header.Get("X-Cache-Status"); got != tt.expectedCache {
+			t.Errorf("%s: expected cache status %q, got %q", tt.path, tt.expectedCache, got)
+		}
+	}
+
+	if requests["users"] != 1 || requests["orders"] != 1 {
+		t.Errorf("expected one upstream request per route, got %v", requests)
+	}
+	if !strings.Contains(logs.String(), "cache=HIT") {
+		t.Errorf("expected access log to contain the cache status, got %s", logs.String())
+	}
+}
+
+func TestReverseProxyCredentials(t *testing.T) {
+	t.Parallel()
+
+	// the upstream answers each client with its own, cacheable, representation
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get("Authorization") + r.Header.Get("Cookie")
+		w.Header().Set("ETag", `"`+user+`"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(user))
+	}))
+	defer upstream.Close()
+
+	cache := local.NewBasicCache()
+	transport := gocondcache.New(&cache, &gocondcache.Config{StatusHeader: "X-Cache-Status"}, nil, nil)(
+		http.DefaultTransport)
+	handler, err := proxy.NewReverseProxy([]proxy.Route{{Prefix: "/", Upstream: upstream.URL}}, transport, nil)
+	if err != nil {
+		t.Fatalf("failed to create proxy: %v", err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	tests := []struct {
+		header        string
+		value         string
+		expectedCache string
+	}{
+		{header: "Authorization", value: "alice", expectedCache: "BYPASS"},
+		{header: "Authorization", value: "bob", expectedCache: "BYPASS"},
+		{header: "Cookie", value: "alice", expectedCache: "BYPASS"},
+		{header: "Cookie", value: "bob", expectedCache: "BYPASS"},
+		{expectedCache: "MISS"},
+		{expectedCache: "HIT"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/me", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(body) != tt.value {
+			t.Errorf("%s %s: expected the response of its own client, got %q", tt.header, tt.value, string(body))
+		}
+		if got := resp.Header.Get("X-Cache-Status"); got != tt.expectedCache {
+			t.Errorf("%s %s: expected cache status %q, got %q", tt.header, tt.value, tt.expectedCache, got)
+		}
+	}
+}
+
+func TestReverseProxySharedCache(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		cacheControl string
+		setCookie    bool
+	}{
+		{name: "set-cookie", cacheControl: "max-age=60", setCookie: true},
+		{name: "private", cacheControl: "private, max-age=60"},
+		{name: "no-store", cacheControl: "no-store"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// every response starts a new anonymous session
+			var sessions atomic.Int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				session := strconv.Itoa(int(sessions.Add(1)))
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Cache-Control", tt.cacheControl)
+				if tt.setCookie {
+					w.Header().Set("Set-Cookie", "session="+session)
+				}
+				w.Write([]byte(session))
+			}))
+			defer upstream.Close()
+
+			cache := local.NewBasicCache()
+			transport := gocondcache.New(&cache, &gocondcache.Config{StatusHeader: "X-Cache-Status"}, nil, nil)(
+				http.DefaultTransport)
+			handler, err := proxy.NewReverseProxy([]proxy.Route{{Prefix: "/", Upstream: upstream.URL}}, transport, nil)
+			if err != nil {
+				t.Fatalf("failed to create proxy: %v", err)
+			}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			// two clients without credentials each get their own session
+			for _, session := range []string{"1", "2"} {
+				resp, err := http.Get(server.URL + "/")
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				if string(body) != session {
+					t.Errorf("expected the response of session %s, got %q", session, string(body))
+				}
+				if tt.setCookie && resp.Header.Get("Set-Cookie") != "session="+session {
+					t.Errorf("expected the cookie of session %s, got %q", session, resp.Header.Get("Set-Cookie"))
+				}
+				if got := resp.Header.Get("X-Cache-Status"); got != "MISS" {
+					t.Errorf("expected cache status MISS, got %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestNewReverseProxyValidation(t *testing.T) {
+	t.Parallel()
+
+	transport := http.DefaultTransport
+	if _, err := proxy.NewReverseProxy(nil, transport, nil); err == nil {
+		t.Error("expected error without routes")
+	}
+	if _, err := proxy.NewReverseProxy([]proxy.Route{{Prefix: "/", Upstream: "not-a-url"}}, transport, nil); err == nil {
+		t.Error("expected error for relative upstream")
+	}
+	_, err := proxy.NewReverseProxy([]proxy.Route{
+		{Prefix: "/api/", Upstream: "https://a.example.com"},
+		{Prefix: "/api/", Upstream: "https://b.example.com"},
+	}, transport, nil)
+	if !errors.Is(err, proxy.ErrDuplicatePrefix) {
+		t.Errorf("expected duplicate prefix error, got %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"net/http"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+)
+
+// credentialHeaders carry client credentials, so the responses to requests with one of
+// them may be specific to that client.
+var credentialHeaders = []string{"Authorization", "Cookie"} //nolint:gochecknoglobals // read only list of header names
+
+// sharedCache makes the transport behave as the cache shared between all the clients of
+// a proxy. Cache keys do not include the credentials of a client, so requests carrying
+// them are sent around the cache, and responses meant for a single client, such as
+// private ones or ones setting a cookie, are not stored, as either would serve the
+// response fetched for one client to another.
+type sharedCache struct {
+	next http.RoundTripper
+}
+
+func (s sharedCache) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := gocondcache.WithSharedCache(r.Context())
+	for _, header := range credentialHeaders {
+		if r.Header.Get(header) != "" {
+			ctx = gocondcache.WithBypass(ctx)
+			break
+		}
+	}
+
+	return s.next.RoundTrip(r.WithContext(ctx))
+}
@@ -0,0 +1,135 @@
+package gocondcache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgduncan/go-cond-cache/caches"
+)
+
+// Delete removes the item stored under k from cache.
+// Returns caches.ErrNotSupported if cache is not a Deleter.
+func Delete(ctx context.Context, cache Cache, k string) error {
+	deleter, ok := cache.(Deleter)
+	if !ok {
+		return caches.ErrNotSupported
+	}
+
+	return deleter.Delete(ctx, k)
+}
+
+// Peek looks up the item stored under k without recording an access to it if cache is a
+// Peeker. Other caches are read with Get, which on a size-bounded cache records an
+// access that makes the item the last to be evicted. The Response of the item may be
+// empty.
+func Peek(ctx context.Context, cache Cache, k string) (*CacheItem, error) {
+	if peeker, ok := cache.(Peeker); ok {
+		return peeker.Peek(ctx, k)
+	}
+
+	return cache.Get(ctx, k)
+}
+
+// Exists reports whether cache holds an item under k, fresh or expired, so that callers
+// can tell a purge that removed an item from one that had nothing to remove. The item is
+// looked up with Peek.
+func Exists(ctx context.Context, cache Cache, k string) (bool, error) {
+	_, err := Peek(ctx, cache, k)
+	switch {
+	case errors.Is(err, caches.ErrNoCacheItem):
+		return false, nil
+	case err != nil && !errors.Is(err, caches.ErrCacheItemExpired):
+		return false, err
+	}
+
+	return true, nil
+}
+
+// PurgePrefix removes every item of cache whose key starts with prefix and returns how
+// many were removed. Caches that are not a PrefixPurger are enumerated with Range.
+// Returns caches.ErrNotSupported if cache can neither purge by prefix nor be enumerated
+// and deleted from.
+func PurgePrefix(ctx context.Context, cache Cache, prefix string) (int, error) {
+	if purger, ok := cache.(PrefixPurger); ok {
+		return purger.PurgePrefix(ctx, prefix)
+	}
+
+	return purgeMatching(ctx, cache, func(k string, _ *CacheItem) bool {
+		return strings.HasPrefix(k, prefix)
+	})
+}
+
+// PurgeHost removes every item of cache cached for host and returns how many were
+// removed. Caches that are not a HostPurger are enumerated with Range.
+// Returns caches.ErrNotSupported if cache can neither purge by host nor be enumerated
+// and deleted from.
+func PurgeHost(ctx context.Context, cache Cache, host string) (int, error) {
+	if purger, ok := cache.(HostPurger); ok {
+		return purger.PurgeHost(ctx, host)
+	}
+
+	return purgeMatching(ctx, cache, func(k string, _ *CacheItem) bool {
+		return caches.KeyHost(k) == host
+	})
+}
+
+// PurgeTag removes every item of cache stored with tag, such as a tag listed in the
+// Surrogate-Key or Cache-Tag header of the cached response, and returns how many were
+// removed. Caches that are not a TagPurger are enumerated with Range.
+// Returns caches.ErrNotSupported if cache can neither purge by tag nor be enumerated
+// and deleted from.
+func PurgeTag(ctx context.Context, cache Cache, tag string) (int, error) {
+	if purger, ok := cache.(TagPurger); ok {
+		return purger.PurgeTag(ctx, tag)
+	}
+
+	return purgeMatching(ctx, cache, func(_ string, v *CacheItem) bool {
+		return slices.Contains(v.Tags, tag)
+	})
+}
+
+// SoftPurge expires the item stored under k so that the next request revalidates it.
+// Caches that are not a SoftPurger have the expiration of the item updated to the time
+// returned by now instead. A nil now defaults to time.Now.
+func SoftPurge(ctx context.Context, cache Cache, k string, now func() time.Time) error {
+	if purger, ok := cache.(SoftPurger); ok {
+		return purger.SoftPurge(ctx, k)
+	}
+
+	if now == nil {
+		now = time.Now
+	}
+
+	return cache.Update(ctx, k, now().UTC())
+}
+
+func purgeMatching(ctx context.Context, cache Cache, match func(k string, v *CacheItem) bool) (int, error) {
+	ranger, isRanger := cache.(Ranger)
+	deleter, isDeleter := cache.(Deleter)
+	if !isRanger || !isDeleter {
+		return 0, caches.ErrNotSupported
+	}
+
+	// keys are collected first as the cache must not be modified while it is enumerated
+	var keys []string
+	err := ranger.Range(ctx, func(k string, v *CacheItem) bool {
+		if match(k, v) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, k := range keys {
+		if err = deleter.Delete(ctx, k); err != nil {
+			return i, err
+		}
+	}
+
+	return len(keys), nil
+}
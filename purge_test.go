@@ -0,0 +1,209 @@
+package gocondcache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+// rangeDeleteCache only exposes Range and Delete, so purges have to fall back to them.
+type rangeDeleteCache struct {
+	gocondcache.Cache
+
+	cache *local.BasicCache
+}
+
+func (r rangeDeleteCache) Range(ctx context.Context, f func(k string, v *gocondcache.CacheItem) bool) error {
+	return r.cache.Range(ctx, f)
+}
+
+func (r rangeDeleteCache) Delete(ctx context.Context, k string) error {
+	return r.cache.Delete(ctx, k)
+}
+
+func seededCache(t *testing.T) *local.BasicCache {
+	t.Helper()
+
+	item := func(tags ...string) *gocondcache.CacheItem {
+		return &gocondcache.CacheItem{Expiration: testTime().Add(time.Hour), Tags: tags}
+	}
+
+	cache := gocondcachetest.NewLocalCache(gocondcachetest.NewClock(testTime()))
+	gocondcachetest.Seed(t, cache, map[string]*gocondcache.CacheItem{
+		"GET#https://a.example.com/users/1":      item("user-1", "users"),
+		"GET#https://a.example.com/users/2":      item("user-2", "users"),
+		"GET#https://a.example.com:8443/users/3": item("user-3"),
+		"GET#https://b.example.com/users/1":      item(),
+	})
+
+	return cache
+}
+
+func TestPurge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		purge         func(ctx context.Context, cache gocondcache.Cache) (int, error)
+		expectedCount int
+		expectedGone  []string
+	}{
+		{
+			name: "prefix",
+			purge: func(ctx context.Context, cache gocondcache.Cache) (int, error) {
+				return gocondcache.PurgePrefix(ctx, cache, "GET#https://a.example.com/")
+			},
+			expectedCount: 2,
+			expectedGone:  []string{"GET#https://a.example.com/users/1", "GET#https://a.example.com/users/2"},
+		},
+		{
+			name: "host",
+			purge: func(ctx context.Context, cache gocondcache.Cache) (int, error) {
+				return gocondcache.PurgeHost(ctx, cache, "a.example.com:8443")
+			},
+			expectedCount: 1,
+			expectedGone:  []string{"GET#https://a.example.com:8443/users/3"},
+		},
+		{
+			name: "tag",
+			purge: func(ctx context.Context, cache gocondcache.Cache) (int, error) {
+				return gocondcache.PurgeTag(ctx, cache, "users")
+			},
+			expectedCount: 2,
+			expectedGone:  []string{"GET#https://a.example.com/users/1", "GET#https://a.example.com/users/2"},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, fallback := range []bool{false, true} {
+			name := tt.name
+			if fallback {
+				name += " with range fallback"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				basic := seededCache(t)
+				var cache gocondcache.Cache = basic
+				if fallback {
+					cache = rangeDeleteCache{cache: basic}
+				}
+
+				count, err := tt.purge(context.Background(), cache)
+				if err != nil {
+					t.Fatalf("purge failed: %v", err)
+				}
+				if count != tt.expectedCount {
+					t.Errorf("expected %d purged items, got %d", tt.expectedCount, count)
+				}
+
+				for _, k := range tt.expectedGone {
+					if _, err = basic.Get(context.Background(), k); !errors.Is(err, caches.ErrNoCacheItem) {
+						t.Errorf("expected %s to be purged, got %v", k, err)
+					}
+				}
+				if _, err = basic.Get(context.Background(), "GET#https://b.example.com/users/1"); err != nil {
+					t.Errorf("expected unrelated item to be kept, got %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestExists(t *testing.T) {
+	t.Parallel()
+
+	cache := seededCache(t)
+	expired := &gocondcache.CacheItem{Expiration: testTime().Add(-time.Hour)}
+	if err := cache.Set(context.Background(), "GET#https://a.example.com/users/2", expired); err != nil {
+		t.Fatalf("failed to expire item: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		expected bool
+	}{
+		{name: "fresh", key: "GET#https://a.example.com/users/1", expected: true},
+		{name: "expired", key: "GET#https://a.example.com/users/2", expected: true},
+		{name: "missing", key: "GET#https://a.example.com/users/4", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			exists, err := gocondcache.Exists(context.Background(), cache, tt.key)
+			if err != nil {
+				t.Fatalf("exists failed: %v", err)
+			}
+			if exists != tt.expected {
+				t.Errorf("expected exists %t, got %t", tt.expected, exists)
+			}
+		})
+	}
+}
+
+func TestPurgeNotSupported(t *testing.T) {
+	t.Parallel()
+
+	cache := &failingCache{}
+	if err := gocondcache.Delete(context.Background(), cache, "k"); !errors.Is(err, caches.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported deleting, got %v", err)
+	}
+	if _, err := gocondcache.PurgePrefix(context.Background(), cache, "GET#"); !errors.Is(err, caches.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported purging by prefix, got %v", err)
+	}
+	if _, err := gocondcache.PurgeHost(context.Background(), cache, "example.com"); !errors.Is(err, caches.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported purging by host, got %v", err)
+	}
+}
+
+func TestSoftPurge(t *testing.T) {
+	t.Parallel()
+
+	cache := seededCache(t)
+	item, _ := cache.Get(context.Background(), "GET#https://a.example.com/users/1")
+
+	if err := gocondcache.SoftPurge(context.Background(), cache, "GET#https://a.example.com/users/1", nil); err != nil {
+		t.Fatalf("soft purge failed: %v", err)
+	}
+	if err := gocondcache.SoftPurge(context.Background(), cache, "missing", nil); !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected ErrNoCacheItem soft purging a missing item, got %v", err)
+	}
+
+	purged, err := cache.Get(context.Background(), "GET#https://a.example.com/users/1")
+	if err != nil {
+		t.Fatalf("expected soft purged item to be kept, got %v", err)
+	}
+	if !purged.Expiration.Equal(testTime()) {
+		t.Errorf("expected expiration %v, got %v", testTime(), purged.Expiration)
+	}
+	if !item.Expiration.Equal(testTime().Add(time.Hour)) {
+		t.Error("expected the item held by a previous reader to be left untouched")
+	}
+}
+
+func TestSoftPurgeFallback(t *testing.T) {
+	t.Parallel()
+
+	// a cache that is not a SoftPurger is expired through Update with the given clock
+	cache := struct{ gocondcache.Cache }{seededCache(t)}
+	now := func() time.Time { return testTime().Add(-time.Minute) }
+
+	if err := gocondcache.SoftPurge(context.Background(), cache, "GET#https://a.example.com/users/1", now); err != nil {
+		t.Fatalf("soft purge failed: %v", err)
+	}
+
+	purged, _ := cache.Get(context.Background(), "GET#https://a.example.com/users/1")
+	if !purged.Expiration.Equal(now()) {
+		t.Errorf("expected expiration %v, got %v", now(), purged.Expiration)
+	}
+}
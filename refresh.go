@@ -0,0 +1,229 @@
+package gocondcache
+
+import (
+	"container/list"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRefreshHotThreshold is the default number of requests within the window above
+	// which an entry is refreshed ahead of its expiration.
+	DefaultRefreshHotThreshold = 10
+	// DefaultRefreshWindow is the default window requests to an entry are counted over.
+	DefaultRefreshWindow = time.Minute
+	// DefaultRefreshFraction is the default fraction of its time to live after which a hot
+	// entry is refreshed.
+	DefaultRefreshFraction = 0.8
+	// DefaultRefreshMaxConcurrent is the default number of refreshes running at once.
+	DefaultRefreshMaxConcurrent = 4
+	// DefaultRefreshMaxTracked is the default number of entries whose requests are counted.
+	DefaultRefreshMaxTracked = 10000
+)
+
+// RefreshAheadConfig defines the configuration options for refreshing hot entries before
+// they expire. Hot entries are revalidated in the background with their validators, the
+// same way an expired entry is, so that requests keep being served from the cache.
+type RefreshAheadConfig struct {
+	// HotThreshold is the number of requests within Window an entry must exceed to be
+	// refreshed ahead of its expiration.
+	HotThreshold int
+
+	// Window is the duration requests to an entry are counted over.
+	Window time.Duration
+
+	// Fraction is the fraction of its time to live, between 0 and 1, after which a hot
+	// entry is refreshed, e.g. 0.8 refreshes an entry cached for 10 minutes once it is
+	// 8 minutes old.
+	Fraction float64
+
+	// XFetchBeta enables probabilistic early expiration when greater than zero. Each
+	// request to a hot entry refreshes it with a probability that increases as it gets
+	// closer to its expiration and with how long the origin took to answer it, spreading
+	// refreshes out. Values above 1 favor earlier refreshes. Entries whose fetch duration
+	// is unknown, such as entries stored by another process, fall back to Fraction.
+	// See https://cseweb.ucsd.edu/~avattani/papers/cache_stampede.pdf.
+	XFetchBeta float64
+
+	// MaxConcurrent is the maximum number of refreshes running at once. Refreshes beyond
+	// it are skipped and retried on a later request.
+	MaxConcurrent int
+
+	// MaxTracked is the maximum number of entries whose requests are counted. Beyond it,
+	// the least recently requested entry is forgotten to count a new one.
+	MaxTracked int
+}
+
+// refresher counts the requests served from each cache entry and decides when hot
+// entries are refreshed.
+type refresher struct {
+	threshold int
+	window    time.Duration
+	fraction  float64
+	beta      float64
+	maxTrack  int
+	now       func() time.Time
+
+	slots chan struct{}
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // tracked entries, most recently requested first
+}
+
+type refreshEntry struct {
+	key         string
+	windowStart time.Time
+	hits        int
+
+	ttl        time.Duration // time to live the entry was last stored or revalidated with
+	fetch      time.Duration // how long the origin took to answer the last time
+	refreshing bool
+}
+
+func newRefresher(config *RefreshAheadConfig, now func() time.Time) *refresher {
+	if config == nil {
+		return nil
+	}
+
+	rf := &refresher{
+		threshold: DefaultRefreshHotThreshold,
+		window:    DefaultRefreshWindow,
+		fraction:  DefaultRefreshFraction,
+		beta:      config.XFetchBeta,
+		maxTrack:  DefaultRefreshMaxTracked,
+		now:       now,
+		entries:   make(map[string]*list.Element),
+		order:     list.New(),
+	}
+	if config.HotThreshold > 0 {
+		rf.threshold = config.HotThreshold
+	}
+	if config.Window > 0 {
+		rf.window = config.Window
+	}
+	if config.Fraction > 0 && config.Fraction < 1 {
+		rf.fraction = config.Fraction
+	}
+	if config.MaxTracked > 0 {
+		rf.maxTrack = config.MaxTracked
+	}
+
+	maxConcurrent := DefaultRefreshMaxConcurrent
+	if config.MaxConcurrent > 0 {
+		maxConcurrent = config.MaxConcurrent
+	}
+	rf.slots = make(chan struct{}, maxConcurrent)
+
+	return rf
+}
+
+// stored records the time to live of an entry that was just stored or revalidated, and
+// how long the origin took to answer.
+func (rf *refresher) stored(k string, ttl, fetch time.Duration) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	e := rf.entry(k)
+	if e == nil {
+		return
+	}
+	e.ttl = ttl
+	e.fetch = fetch
+}
+
+// hit records a request served from the entry k and reports whether it is due to be
+// refreshed. When it is, done must be called once the refresh is over. date is the
+// Date header of the cached response, if any.
+func (rf *refresher) hit(k string, item *CacheItem, date *time.Time) bool {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	e := rf.entry(k)
+	if e == nil {
+		return false
+	}
+
+	now := rf.now().UTC()
+	if now.Sub(e.windowStart) > rf.window {
+		e.windowStart = now
+		e.hits = 0
+	}
+	e.hits++
+	if e.hits <= rf.threshold || e.refreshing {
+		return false
+	}
+
+	ttl := e.ttl
+	if ttl <= 0 && date != nil {
+		ttl = item.Expiration.Sub(*date)
+	}
+
+	remaining := item.Expiration.Sub(now)
+	var due bool
+	switch {
+	case rf.beta > 0 && e.fetch > 0:
+		// XFetch: refresh once now - fetch * beta * ln(rand) reaches the expiration
+		due = float64(remaining) <= float64(e.fetch)*rf.beta*-math.Log(rand.Float64()) //nolint:gosec // not used for security
+	case ttl > 0:
+		due = remaining <= time.Duration(float64(ttl)*(1-rf.fraction))
+	}
+	if !due {
+		return false
+	}
+
+	select {
+	case rf.slots <- struct{}{}:
+		e.refreshing = true
+		return true
+	default:
+		return false
+	}
+}
+
+// done marks the refresh of the entry k as over.
+func (rf *refresher) done(k string) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if elem, found := rf.entries[k]; found {
+		refreshEntryOf(elem).refreshing = false
+	}
+	<-rf.slots
+}
+
+// entry returns the tracked entry k, adding it if there is room. When the tracked entries
+// are full, the least recently requested one that is not being refreshed is forgotten.
+// The lock must be held.
+func (rf *refresher) entry(k string) *refreshEntry {
+	if elem, found := rf.entries[k]; found {
+		rf.order.MoveToFront(elem)
+		return refreshEntryOf(elem)
+	}
+
+	if len(rf.entries) >= rf.maxTrack {
+		// at most MaxConcurrent entries are being refreshed, so few are skipped
+		for elem := rf.order.Back(); elem != nil; elem = elem.Prev() {
+			if e := refreshEntryOf(elem); !e.refreshing {
+				rf.order.Remove(elem)
+				delete(rf.entries, e.key)
+				break
+			}
+		}
+		if len(rf.entries) >= rf.maxTrack {
+			return nil
+		}
+	}
+
+	e := &refreshEntry{key: k, windowStart: rf.now().UTC()}
+	rf.entries[k] = rf.order.PushFront(e)
+
+	return e
+}
+
+func refreshEntryOf(elem *list.Element) *refreshEntry {
+	e, _ := elem.Value.(*refreshEntry)
+	return e
+}
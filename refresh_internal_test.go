@@ -0,0 +1,38 @@
+package gocondcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefresherMaxTracked(t *testing.T) {
+	t.Parallel()
+
+	now := func() time.Time { return time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC) }
+	rf := newRefresher(&RefreshAheadConfig{MaxTracked: 3, MaxConcurrent: 1}, now)
+
+	for _, k := range []string{"a", "b", "c"} {
+		rf.entry(k)
+	}
+	// a is requested again, and b is being refreshed
+	rf.entry("a")
+	rf.entry("b").refreshing = true
+
+	// c is the least recently requested entry that is not being refreshed
+	if rf.entry("d") == nil {
+		t.Fatal("expected the new entry to be tracked")
+	}
+	for k, tracked := range map[string]bool{"a": true, "b": true, "c": false, "d": true} {
+		if _, found := rf.entries[k]; found != tracked {
+			t.Errorf("expected %q tracked to be %t, got %t", k, tracked, found)
+		}
+	}
+
+	// no entry can be forgotten while every one of them is being refreshed
+	for _, elem := range rf.entries {
+		refreshEntryOf(elem).refreshing = true
+	}
+	if rf.entry("e") != nil || len(rf.entries) != 3 {
+		t.Errorf("expected no room for a new entry, got %d tracked", len(rf.entries))
+	}
+}
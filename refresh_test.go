@@ -0,0 +1,119 @@
+package gocondcache_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+)
+
+func TestRefreshAhead(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name              string
+		config            *gocondcache.RefreshAheadConfig
+		advance           time.Duration
+		requests          int
+		expectedRefreshes uint64
+	}{
+		{
+			name:              "hot entry past the fraction is refreshed",
+			config:            &gocondcache.RefreshAheadConfig{HotThreshold: 2, Fraction: 0.5},
+			advance:           60 * time.Second,
+			requests:          3,
+			expectedRefreshes: 1,
+		},
+		{
+			name:              "cold entry is not refreshed",
+			config:            &gocondcache.RefreshAheadConfig{HotThreshold: 5, Fraction: 0.5},
+			advance:           60 * time.Second,
+			requests:          3,
+			expectedRefreshes: 0,
+		},
+		{
+			name:              "hot entry before the fraction is not refreshed",
+			config:            &gocondcache.RefreshAheadConfig{HotThreshold: 2, Fraction: 0.5},
+			advance:           10 * time.Second,
+			requests:          3,
+			expectedRefreshes: 0,
+		},
+		{
+			name:              "xfetch refreshes early",
+			config:            &gocondcache.RefreshAheadConfig{HotThreshold: 2, XFetchBeta: 1e12},
+			advance:           0,
+			requests:          3,
+			expectedRefreshes: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var revalidations atomic.Int32
+			origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Cache-Control", "max-age=100")
+				if r.Header.Get("If-None-Match") == `"v1"` {
+					revalidations.Add(1)
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Write([]byte("hot"))
+			}))
+			defer origin.Close()
+
+			var offset atomic.Int64
+			now := func() time.Time { return testTime().Add(time.Duration(offset.Load())) }
+
+			cache := local.NewBasicCacheWithTimeFunc(now)
+			config := &gocondcache.Config{RefreshAhead: tt.config}
+			transport := gocondcache.New(&cache, config, now, nil)(http.DefaultTransport).(*gocondcache.CacheTransport)
+			client := &http.Client{Transport: transport}
+
+			get := func() {
+				resp, err := client.Get(origin.URL)
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+				resp.Body.Close()
+			}
+
+			get()
+			offset.Store(int64(tt.advance))
+			for range tt.requests {
+				get()
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for transport.Stats().Refreshes < tt.expectedRefreshes && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			stats := transport.Stats()
+			if stats.Refreshes != tt.expectedRefreshes || uint64(revalidations.Load()) != tt.expectedRefreshes {
+				t.Fatalf("expected %d refreshes, got %d with %d revalidations",
+					tt.expectedRefreshes, stats.Refreshes, revalidations.Load())
+			}
+			if stats.Misses != 1 || stats.Hits != uint64(tt.requests) {
+				t.Errorf("expected every request but the first to be a hit, got %+v", stats)
+			}
+
+			if tt.expectedRefreshes > 0 {
+				item, err := cache.Get(context.Background(), "GET#"+origin.URL)
+				if err != nil {
+					t.Fatalf("expected refreshed item to be fresh, got %v", err)
+				}
+				if expected := now().UTC().Add(100 * time.Second); !item.Expiration.Equal(expected) {
+					t.Errorf("expected expiration %v, got %v", expected, item.Expiration)
+				}
+			}
+		})
+	}
+}
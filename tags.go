@@ -0,0 +1,43 @@
+package gocondcache
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	// headerSurrogateKey lists space separated tags, as used by Fastly and Varnish.
+	headerSurrogateKey = "Surrogate-Key"
+	// headerCacheTag lists comma separated tags, as used by Cloudflare and Akamai.
+	headerCacheTag = "Cache-Tag"
+)
+
+// itemTags returns the tags attached to ctx with WithTags followed by the tags listed in
+// the Surrogate-Key and Cache-Tag headers of a response, without duplicates.
+func itemTags(ctx context.Context, h http.Header) []string {
+	tags := slices.Clone(tagsFromContext(ctx))
+	for _, v := range h.Values(headerSurrogateKey) {
+		tags = append(tags, strings.Fields(v)...)
+	}
+	for _, v := range h.Values(headerCacheTag) {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	unique := tags[:0]
+	for i, tag := range tags {
+		if !slices.Contains(tags[:i], tag) {
+			unique = append(unique, tag)
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	return unique
+}
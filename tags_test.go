@@ -0,0 +1,106 @@
+package gocondcache_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+)
+
+func TestResponseTags(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		header       http.Header
+		contextTags  []string
+		expectedTags []string
+	}{
+		{
+			name:         "surrogate key",
+			header:       http.Header{"Surrogate-Key": {"user-1  users"}},
+			expectedTags: []string{"user-1", "users"},
+		},
+		{
+			name:         "cache tag",
+			header:       http.Header{"Cache-Tag": {"user-1, users,"}},
+			expectedTags: []string{"user-1", "users"},
+		},
+		{
+			name:         "merged with context tags without duplicates",
+			header:       http.Header{"Surrogate-Key": {"users"}, "Cache-Tag": {"user-1,users"}},
+			contextTags:  []string{"api"},
+			expectedTags: []string{"api", "users", "user-1"},
+		},
+		{
+			name:         "no tags",
+			header:       http.Header{},
+			expectedTags: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				for k, v := range tt.header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Cache-Control", "max-age=60")
+			}))
+			defer server.Close()
+
+			cache := local.NewBasicCacheWithTimeFunc(testTime)
+			client := &http.Client{Transport: gocondcache.New(&cache, nil, testTime, nil)(http.DefaultTransport)}
+
+			ctx := context.Background()
+			if tt.contextTags != nil {
+				ctx = gocondcache.WithTags(ctx, tt.contextTags...)
+			}
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			item, err := cache.Get(context.Background(), caches.Key(*req))
+			if err != nil {
+				t.Fatalf("expected response to be cached, got %v", err)
+			}
+			if !slices.Equal(item.Tags, tt.expectedTags) {
+				t.Errorf("expected tags %v, got %v", tt.expectedTags, item.Tags)
+			}
+		})
+	}
+}
+
+func TestPurgeTagReplacedItem(t *testing.T) {
+	t.Parallel()
+
+	cache := local.NewBasicCacheWithTimeFunc(testTime)
+	ctx := context.Background()
+	_ = cache.Set(ctx, "k", &gocondcache.CacheItem{Expiration: testTime(), Tags: []string{"old"}})
+	_ = cache.Set(ctx, "k", &gocondcache.CacheItem{Expiration: testTime(), Tags: []string{"new"}})
+
+	purged, err := gocondcache.PurgeTag(ctx, &cache, "old")
+	if err != nil || purged != 0 {
+		t.Fatalf("expected no item purged by a tag no longer in use, got %d, %v", purged, err)
+	}
+
+	purged, err = gocondcache.PurgeTag(ctx, &cache, "new")
+	if err != nil || purged != 1 {
+		t.Fatalf("expected 1 item purged, got %d, %v", purged, err)
+	}
+	if _, err = cache.Get(ctx, "k"); !errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected item to be purged, got %v", err)
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgduncan/go-cond-cache/caches"
@@ -23,6 +25,8 @@ const (
 
 	headerIfMatch     = "If-Match"
 	headerIfNoneMatch = "If-None-Match"
+	headerIfRange     = "If-Range"
+	headerRange       = "Range"
 
 	headerLastModified      = "Last-Modified"
 	headerIfModifiedSince   = "If-Modified-Since"
@@ -33,6 +37,26 @@ const (
 	directiveCacheControlMaxAge = "max-age"
 )
 
+// MethodQuery is the HTTP QUERY method, a safe and idempotent request that carries its
+// query in the body. See https://httpwg.org/http-extensions/draft-ietf-httpbis-safe-method-w-body.html.
+const MethodQuery = "QUERY"
+
+// CacheStatus describes how the transport answered a request.
+type CacheStatus string
+
+const (
+	// CacheStatusHit means the response was served from a fresh cache item.
+	CacheStatusHit CacheStatus = "HIT"
+	// CacheStatusMiss means the response came from the origin.
+	CacheStatusMiss CacheStatus = "MISS"
+	// CacheStatusRevalidated means an expired cache item was revalidated by the origin and served.
+	CacheStatusRevalidated CacheStatus = "REVALIDATED"
+	// CacheStatusStale means an expired cache item was served because revalidation failed.
+	CacheStatusStale CacheStatus = "STALE"
+	// CacheStatusBypass means the request was not eligible for caching and went to the origin.
+	CacheStatusBypass CacheStatus = "BYPASS"
+)
+
 // CacheTransport implements http.RoundTripper and provides caching functionality
 // for HTTP requests. It handles cache validation using ETags and manages cache
 // expiration based on Cache-Control headers.
@@ -43,7 +67,45 @@ type CacheTransport struct {
 	logger *slog.Logger
 	now    func() time.Time
 
-	c Config
+	c         Config
+	refresher *refresher
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	revalidated atomic.Uint64
+	stale       atomic.Uint64
+	bypassed    atomic.Uint64
+	failures    atomic.Uint64
+	refreshes   atomic.Uint64
+}
+
+// TransportStats holds counters describing how a CacheTransport answered requests.
+type TransportStats struct {
+	Hits        uint64 // responses served from a fresh cache item
+	Misses      uint64 // responses fetched from the origin
+	Revalidated uint64 // expired cache items revalidated by the origin
+	Stale       uint64 // expired cache items served because revalidation failed
+	Bypassed    uint64 // requests not eligible for caching
+	Errors      uint64 // requests that failed
+	Refreshes   uint64 // hot cache items refreshed ahead of their expiration
+}
+
+// Stats returns a snapshot of the request counters.
+func (c *CacheTransport) Stats() TransportStats {
+	return TransportStats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Revalidated: c.revalidated.Load(),
+		Stale:       c.stale.Load(),
+		Bypassed:    c.bypassed.Load(),
+		Errors:      c.failures.Load(),
+		Refreshes:   c.refreshes.Load(),
+	}
+}
+
+// Cache returns the cache the transport stores responses in.
+func (c *CacheTransport) Cache() Cache {
+	return c.cache
 }
 
 // RoundTrip implements http.RoundTripper interface and handles the caching logic
@@ -56,15 +118,65 @@ type CacheTransport struct {
 // 3. Attempts revalidation if expired
 // 4. Caches new responses with ETags.
 func (c *CacheTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, status, err := c.roundTrip(r)
+	c.count(status, err)
+	if recorded := statusFromContext(r.Context()); recorded != nil {
+		*recorded = status
+	}
+	if resp != nil && c.c.StatusHeader != "" {
+		resp.Header.Set(c.c.StatusHeader, string(status))
+	}
+
+	return resp, err
+}
+
+func (c *CacheTransport) count(status CacheStatus, err error) {
+	if err != nil {
+		c.failures.Add(1)
+		return
+	}
+
+	switch status {
+	case CacheStatusHit:
+		c.hits.Add(1)
+	case CacheStatusMiss:
+		c.misses.Add(1)
+	case CacheStatusRevalidated:
+		c.revalidated.Add(1)
+	case CacheStatusStale:
+		c.stale.Add(1)
+	case CacheStatusBypass:
+		c.bypassed.Add(1)
+	}
+}
+
+func (c *CacheTransport) roundTrip(r *http.Request) (*http.Response, CacheStatus, error) {
 	ctx := r.Context()
 
+	r, key, cacheable, err := c.requestKey(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if !cacheable || bypassFromContext(ctx) {
+		c.logger.DebugContext(ctx, "request not cacheable, bypassing cache", "url", r.URL.String(), "method", r.Method)
+		resp, transportError := c.Wrapped.RoundTrip(r)
+		return resp, CacheStatusBypass, transportError
+	}
+
 	// check if cached value exists within the cache
-	item, err := c.cache.Get(ctx, caches.Key(*r))
+	item, err := c.get(ctx, key)
+	if err == nil && revalidateFromContext(ctx) {
+		// the item is fresh but the caller asked for it to be revalidated anyway
+		err = caches.ErrCacheItemExpired
+	}
 	if err == nil { // cache hit
 		c.logger.DebugContext(ctx, "cache item found", "url", r.URL.String())
 
-		nr := bufio.NewReader(bytes.NewReader(item.Response))
-		return http.ReadResponse(nr, nil)
+		cached, readErr := readCachedResponse(item)
+		if readErr == nil {
+			c.maybeRefreshAhead(r, key, item, cached)
+		}
+		return cached, CacheStatusHit, readErr
 	}
 
 	// cache miss
@@ -84,21 +196,43 @@ func (c *CacheTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 		if item.LastModified != nil {
 			r.Header.Add(headerIfModifiedSince, item.LastModified.Format(http.TimeFormat))
 		}
-	} else {
+	} else if errors.Is(err, caches.ErrNoCacheItem) {
 		c.logger.DebugContext(ctx, "cache item not found", "url", r.URL.String())
+	} else {
+		if c.c.BackendErrorPolicy == FailClosed {
+			return nil, "", err
+		}
+		c.logger.WarnContext(ctx, "error reading cache, treating as miss", "url", r.URL.String(), "error", err)
+		item = nil
 	}
 
+	fetchStart := time.Now()
 	resp, transportError := c.Wrapped.RoundTrip(r)
+	fetchDuration := time.Since(fetchStart)
 	if transportError != nil {
-		return resp, transportError
+		if c.canServeStale(item) {
+			c.logger.DebugContext(ctx, "revalidation failed, serving stale cache item",
+				"url", r.URL.String(), "error", transportError)
+			cached, readErr := readCachedResponse(item)
+			return cached, CacheStatusStale, readErr
+		}
+		return resp, "", transportError
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError && c.canServeStale(item) {
+		c.logger.DebugContext(ctx, "origin returned server error, serving stale cache item",
+			"url", r.URL.String(), "status", resp.StatusCode)
+		resp.Body.Close()
+		cached, readErr := readCachedResponse(item)
+		return cached, CacheStatusStale, readErr
 	}
 
 	if resp.StatusCode != http.StatusPreconditionFailed && (resp.StatusCode < 200 || resp.StatusCode > 399) {
-		return resp, transportError
+		return resp, CacheStatusMiss, transportError
 	}
 
 	// re-validation sucesfull
-	if resp.StatusCode == http.StatusNotModified {
+	if resp.StatusCode == http.StatusNotModified && item != nil {
 		// cache item as been revalidated as the response is 304
 		c.logger.DebugContext(ctx, "cache item successfully revalidated", "url", r.URL.String())
 		maxAge := getTimeToCache(resp, c.c.DomainOverrides, c.logger)
@@ -109,12 +243,20 @@ func (c *CacheTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 			"expiration",
 			c.now().UTC().Add(maxAge).Format(time.RFC3339))
 
-		if updateErr := c.cache.Update(ctx, caches.Key(*resp.Request), c.now().UTC().Add(maxAge)); updateErr != nil {
+		if updateErr := c.update(ctx, key, c.now().UTC().Add(maxAge)); updateErr != nil {
 			c.logger.WarnContext(ctx, "error updating cache with response", "error", updateErr)
+		} else if c.refresher != nil {
+			c.refresher.stored(key, maxAge, fetchDuration)
 		}
 
-		nr := bufio.NewReader(bytes.NewReader(item.Response))
-		return http.ReadResponse(nr, nil)
+		cached, readErr := readCachedResponse(item)
+		return cached, CacheStatusRevalidated, readErr
+	}
+
+	directives := cacheControlDirectives(getCacheControlHeader(resp))
+	if sharedCacheFromContext(ctx) && !sharedStorable(r, resp.Header, directives) {
+		c.logger.DebugContext(ctx, "response not storable by a shared cache, not caching response", "url", r.URL.String())
+		return resp, CacheStatusMiss, transportError
 	}
 
 	// check if response contains conditional request header i.e etag or last-modified
@@ -123,26 +265,169 @@ func (c *CacheTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 
 	if etag == "" && lastModified == nil { // if no conditional headers found, we don't cache the response
 		c.logger.DebugContext(ctx, "no etag or last-modified header found, not caching response", "url", r.URL.String())
-		return resp, transportError
+		return resp, CacheStatusMiss, transportError
 	}
 
 	// cache the response
 	maxAge := getTimeToCache(resp, c.c.DomainOverrides, c.logger)
 	c.logger.DebugContext(ctx, "caching response", "url", r.URL.String(), "expiration", c.now().UTC().Add(maxAge))
 	resBytes, _ := httputil.DumpResponse(resp, true)
-	if cacheErr := c.cache.Set(ctx, caches.Key(*resp.Request), &CacheItem{
+	if cacheErr := c.set(ctx, key, &CacheItem{
 		ETAG:         etag,
 		LastModified: lastModified,
 		Response:     resBytes,
 		Expiration:   c.now().UTC().Add(maxAge),
+		Tags:         itemTags(ctx, resp.Header),
+		StaleWindow:  staleWindowFromContext(ctx),
 	}); cacheErr != nil {
 		c.logger.WarnContext(ctx, "error caching response", "error", cacheErr)
+	} else if c.refresher != nil {
+		c.refresher.stored(key, maxAge, fetchDuration)
+	}
+
+	return resp, CacheStatusMiss, transportError
+}
+
+// maybeRefreshAhead counts a request served from the cache item stored under key and,
+// if the item is hot and close enough to its expiration, revalidates it in the
+// background the same way an expired item is.
+func (c *CacheTransport) maybeRefreshAhead(r *http.Request, key string, item *CacheItem, cached *http.Response) {
+	if c.refresher == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return
+	}
+	if !c.refresher.hit(key, item, parseHTTPDate(cached.Header.Get("Date"))) {
+		return
+	}
+
+	// the refresh outlives the request, and must not carry its conditional headers
+	req := r.Clone(withRevalidate(context.WithoutCancel(r.Context())))
+	req.Header.Del(headerIfNoneMatch)
+	req.Header.Del(headerIfModifiedSince)
+
+	go func() {
+		defer c.refresher.done(key)
+
+		ctx := req.Context()
+		c.logger.DebugContext(ctx, "refreshing hot cache item ahead of expiration", "url", req.URL.String())
+		resp, _, err := c.roundTrip(req)
+		if err != nil {
+			c.logger.WarnContext(ctx, "error refreshing cache item", "url", req.URL.String(), "error", err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		c.refreshes.Add(1)
+	}()
+}
+
+// requestKey returns the cache key of r and whether it may be cached at all. Requests
+// cached on their body have it buffered, in which case the returned request is a clone
+// of r whose body can be read again.
+func (c *CacheTransport) requestKey(r *http.Request) (*http.Request, string, bool, error) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return r, caches.Key(*r), true, nil
+	}
+
+	bc := c.c.BodyCaching
+	if bc == nil || !slices.Contains(bc.Methods, r.Method) {
+		return r, "", false, nil
+	}
+	if len(bc.Hosts) > 0 && !slices.Contains(bc.Hosts, r.URL.Host) && !slices.Contains(bc.Hosts, r.URL.Hostname()) {
+		return r, "", false, nil
+	}
+
+	maxBodySize := bc.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return r, caches.KeyWithBody(*r, nil), true, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		r.Body.Close()
+		return r, "", false, err
+	}
+
+	clone := r.Clone(r.Context())
+	if int64(len(body)) > maxBodySize {
+		// too large to cache, stitch the buffered prefix back in front of the rest
+		clone.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return clone, "", false, nil
 	}
 
-	return resp, transportError
+	r.Body.Close()
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return clone, caches.KeyWithBody(*clone, body), true, nil
+}
+
+func (c *CacheTransport) get(ctx context.Context, k string) (*CacheItem, error) {
+	ctx, cancel := withOptionalTimeout(ctx, c.c.ReadTimeout)
+	defer cancel()
+
+	return c.cache.Get(ctx, k)
+}
+
+func (c *CacheTransport) peek(ctx context.Context, k string) (*CacheItem, error) {
+	ctx, cancel := withOptionalTimeout(ctx, c.c.ReadTimeout)
+	defer cancel()
+
+	return Peek(ctx, c.cache, k)
+}
+
+func (c *CacheTransport) set(ctx context.Context, k string, v *CacheItem) error {
+	ctx, cancel := withOptionalTimeout(ctx, c.c.WriteTimeout)
+	defer cancel()
+
+	return c.cache.Set(ctx, k, v)
+}
+
+func (c *CacheTransport) update(ctx context.Context, k string, expiration time.Time) error {
+	ctx, cancel := withOptionalTimeout(ctx, c.c.WriteTimeout)
+	defer cancel()
+
+	return c.cache.Update(ctx, k, expiration)
+}
+
+func withOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// canServeStale reports whether an expired item is still within the stale window it
+// was stored with.
+func (c *CacheTransport) canServeStale(item *CacheItem) bool {
+	if item == nil || item.StaleWindow <= 0 {
+		return false
+	}
+
+	return !c.now().UTC().After(item.Expiration.Add(item.StaleWindow))
+}
+
+func readCachedResponse(item *CacheItem) (*http.Response, error) {
+	nr := bufio.NewReader(bytes.NewReader(item.Response))
+	return http.ReadResponse(nr, nil)
 }
 
 func getTimeToCache(r *http.Response, c []DomainOverride, logger *slog.Logger) time.Duration {
+	// check to see if the request overrides the time to cache
+	if ttl, ok := ttlFromContext(r.Request.Context()); ok {
+		logger.DebugContext(r.Request.Context(), "request ttl override found")
+		return ttl
+	}
+
 	// check to see if any domain overrides exist
 	for _, v := range c {
 		if strings.HasPrefix(r.Request.URL.Host+r.Request.URL.Path, v.URI) {
@@ -187,6 +472,21 @@ func getMaxAge(r *http.Response) time.Duration {
 	return maxAge
 }
 
+// cacheControlDirectives parses a Cache-Control header value into its directives,
+// mapping lowercased directive names to their unquoted arguments.
+func cacheControlDirectives(cacheControl string) map[string]string {
+	directives := make(map[string]string)
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+
+	return directives
+}
+
 func getETAGHeader(r *http.Response) string {
 	return r.Header.Get(headerETAG)
 }
@@ -242,6 +542,13 @@ func New(
 	}
 
 	return func(rt http.RoundTripper) http.RoundTripper {
-		return &CacheTransport{Wrapped: rt, cache: cache, now: nowFunc, logger: logger, c: c}
+		return &CacheTransport{
+			Wrapped:   rt,
+			cache:     cache,
+			now:       nowFunc,
+			logger:    logger,
+			c:         c,
+			refresher: newRefresher(c.RefreshAhead, nowFunc),
+		}
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -330,3 +331,122 @@ func TestLastModifiedParsingErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestBodyCaching(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		method           string
+		config           *gocondcache.BodyCachingConfig
+		bodies           []string
+		expectedRequests int
+	}{
+		{
+			name:             "POST not cached without body caching",
+			method:           http.MethodPost,
+			config:           nil,
+			bodies:           []string{`{"query":"a"}`, `{"query":"a"}`},
+			expectedRequests: 2,
+		},
+		{
+			name:             "POST with same body served from cache",
+			method:           http.MethodPost,
+			config:           &gocondcache.BodyCachingConfig{Methods: []string{http.MethodPost}},
+			bodies:           []string{`{"query":"a"}`, `{"query":"a"}`},
+			expectedRequests: 1,
+		},
+		{
+			name:             "POST with different body not served from cache",
+			method:           http.MethodPost,
+			config:           &gocondcache.BodyCachingConfig{Methods: []string{http.MethodPost}},
+			bodies:           []string{`{"query":"a"}`, `{"query":"b"}`},
+			expectedRequests: 2,
+		},
+		{
+			name:             "QUERY with same body served from cache",
+			method:           gocondcache.MethodQuery,
+			config:           &gocondcache.BodyCachingConfig{Methods: []string{gocondcache.MethodQuery}},
+			bodies:           []string{"select *", "select *"},
+			expectedRequests: 1,
+		},
+		{
+			name:   "body over size limit not cached",
+			method: http.MethodPost,
+			config: &gocondcache.BodyCachingConfig{
+				Methods:     []string{http.MethodPost},
+				MaxBodySize: 4,
+			},
+			bodies:           []string{`{"query":"a"}`, `{"query":"a"}`},
+			expectedRequests: 2,
+		},
+		{
+			name:   "host not configured not cached",
+			method: http.MethodPost,
+			config: &gocondcache.BodyCachingConfig{
+				Methods: []string{http.MethodPost},
+				Hosts:   []string{"api.example.com"},
+			},
+			bodies:           []string{`{"query":"a"}`, `{"query":"a"}`},
+			expectedRequests: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			requestCount := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestCount++
+
+				// the upstream must always receive the full body
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("failed to read request body: %v", err)
+				}
+
+				w.Header().Set("ETag", `"abc"`)
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.WriteHeader(http.StatusOK)
+				w.Write(body)
+			}))
+			defer server.Close()
+
+			baseTime := testTime()
+			cache := local.NewBasicCacheWithTimeFunc(func() time.Time { return baseTime })
+			client := &http.Client{Transport: gocondcache.New(
+				&cache,
+				&gocondcache.Config{BodyCaching: tt.config},
+				func() time.Time { return baseTime },
+				slog.New(slog.NewTextHandler(io.Discard, nil)),
+			)(http.DefaultTransport)}
+
+			for _, body := range tt.bodies {
+				req, err := http.NewRequestWithContext(
+					context.Background(), tt.method, server.URL, strings.NewReader(body))
+				if err != nil {
+					t.Fatalf("failed to create request: %v", err)
+				}
+
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+
+				got, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Fatalf("failed to read response body: %v", err)
+				}
+				if string(got) != body {
+					t.Errorf("expected body %q, got %q", body, string(got))
+				}
+			}
+
+			if requestCount != tt.expectedRequests {
+				t.Errorf("expected %d requests to server, got %d", tt.expectedRequests, requestCount)
+			}
+		})
+	}
+}
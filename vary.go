@@ -0,0 +1,46 @@
+package gocondcache
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	headerVary = "Vary"
+
+	varyWildcard = "*"
+)
+
+// parseVary returns the header names listed in the Vary headers of h, canonicalized and
+// sorted so that equivalent Vary headers yield the same list.
+func parseVary(h http.Header) []string {
+	var names []string
+	for _, value := range h.Values(headerVary) {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == varyWildcard {
+				return []string{varyWildcard}
+			}
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// varyKey returns the key under which the variant of base selected by the values of the
+// vary headers in h is stored.
+func varyKey(base string, vary []string, h http.Header) string {
+	values := url.Values{}
+	for _, name := range vary {
+		values.Set(name, strings.Join(h.Values(name), ","))
+	}
+
+	return base + "#vary:" + values.Encode()
+}
@@ -0,0 +1,229 @@
+package gocondcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgduncan/go-cond-cache/caches"
+)
+
+// WarmConfig defines the configuration options for Warm.
+type WarmConfig struct {
+	// HostInterval is the minimum time between the start of two requests to the same
+	// host. Zero means requests are only bounded by the concurrency.
+	HostInterval time.Duration
+
+	// RefreshWithin makes fresh cache items expiring within this duration be revalidated.
+	// Fresh items expiring later are skipped. Zero means only missing and expired items
+	// are fetched.
+	RefreshWithin time.Duration
+}
+
+// WarmResult describes how Warm handled a URL.
+type WarmResult struct {
+	URL string
+
+	// Skipped is true if the URL was not fetched because its cache item was fresh.
+	Skipped bool
+
+	// Status is how the transport answered the request. It is empty when the client
+	// does not use a CacheTransport.
+	Status CacheStatus
+
+	StatusCode int
+	Duration   time.Duration
+	Err        error
+}
+
+// Warm fetches urls with GET requests sent through client, so that their responses are
+// cached by its transport, using up to concurrency requests at once. It returns a result
+// for every URL, in the order of urls. URLs not fetched because ctx is done have its
+// error as their result.
+//
+// When the transport of client is a CacheTransport, only URLs whose cache item is
+// missing, expired or expiring within WarmConfig.RefreshWithin are fetched. Items close
+// to expiry are revalidated with their validators. Items are checked with Peek, so that
+// on a cache that is not a Peeker the check records an access to every fresh item.
+//
+// If config is nil, the defaults are used.
+func Warm(ctx context.Context, client *http.Client, urls []string, concurrency int, config *WarmConfig) []WarmResult {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	c := WarmConfig{}
+	if config != nil {
+		c = *config
+	}
+
+	transport, _ := client.Transport.(*CacheTransport)
+	limiter := &hostLimiter{interval: c.HostInterval, next: make(map[string]time.Time)}
+	results := make([]WarmResult, len(urls))
+
+	indexes := make(chan int)
+	var workers sync.WaitGroup
+	for range min(max(concurrency, 1), len(urls)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range indexes {
+				results[i] = warmURL(ctx, client, transport, limiter, urls[i], c)
+			}
+		}()
+	}
+
+feed:
+	for i := range urls {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			for j := i; j < len(urls); j++ {
+				results[j] = WarmResult{URL: urls[j], Err: ctx.Err()}
+			}
+			break feed
+		}
+	}
+	close(indexes)
+	workers.Wait()
+
+	return results
+}
+
+func warmURL(
+	ctx context.Context,
+	client *http.Client,
+	transport *CacheTransport,
+	limiter *hostLimiter,
+	u string,
+	c WarmConfig,
+) WarmResult {
+	result := WarmResult{URL: u}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	if transport != nil {
+		item, getErr := transport.peek(ctx, caches.Key(*req))
+		if getErr == nil {
+			if item.Expiration.Sub(transport.now().UTC()) > c.RefreshWithin {
+				result.Skipped = true
+				return result
+			}
+			req = req.WithContext(withRevalidate(req.Context()))
+		}
+	}
+
+	if err = limiter.wait(ctx, req.URL.Host); err != nil {
+		result.Err = err
+		return result
+	}
+
+	start := time.Now()
+	req = req.WithContext(withStatus(req.Context(), &result.Status))
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	result.Duration = time.Since(start)
+	result.Err = err
+
+	return result
+}
+
+// hostLimiter spaces the requests made to each host by a minimum interval.
+type hostLimiter struct {
+	interval time.Duration
+
+	lock sync.Mutex
+	next map[string]time.Time
+}
+
+// wait blocks until a request to host may start, or ctx is done.
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	if l.interval <= 0 {
+		return nil
+	}
+
+	l.lock.Lock()
+	slot := time.Now()
+	if next := l.next[host]; next.After(slot) {
+		slot = next
+	}
+	l.next[host] = slot.Add(l.interval)
+	l.lock.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReadURLList reads a list of URLs, one per line. Blank lines and lines starting with #
+// are ignored.
+func ReadURLList(r io.Reader) ([]string, error) {
+	var urls []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+
+	return urls, scanner.Err()
+}
+
+// ParseSitemap reads a sitemap as defined by https://www.sitemaps.org/protocol.html.
+// It returns the page URLs of a urlset, or the sitemap URLs of a sitemapindex.
+func ParseSitemap(r io.Reader) (urls []string, sitemaps []string, err error) {
+	var doc struct {
+		URLs []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+	if err = xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, nil, err
+	}
+
+	for _, u := range doc.URLs {
+		if loc := strings.TrimSpace(u.Loc); loc != "" {
+			urls = append(urls, loc)
+		}
+	}
+	for _, s := range doc.Sitemaps {
+		if loc := strings.TrimSpace(s.Loc); loc != "" {
+			sitemaps = append(sitemaps, loc)
+		}
+	}
+
+	return urls, sitemaps, nil
+}
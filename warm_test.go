@@ -0,0 +1,194 @@
+package gocondcache_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+)
+
+func newWarmOrigin(count *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("warm"))
+	}))
+}
+
+func TestWarm(t *testing.T) {
+	t.Parallel()
+
+	var count atomic.Int32
+	origin := newWarmOrigin(&count)
+	defer origin.Close()
+
+	cache := local.NewBasicCache()
+	client := &http.Client{Transport: gocondcache.New(&cache, nil, nil, nil)(http.DefaultTransport)}
+	urls := []string{origin.URL + "/a", origin.URL + "/b", origin.URL + "/c"}
+
+	tests := []struct {
+		name             string
+		config           *gocondcache.WarmConfig
+		expectedSkipped  bool
+		expectedStatus   gocondcache.CacheStatus
+		expectedRequests int32
+	}{
+		{name: "cold cache is fetched", expectedStatus: gocondcache.CacheStatusMiss, expectedRequests: 3},
+		{name: "fresh entries are skipped", expectedSkipped: true, expectedRequests: 3},
+		{
+			name:             "entries near expiry are revalidated",
+			config:           &gocondcache.WarmConfig{RefreshWithin: 2 * time.Minute},
+			expectedStatus:   gocondcache.CacheStatusRevalidated,
+			expectedRequests: 6,
+		},
+	}
+
+	// the cases share the cache and run in order
+	for _, tt := range tests {
+		results := gocondcache.Warm(context.Background(), client, urls, 2, tt.config)
+
+		for i, r := range results {
+			if r.URL != urls[i] {
+				t.Errorf("%s: expected result %d for %s, got %s", tt.name, i, urls[i], r.URL)
+			}
+			if r.Err != nil {
+				t.Fatalf("%s: warming %s failed: %v", tt.name, r.URL, r.Err)
+			}
+			if r.Skipped != tt.expectedSkipped || r.Status != tt.expectedStatus {
+				t.Errorf("%s: unexpected result %+v", tt.name, r)
+			}
+		}
+		if count.Load() != tt.expectedRequests {
+			t.Errorf("%s: expected %d requests to origin, got %d", tt.name, tt.expectedRequests, count.Load())
+		}
+	}
+}
+
+func TestWarmLeavesEvictionOrder(t *testing.T) {
+	t.Parallel()
+
+	var count atomic.Int32
+	origin := newWarmOrigin(&count)
+	defer origin.Close()
+
+	cache := local.New(&local.Config{MaxEntries: 2}, nil)
+	client := &http.Client{Transport: gocondcache.New(cache, nil, nil, nil)(http.DefaultTransport)}
+	a, b, c := origin.URL+"/a", origin.URL+"/b", origin.URL+"/c"
+
+	gocondcache.Warm(context.Background(), client, []string{a, b}, 1, nil)
+	// skipping the fresh a does not count as a use, so storing c evicts it rather than b
+	if results := gocondcache.Warm(context.Background(), client, []string{a}, 1, nil); !results[0].Skipped {
+		t.Fatalf("expected a to be skipped, got %+v", results[0])
+	}
+	gocondcache.Warm(context.Background(), client, []string{c}, 1, nil)
+
+	results := gocondcache.Warm(context.Background(), client, []string{b, a}, 1, nil)
+	if !results[0].Skipped || results[1].Skipped {
+		t.Errorf("expected b to be kept and a to be evicted, got %+v", results)
+	}
+}
+
+func TestWarmHostInterval(t *testing.T) {
+	t.Parallel()
+
+	var count atomic.Int32
+	origin := newWarmOrigin(&count)
+	defer origin.Close()
+
+	urls := []string{origin.URL + "/a", origin.URL + "/b", origin.URL + "/c"}
+	config := &gocondcache.WarmConfig{HostInterval: 50 * time.Millisecond}
+
+	start := time.Now()
+	results := gocondcache.Warm(context.Background(), http.DefaultClient, urls, len(urls), config)
+	if elapsed := time.Since(start); elapsed < 2*config.HostInterval {
+		t.Errorf("expected requests to the same host to be spaced, took %v", elapsed)
+	}
+
+	for _, r := range results {
+		if r.Err != nil || r.StatusCode != http.StatusOK || r.Status != "" {
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+}
+
+func TestWarmCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := gocondcache.Warm(ctx, http.DefaultClient, []string{"http://example.invalid/"}, 1, nil)
+	if len(results) != 1 || !errors.Is(results[0].Err, context.Canceled) {
+		t.Errorf("expected canceled result, got %+v", results)
+	}
+}
+
+func TestReadURLList(t *testing.T) {
+	t.Parallel()
+
+	urls, err := gocondcache.ReadURLList(strings.NewReader("https://example.com/a\n\n# comment\n  https://example.com/b  \n"))
+	if err != nil {
+		t.Fatalf("failed to read list: %v", err)
+	}
+
+	expected := []string{"https://example.com/a", "https://example.com/b"}
+	if !slices.Equal(urls, expected) {
+		t.Errorf("expected %v, got %v", expected, urls)
+	}
+}
+
+func TestParseSitemap(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		sitemap          string
+		expectedURLs     []string
+		expectedSitemaps []string
+	}{
+		{
+			name: "urlset",
+			sitemap: `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/</loc><lastmod>2023-01-01</lastmod></url>
+  <url><loc> https://example.com/about </loc></url>
+</urlset>`,
+			expectedURLs: []string{"https://example.com/", "https://example.com/about"},
+		},
+		{
+			name: "sitemap index",
+			sitemap: `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/sitemap-1.xml</loc></sitemap>
+</sitemapindex>`,
+			expectedSitemaps: []string{"https://example.com/sitemap-1.xml"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			urls, sitemaps, err := gocondcache.ParseSitemap(strings.NewReader(tt.sitemap))
+			if err != nil {
+				t.Fatalf("failed to parse sitemap: %v", err)
+			}
+			if !slices.Equal(urls, tt.expectedURLs) || !slices.Equal(sitemaps, tt.expectedSitemaps) {
+				t.Errorf("expected %v and %v, got %v and %v", tt.expectedURLs, tt.expectedSitemaps, urls, sitemaps)
+			}
+		})
+	}
+}
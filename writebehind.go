@@ -0,0 +1,375 @@
+package gocondcache
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgduncan/go-cond-cache/caches"
+)
+
+const (
+	// DefaultWriteBehindQueueSize is the default number of writes that can be waiting to be applied.
+	DefaultWriteBehindQueueSize = 1024
+	// DefaultWriteBehindWorkers is the default number of workers applying writes.
+	DefaultWriteBehindWorkers = 4
+	// DefaultWriteBehindTimeout is the default timeout of a single write to the wrapped cache.
+	DefaultWriteBehindTimeout = 5 * time.Second
+)
+
+// WriteBehindConfig defines the configuration options for a WriteBehind cache.
+type WriteBehindConfig struct {
+	// QueueSize is the maximum number of writes waiting to be applied. Writes beyond
+	// this limit are dropped and counted in WriteBehindStats.Dropped.
+	QueueSize int
+
+	// Workers is the number of goroutines applying writes to the wrapped cache.
+	Workers int
+
+	// Timeout bounds each write to the wrapped cache.
+	Timeout time.Duration
+
+	// Now returns the current time, used to expire items soft purged in a wrapped cache
+	// that is not a SoftPurger. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// WriteBehindStats holds counters describing the writes handled by a WriteBehind cache.
+type WriteBehindStats struct {
+	Enqueued uint64 // writes accepted into the queue
+	Written  uint64 // writes applied to the wrapped cache
+	Failed   uint64 // writes the wrapped cache returned an error for
+	Dropped  uint64 // writes rejected because the queue was full
+}
+
+type writeKind int
+
+const (
+	writeSet writeKind = iota
+	writeUpdate
+	writeDelete
+	writeSoftPurge
+)
+
+type writeOp struct {
+	ctx        context.Context //nolint:containedctx // detached context carried to the worker
+	kind       writeKind
+	key        string
+	item       *CacheItem
+	expiration time.Time
+}
+
+// WriteBehind wraps a Cache so that Set and Update return immediately and are applied
+// to the wrapped cache by a pool of workers. Writes use a context detached from the
+// caller's cancellation, so a client disconnecting early does not abort them.
+//
+// Writes to the same key are always applied in order by the same worker. Reads go
+// straight to the wrapped cache and may not observe writes still waiting in the queue.
+type WriteBehind struct {
+	cache Cache
+
+	queues  []*writeQueue
+	timeout time.Duration
+	now     func() time.Time
+	logger  *slog.Logger
+	workers sync.WaitGroup
+
+	closeLock sync.RWMutex
+	closed    bool
+
+	// applied counts the writes each worker has applied, and progress is closed and
+	// replaced whenever one of them is, so that Flush can wait for its targets.
+	progressLock sync.Mutex
+	applied      []uint64
+	progress     chan struct{}
+
+	enqueued atomic.Uint64
+	written  atomic.Uint64
+	failed   atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// writeQueue holds the writes waiting for a worker.
+type writeQueue struct {
+	ops chan writeOp
+
+	// sent counts the writes sent to ops, in the order they were sent.
+	lock sync.Mutex
+	sent uint64
+}
+
+// Get retrieves an item from the wrapped cache.
+func (wb *WriteBehind) Get(ctx context.Context, k string) (*CacheItem, error) {
+	return wb.cache.Get(ctx, k)
+}
+
+// Peek looks up an item of the wrapped cache as Peek does.
+func (wb *WriteBehind) Peek(ctx context.Context, k string) (*CacheItem, error) {
+	return Peek(ctx, wb.cache, k)
+}
+
+// Set queues the item to be stored in the wrapped cache.
+// Returns caches.ErrWriteQueueFull if the write was dropped.
+func (wb *WriteBehind) Set(ctx context.Context, k string, v *CacheItem) error {
+	return wb.enqueue(writeOp{ctx: context.WithoutCancel(ctx), kind: writeSet, key: k, item: v})
+}
+
+// Update queues the expiration change to be applied to the wrapped cache.
+// Returns caches.ErrWriteQueueFull if the write was dropped.
+func (wb *WriteBehind) Update(ctx context.Context, k string, expiration time.Time) error {
+	return wb.enqueue(writeOp{ctx: context.WithoutCancel(ctx), kind: writeUpdate, key: k, expiration: expiration})
+}
+
+// Range enumerates the wrapped cache. Writes still waiting in the queue are not visible.
+// Returns caches.ErrNotSupported if the wrapped cache is not a Ranger.
+func (wb *WriteBehind) Range(ctx context.Context, f func(k string, v *CacheItem) bool) error {
+	ranger, ok := wb.cache.(Ranger)
+	if !ok {
+		return caches.ErrNotSupported
+	}
+
+	return ranger.Range(ctx, f)
+}
+
+// Delete queues the removal of an item from the wrapped cache, after any write to the
+// same key already queued. Returns caches.ErrNotSupported if the wrapped cache is not a
+// Deleter, or caches.ErrWriteQueueFull if the removal was dropped.
+func (wb *WriteBehind) Delete(ctx context.Context, k string) error {
+	if _, ok := wb.cache.(Deleter); !ok {
+		return caches.ErrNotSupported
+	}
+
+	return wb.enqueue(writeOp{ctx: context.WithoutCancel(ctx), kind: writeDelete, key: k})
+}
+
+// SoftPurge queues the expiration of an item of the wrapped cache, after any write to
+// the same key already queued. Returns caches.ErrWriteQueueFull if it was dropped.
+func (wb *WriteBehind) SoftPurge(ctx context.Context, k string) error {
+	return wb.enqueue(writeOp{ctx: context.WithoutCancel(ctx), kind: writeSoftPurge, key: k})
+}
+
+// PurgePrefix applies the writes already queued, then removes every item whose key
+// starts with prefix from the wrapped cache.
+func (wb *WriteBehind) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	if err := wb.Flush(ctx); err != nil {
+		return 0, err
+	}
+
+	return PurgePrefix(ctx, wb.cache, prefix)
+}
+
+// PurgeHost applies the writes already queued, then removes every item cached for host
+// from the wrapped cache.
+func (wb *WriteBehind) PurgeHost(ctx context.Context, host string) (int, error) {
+	if err := wb.Flush(ctx); err != nil {
+		return 0, err
+	}
+
+	return PurgeHost(ctx, wb.cache, host)
+}
+
+// PurgeTag applies the writes already queued, then removes every item stored with tag
+// from the wrapped cache.
+func (wb *WriteBehind) PurgeTag(ctx context.Context, tag string) (int, error) {
+	if err := wb.Flush(ctx); err != nil {
+		return 0, err
+	}
+
+	return PurgeTag(ctx, wb.cache, tag)
+}
+
+// Flush blocks until every write queued before it was called has been applied, or ctx
+// is done. Writes queued while it waits are not waited for.
+func (wb *WriteBehind) Flush(ctx context.Context) error {
+	targets := make([]uint64, len(wb.queues))
+	for i, q := range wb.queues {
+		q.lock.Lock()
+		targets[i] = q.sent
+		q.lock.Unlock()
+	}
+
+	for {
+		wb.progressLock.Lock()
+		done := true
+		for i, target := range targets {
+			if wb.applied[i] < target {
+				done = false
+				break
+			}
+		}
+		progress := wb.progress
+		wb.progressLock.Unlock()
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-progress:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close stops accepting writes, applies the writes still queued and stops the workers.
+// It blocks until the workers have stopped or ctx is done.
+func (wb *WriteBehind) Close(ctx context.Context) error {
+	wb.closeLock.Lock()
+	if !wb.closed {
+		wb.closed = true
+		for _, q := range wb.queues {
+			close(q.ops)
+		}
+	}
+	wb.closeLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wb.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of the write counters.
+func (wb *WriteBehind) Stats() WriteBehindStats {
+	return WriteBehindStats{
+		Enqueued: wb.enqueued.Load(),
+		Written:  wb.written.Load(),
+		Failed:   wb.failed.Load(),
+		Dropped:  wb.dropped.Load(),
+	}
+}
+
+func (wb *WriteBehind) enqueue(op writeOp) error {
+	wb.closeLock.RLock()
+	defer wb.closeLock.RUnlock()
+
+	if wb.closed {
+		return caches.ErrCacheClosed
+	}
+
+	q := wb.queues[wb.queueFor(op.key)]
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	select {
+	case q.ops <- op:
+		q.sent++
+		wb.enqueued.Add(1)
+		return nil
+	default:
+		wb.dropped.Add(1)
+		return caches.ErrWriteQueueFull
+	}
+}
+
+func (wb *WriteBehind) queueFor(k string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(k))
+
+	return int(h.Sum32() % uint32(len(wb.queues))) //nolint:gosec // number of queues always fits
+}
+
+func (wb *WriteBehind) work(i int) {
+	defer wb.workers.Done()
+
+	for op := range wb.queues[i].ops {
+		wb.apply(op)
+
+		wb.progressLock.Lock()
+		wb.applied[i]++
+		close(wb.progress)
+		wb.progress = make(chan struct{})
+		wb.progressLock.Unlock()
+	}
+}
+
+func (wb *WriteBehind) apply(op writeOp) {
+	ctx, cancel := context.WithTimeout(op.ctx, wb.timeout)
+	defer cancel()
+
+	var err error
+	switch op.kind {
+	case writeSet:
+		err = wb.cache.Set(ctx, op.key, op.item)
+	case writeUpdate:
+		err = wb.cache.Update(ctx, op.key, op.expiration)
+	case writeDelete:
+		if deleter, ok := wb.cache.(Deleter); ok {
+			err = deleter.Delete(ctx, op.key)
+		}
+	case writeSoftPurge:
+		err = SoftPurge(ctx, wb.cache, op.key, wb.now)
+	}
+
+	if err != nil {
+		wb.failed.Add(1)
+		wb.logger.WarnContext(ctx, "error applying write-behind write", "key", op.key, "error", err)
+		return
+	}
+	wb.written.Add(1)
+}
+
+// NewWriteBehind wraps cache with a bounded write-behind queue and starts its workers.
+// If config is nil or any of its values are zero, the defaults are used.
+// If the 'logger' is nil, a no-op logger writing to io.Discard will be used.
+//
+// Close must be called to stop the workers and apply any writes still queued.
+func NewWriteBehind(cache Cache, config *WriteBehindConfig, logger *slog.Logger) *WriteBehind {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	queueSize := DefaultWriteBehindQueueSize
+	workers := DefaultWriteBehindWorkers
+	timeout := DefaultWriteBehindTimeout
+	now := time.Now
+	if config != nil {
+		if config.QueueSize > 0 {
+			queueSize = config.QueueSize
+		}
+		if config.Workers > 0 {
+			workers = config.Workers
+		}
+		if config.Timeout > 0 {
+			timeout = config.Timeout
+		}
+		if config.Now != nil {
+			now = config.Now
+		}
+	}
+
+	// the queue is split between the workers so writes to a key stay in order
+	workers = min(workers, queueSize)
+	wb := &WriteBehind{
+		cache: cache,
+
+		queues:  make([]*writeQueue, workers),
+		timeout: timeout,
+		now:     now,
+		logger:  logger,
+
+		applied:  make([]uint64, workers),
+		progress: make(chan struct{}),
+	}
+
+	for i := range wb.queues {
+		wb.queues[i] = &writeQueue{ops: make(chan writeOp, queueSize/workers)}
+		wb.workers.Add(1)
+		go wb.work(i)
+	}
+
+	return wb
+}
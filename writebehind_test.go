@@ -0,0 +1,208 @@
+package gocondcache_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	gocondcache "github.com/dgduncan/go-cond-cache"
+	"github.com/dgduncan/go-cond-cache/caches"
+	"github.com/dgduncan/go-cond-cache/caches/local"
+	"github.com/dgduncan/go-cond-cache/gocondcachetest"
+)
+
+// blockingCache is a Cache whose writes block until release is closed.
+type blockingCache struct {
+	local.BasicCache
+
+	release chan struct{}
+}
+
+func (b *blockingCache) Set(ctx context.Context, k string, v *gocondcache.CacheItem) error {
+	<-b.release
+	return b.BasicCache.Set(ctx, k, v)
+}
+
+func TestWriteBehindAppliesWrites(t *testing.T) {
+	t.Parallel()
+
+	cache := local.NewBasicCacheWithTimeFunc(testTime)
+	wb := gocondcache.NewWriteBehind(&cache, nil, nil)
+
+	// writes are applied even when the caller's context is already canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expiration := testTime().Add(time.Hour)
+	if err := wb.Set(ctx, "key", &gocondcache.CacheItem{ETAG: `"abc"`, Expiration: testTime()}); err != nil {
+		t.Fatalf("unexpected error queueing set: %v", err)
+	}
+	if err := wb.Update(ctx, "key", expiration); err != nil {
+		t.Fatalf("unexpected error queueing update: %v", err)
+	}
+
+	if err := wb.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error flushing: %v", err)
+	}
+
+	item, err := wb.Get(context.Background(), "key")
+	if err != nil {
+		t.Fatalf("expected cache hit but got error: %v", err)
+	}
+	if !item.Expiration.Equal(expiration) {
+		t.Errorf("expected expiration %s, got %s", expiration, item.Expiration)
+	}
+
+	if err := wb.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+	if err := wb.Set(context.Background(), "key", &gocondcache.CacheItem{}); !errors.Is(err, caches.ErrCacheClosed) {
+		t.Errorf("expected closed error, got %v", err)
+	}
+
+	if stats := wb.Stats(); stats.Enqueued != 2 || stats.Written != 2 {
+		t.Errorf("expected 2 writes enqueued and written, got %+v", stats)
+	}
+}
+
+func TestWriteBehindDropsOnOverflow(t *testing.T) {
+	t.Parallel()
+
+	backend := &blockingCache{
+		BasicCache: local.NewBasicCacheWithTimeFunc(testTime),
+		release:    make(chan struct{}),
+	}
+	wb := gocondcache.NewWriteBehind(backend, &gocondcache.WriteBehindConfig{QueueSize: 1, Workers: 1}, nil)
+
+	ctx := context.Background()
+
+	// the first write is picked up by the worker and blocks it, the second fills the queue
+	if err := wb.Set(ctx, "first", &gocondcache.CacheItem{}); err != nil {
+		t.Fatalf("unexpected error queueing set: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := wb.Set(ctx, "second", &gocondcache.CacheItem{})
+		if errors.Is(err, caches.ErrWriteQueueFull) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected queue to overflow")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	flushCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := wb.Flush(flushCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected flush to time out while writes are blocked, got %v", err)
+	}
+
+	close(backend.release)
+	if err := wb.Close(ctx); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+
+	if stats := wb.Stats(); stats.Dropped == 0 {
+		t.Errorf("expected dropped writes, got %+v", stats)
+	}
+	if _, err := backend.Get(ctx, "first"); errors.Is(err, caches.ErrNoCacheItem) {
+		t.Errorf("expected queued write to be applied on close, got %v", err)
+	}
+}
+
+// slowCache is a Cache whose writes take a while, as a remote backend's would.
+type slowCache struct {
+	gocondcache.Cache
+}
+
+func (s slowCache) Set(ctx context.Context, k string, v *gocondcache.CacheItem) error {
+	time.Sleep(100 * time.Microsecond)
+	return s.Cache.Set(ctx, k, v)
+}
+
+func TestWriteBehindFlushUnderLoad(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := local.New(nil, testTime)
+	wb := gocondcache.NewWriteBehind(slowCache{backend}, &gocondcache.WriteBehindConfig{QueueSize: 64, Workers: 2}, nil)
+	defer wb.Close(ctx)
+
+	// writes keep arriving while Flush waits
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = wb.Set(ctx, fmt.Sprintf("load-%d", i%100), &gocondcache.CacheItem{})
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	for errors.Is(wb.Set(ctx, "before", &gocondcache.CacheItem{}), caches.ErrWriteQueueFull) {
+		time.Sleep(time.Millisecond)
+	}
+
+	flushCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := wb.Flush(flushCtx); err != nil {
+		t.Fatalf("expected Flush to return while writes keep arriving, got %v", err)
+	}
+	if _, err := backend.Get(ctx, "before"); errors.Is(err, caches.ErrNoCacheItem) {
+		t.Error("expected the write queued before Flush to be applied")
+	}
+}
+
+func TestWriteBehindSoftPurge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		wrap func(cache gocondcache.Cache) gocondcache.Cache
+	}{
+		{name: "soft purger", wrap: func(cache gocondcache.Cache) gocondcache.Cache { return cache }},
+		// caches that are not a SoftPurger are expired with Update
+		{name: "update", wrap: func(cache gocondcache.Cache) gocondcache.Cache { return struct{ gocondcache.Cache }{cache} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			clock := gocondcachetest.NewClock(testTime())
+			backend := local.New(nil, clock.Now)
+			_ = backend.Set(ctx, "key", &gocondcache.CacheItem{Expiration: testTime().Add(time.Hour)})
+
+			wb := gocondcache.NewWriteBehind(tt.wrap(backend), &gocondcache.WriteBehindConfig{Now: clock.Now}, nil)
+			defer wb.Close(ctx)
+
+			if err := wb.SoftPurge(ctx, "key"); err != nil {
+				t.Fatalf("unexpected error queueing soft purge: %v", err)
+			}
+			if err := wb.Flush(ctx); err != nil {
+				t.Fatalf("unexpected error flushing: %v", err)
+			}
+
+			clock.Advance(time.Second)
+			if _, err := backend.Get(ctx, "key"); !errors.Is(err, caches.ErrCacheItemExpired) {
+				t.Errorf("expected the item to be expired after Flush, got %v", err)
+			}
+			if stats := wb.Stats(); stats.Written != 1 || stats.Failed != 0 {
+				t.Errorf("expected the soft purge to be written, got %+v", stats)
+			}
+		})
+	}
+}